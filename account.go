@@ -0,0 +1,407 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/mail"
+	"time"
+)
+
+var (
+	// ErrUsernameTaken is returned when signing up with a username that is already registered
+	ErrUsernameTaken = errors.New("username already exists")
+	// ErrInvalidCredentials is returned for an unknown username or a wrong password
+	ErrInvalidCredentials = errors.New("invalid username or password")
+)
+
+// ValidationError reports input that was rejected before reaching the database
+type ValidationError struct {
+	Field string
+	Err   error
+}
+
+func (e *ValidationError) Error() string {
+	return e.Field + ": " + e.Err.Error()
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// Function to check a username against the "user" table's limits
+func validateUsername(username string) error {
+	if username == "" {
+		return &ValidationError{Field: "username", Err: errors.New("username is required")}
+	}
+	if len(username) > 50 {
+		return &ValidationError{Field: "username", Err: errors.New("username must be at most 50 characters long")}
+	}
+	return nil
+}
+
+// Function to check an optional email address, returning it in plain
+// user@example.com form
+func validateEmail(email string) (string, error) {
+	if email == "" {
+		return "", nil
+	}
+	address, err := mail.ParseAddress(email)
+	if err != nil {
+		return "", &ValidationError{Field: "email", Err: errors.New("email must be an address such as user@example.com")}
+	}
+	if len(address.Address) > 254 {
+		return "", &ValidationError{Field: "email", Err: errors.New("email must be at most 254 characters long")}
+	}
+	return address.Address, nil
+}
+
+// Function to check whether a username is registered. It reads from the
+// primary so that an account created a moment ago is never missed.
+func usernameExists(router *dbRouter, username string) (bool, error) {
+	var existingUserID int
+	queryCheckUsername := `SELECT user_id FROM "user" WHERE username = $1`
+	err := router.Writer(anonymousSession).QueryRow(queryCheckUsername, username).Scan(&existingUserID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Function to validate and store a new account, returning its user ID. The
+// answers must cover the required number of security questions.
+func createUser(router *dbRouter, username, password, email string, answers []SecurityAnswer, requiredAnswers int) (int, error) {
+	if err := validateUsername(username); err != nil {
+		return 0, err
+	}
+	if err := ValidPassword(password); err != nil {
+		return 0, &ValidationError{Field: "password", Err: err}
+	}
+	email, err := validateEmail(email)
+	if err != nil {
+		return 0, err
+	}
+	if err := validateAnswers(router.Writer(anonymousSession), answers, requiredAnswers); err != nil {
+		return 0, err
+	}
+
+	exists, err := usernameExists(router, username)
+	if err != nil {
+		return 0, fmt.Errorf("checking for existing username: %w", err)
+	}
+	if exists {
+		return 0, ErrUsernameTaken
+	}
+
+	// Hash the password
+	hashedPassword, err := hashPassword(password)
+	if err != nil {
+		return 0, fmt.Errorf("hashing password: %w", err)
+	}
+
+	// Insert into the database, together with the user's own workspace
+	tx, err := router.Writer(anonymousSession).Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var userID int
+	query := `
+		INSERT INTO "user" (username, password, email)
+		VALUES ($1, $2, $3)
+		RETURNING user_id`
+	err = tx.QueryRow(query, username, hashedPassword, sql.NullString{String: email, Valid: email != ""}).Scan(&userID)
+	if err == nil {
+		if err = saveAnswers(tx, userID, answers); err == nil {
+			if _, err = createWorkspace(tx, userID, username); err == nil {
+				err = tx.Commit()
+			}
+		}
+	}
+	if err != nil {
+		tx.Rollback()
+		// Lost a race with another sign-up for the same name
+		if exists, _ := usernameExists(router, username); exists {
+			return 0, ErrUsernameTaken
+		}
+		return 0, err
+	}
+	return userID, nil
+}
+
+// Function to check a username and password, returning the user ID
+func authenticate(router *dbRouter, username, password string) (int, error) {
+	query := `SELECT user_id, password FROM "user" WHERE username = $1`
+	row := router.Reader(anonymousSession).QueryRow(query, username)
+
+	var userID int
+	var hashedPassword string
+	err := row.Scan(&userID, &hashedPassword)
+	if err == sql.ErrNoRows {
+		return 0, ErrInvalidCredentials
+	} else if err != nil {
+		return 0, err
+	}
+	if !checkPasswordHash(password, hashedPassword) {
+		return 0, ErrInvalidCredentials
+	}
+	return userID, nil
+}
+
+// Account is what a user can see and change about their own account
+type Account struct {
+	UserID   int    `json:"user_id"`
+	Username string `json:"username"`
+	Email    string `json:"email,omitempty"` // where password reset codes are sent
+}
+
+// Function to load the logged-in user's account
+func loadAccount(router *dbRouter, userID int) (Account, error) {
+	account := Account{UserID: userID}
+	var email sql.NullString
+	query := `SELECT username, email FROM "user" WHERE user_id = $1`
+	err := router.Reader(userID).QueryRow(query, userID).Scan(&account.Username, &email)
+	account.Email = email.String
+	return account, err
+}
+
+// Function to change or, given an empty address, remove the user's email address
+func setEmail(router *dbRouter, userID int, email string) error {
+	email, err := validateEmail(email)
+	if err != nil {
+		return err
+	}
+	query := `UPDATE "user" SET email = $1 WHERE user_id = $2`
+	_, err = router.Writer(userID).Exec(query, sql.NullString{String: email, Valid: email != ""}, userID)
+	return err
+}
+
+// Function to check the logged-in user's current password before a sensitive change
+func checkCurrentPassword(db queryer, userID int, password string) error {
+	var hashedPassword string
+	if err := db.QueryRow(`SELECT password FROM "user" WHERE user_id = $1`, userID).Scan(&hashedPassword); err != nil {
+		return err
+	}
+	if !checkPasswordHash(password, hashedPassword) {
+		return ErrInvalidCredentials
+	}
+	return nil
+}
+
+// Function to change the user's password after checking the current one.
+// Every session but the one making the change is logged out.
+func changePassword(router *dbRouter, userID int, keepToken, currentPassword, newPassword string) error {
+	db := router.Writer(userID)
+	if err := checkCurrentPassword(db, userID, currentPassword); err != nil {
+		return err
+	}
+	if err := ValidPassword(newPassword); err != nil {
+		return &ValidationError{Field: "new_password", Err: err}
+	}
+	hashedPassword, err := hashPassword(newPassword)
+	if err != nil {
+		return fmt.Errorf("hashing password: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`UPDATE "user" SET password = $1 WHERE user_id = $2`, hashedPassword, userID); err != nil {
+		return err
+	}
+	query := `UPDATE sessions SET revoked_at = $1 WHERE user_id = $2 AND token_hash <> $3 AND revoked_at IS NULL`
+	if _, err := tx.Exec(query, time.Now().UTC(), userID, hashToken(keepToken)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Function to rename the user, keeping usernames unique
+func changeUsername(router *dbRouter, userID int, username string) error {
+	if err := validateUsername(username); err != nil {
+		return err
+	}
+	db := router.Writer(userID)
+	var oldUsername string
+	if err := db.QueryRow(`SELECT username FROM "user" WHERE user_id = $1`, userID).Scan(&oldUsername); err != nil {
+		return err
+	}
+	if username == oldUsername {
+		return nil
+	}
+	exists, err := usernameExists(router, username)
+	if err != nil {
+		return fmt.Errorf("checking for existing username: %w", err)
+	}
+	if exists {
+		return ErrUsernameTaken
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`UPDATE "user" SET username = $1 WHERE user_id = $2`, username, userID)
+	if err == nil {
+		// Failed logins are counted by username; they follow the account
+		query := `UPDATE login_throttle SET subject = $1 WHERE kind = $2 AND subject = $3`
+		if _, err = tx.Exec(query, username, throttleUser, oldUsername); err == nil {
+			err = tx.Commit()
+		}
+	}
+	if err != nil {
+		tx.Rollback()
+		// Lost a race with a sign-up or rename to the same name
+		if exists, _ := usernameExists(router, username); exists {
+			return ErrUsernameTaken
+		}
+		return err
+	}
+	return nil
+}
+
+// DeleteAccount deletes the user's account for good, after checking their
+// password. Workspaces nobody else belongs to go with it; the user must hand
+// over any other workspace they are the only owner of first. Everything else
+// they created goes with the account too, as the user_id foreign keys cascade:
+// their tasks with the subtasks under them, their projects and their sessions.
+func (s *sqlTaskStore) DeleteAccount(userID int, password string) error {
+	db := s.router.Writer(userID)
+	if err := checkCurrentPassword(db, userID, password); err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+	SELECT w.workspace_id, w.name,
+		(SELECT COUNT(*) FROM workspace_member o WHERE o.workspace_id = w.workspace_id AND o.user_id <> $1),
+		(SELECT COUNT(*) FROM workspace_member o WHERE o.workspace_id = w.workspace_id AND o.user_id <> $1 AND o.role = $2)
+	FROM workspace w JOIN workspace_member m ON m.workspace_id = w.workspace_id
+	WHERE m.user_id = $1 AND m.role = $2`
+	rows, err := tx.Query(query, userID, RoleOwner)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var emptyWorkspaces []int
+	for rows.Next() {
+		var workspaceID, others, otherOwners int
+		var name string
+		if err := rows.Scan(&workspaceID, &name, &others, &otherOwners); err != nil {
+			return err
+		}
+		if others == 0 {
+			emptyWorkspaces = append(emptyWorkspaces, workspaceID)
+		} else if otherOwners == 0 {
+			return fmt.Errorf("workspace %q: %w", name, ErrLastOwner)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close() // the memory backend has a single connection
+
+	for _, workspaceID := range emptyWorkspaces {
+		if _, err := tx.Exec(`DELETE FROM workspace WHERE workspace_id = $1`, workspaceID); err != nil {
+			return err
+		}
+	}
+	workspaceIDs, err := queryIDs(tx, `SELECT workspace_id FROM workspace_member WHERE user_id = $1 ORDER BY workspace_id`, userID)
+	if err != nil {
+		return err
+	}
+	for _, workspaceID := range workspaceIDs {
+		if err := s.purgeCreatedBy(tx, Actor{UserID: userID, WorkspaceID: workspaceID}); err != nil {
+			return err
+		}
+	}
+
+	var username string
+	if err := tx.QueryRow(`SELECT username FROM "user" WHERE user_id = $1`, userID).Scan(&username); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM login_throttle WHERE kind = $1 AND subject = $2`, throttleUser, username); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM "user" WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Helper function to delete, ahead of the cascade, the tasks and projects a
+// user whose account is being deleted created in a workspace others share, so
+// that their history can tell. The deleted tasks, and the subtasks under them,
+// get a purge event; the other members' tasks that lose a project, a blocker,
+// or the user as assignee or watcher get those changes recorded.
+func (s *sqlTaskStore) purgeCreatedBy(tx queryer, actor Actor) error {
+	query := `
+	WITH RECURSIVE purged (task_id) AS (
+		SELECT task_id FROM "task" WHERE workspace_id = $1 AND user_id = $2
+		UNION
+		SELECT t.task_id FROM "task" t JOIN purged p ON t.parent_id = p.task_id
+	)
+	SELECT task_id FROM purged`
+	purged, err := queryIDs(tx, query, actor.WorkspaceID, actor.UserID)
+	if err != nil {
+		return err
+	}
+
+	before, err := s.snapshotInvolving(tx, actor.WorkspaceID, actor.UserID)
+	if err != nil {
+		return err
+	}
+	args := []interface{}{actor.WorkspaceID, actor.UserID}
+	query = `
+	SELECT t.task_id FROM "task" t JOIN project p ON p.project_id = t.project_id
+	WHERE t.workspace_id = $1 AND p.user_id = $2`
+	if len(purged) > 0 {
+		query += ` UNION SELECT task_id FROM task_dependency WHERE blocker_id IN (` + idList(&args, purged) + `)`
+	}
+	touched, err := queryIDs(tx, query, args...)
+	if err != nil {
+		return err
+	}
+	losing, err := s.snapshot(tx, actor.WorkspaceID, touched)
+	if err != nil {
+		return err
+	}
+	for taskID, task := range losing {
+		before[taskID] = task
+	}
+	for _, taskID := range purged {
+		delete(before, taskID)
+	}
+
+	if err := unassignMember(tx, actor, actor.UserID); err != nil {
+		return err
+	}
+	if len(purged) > 0 {
+		// SQLite numbers $n placeholders in the order they first appear, so keep them in order
+		args = []interface{}{actor.UserID, EventPurge, actor.WorkspaceID}
+		query = `
+		INSERT INTO task_event (workspace_id, task_id, user_id, action)
+		SELECT workspace_id, task_id, $1, $2 FROM "task" WHERE workspace_id = $3 AND task_id IN (` + idList(&args, purged) + `)`
+		if _, err := tx.Exec(query, args...); err != nil {
+			return err
+		}
+		args = []interface{}{actor.WorkspaceID}
+		query = `DELETE FROM "task" WHERE workspace_id = $1 AND task_id IN (` + idList(&args, purged) + `)`
+		if _, err := tx.Exec(query, args...); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM project WHERE workspace_id = $1 AND user_id = $2`, actor.WorkspaceID, actor.UserID); err != nil {
+		return err
+	}
+	return s.recordChanges(tx, actor, before)
+}
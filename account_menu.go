@@ -0,0 +1,197 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// Account menu. It returns true once the account is deleted and the user is logged out.
+func accountMenu(store TaskStore, sessions *sessionStore, limiter *loginLimiter, resets *resetStore, token string, userID int) bool {
+	router := sessions.router
+	for {
+		account, err := loadAccount(router, userID)
+		if err != nil {
+			log.Println("Error loading account:", err)
+			return false
+		}
+		printAccount(account)
+
+		fmt.Println("\nAccount Menu:")
+		fmt.Println("---------------------------------")
+		fmt.Println("1 - Change Email Address")
+		fmt.Println("2 - Change Password")
+		fmt.Println("3 - Change Username")
+		fmt.Println("4 - Two-Factor Authentication")
+		fmt.Println("5 - Change Security Questions")
+		fmt.Println("6 - Delete Account")
+		fmt.Println("7 - Back")
+
+		fmt.Print("Enter your choice: ")
+		choiceInput, _ := stdin.ReadString('\n')
+		choice, err := strconv.Atoi(sanitizeInput(choiceInput))
+		if err != nil {
+			log.Println("Invalid input. Please enter a number.")
+			continue
+		}
+
+		switch choice {
+		case 1:
+			fmt.Print("Enter your email address (leave empty to remove it): ")
+			email, _ := stdin.ReadString('\n')
+			err = setEmail(router, userID, sanitizeInput(email))
+		case 2:
+			fmt.Print("Enter your current password: ")
+			currentPassword, _ := stdin.ReadString('\n')
+			fmt.Print("Enter a new password: ")
+			newPassword, _ := stdin.ReadString('\n')
+			err = limiter.AttemptAs(userID, terminalSource, func() error {
+				return changePassword(router, userID, token, sanitizeInput(currentPassword), sanitizeInput(newPassword))
+			})
+			if err == nil {
+				fmt.Println("Your other sessions have been logged out.")
+			}
+		case 3:
+			fmt.Print("Enter a new username: ")
+			username, _ := stdin.ReadString('\n')
+			err = changeUsername(router, userID, sanitizeInput(username))
+		case 4:
+			twoFactorMenu(store, router, limiter, userID)
+			continue
+		case 5:
+			if resets.questions == 0 {
+				fmt.Println("Error:", ErrQuestionsOff)
+				continue
+			}
+			fmt.Print("Enter your current password: ")
+			password, _ := stdin.ReadString('\n')
+			answers, ok := chooseSecurityQuestions(router.Reader(userID), resets.questions)
+			if !ok {
+				continue
+			}
+			err = limiter.AttemptAs(userID, terminalSource, func() error {
+				return resets.SetAnswers(userID, sanitizeInput(password), answers)
+			})
+		case 6:
+			if deleteAccountPrompt(store, limiter, userID) {
+				fmt.Println("Your account has been deleted. Goodbye!")
+				return true
+			}
+			continue
+		case 7:
+			return false
+		default:
+			fmt.Println("Invalid choice. Please try again.")
+			continue
+		}
+
+		var validationErr *ValidationError
+		if errors.As(err, &validationErr) {
+			fmt.Println("Error:", validationErr.Err)
+			continue
+		} else if err == ErrInvalidCredentials {
+			fmt.Println("Error: wrong password")
+			continue
+		} else if errors.Is(err, ErrTooManyAttempts) {
+			fmt.Println("Error:", err)
+			continue
+		} else if err == ErrUsernameTaken {
+			fmt.Println("Username already exists. Please choose another username.")
+			continue
+		} else if err != nil {
+			log.Println("Error saving account:", err)
+			continue
+		}
+		fmt.Println("Account updated successfully!")
+	}
+}
+
+// Helper function to confirm and delete the user's account, returning true if it was deleted
+func deleteAccountPrompt(store TaskStore, limiter *loginLimiter, userID int) bool {
+	fmt.Println("Deleting your account is permanent. It deletes the workspaces nobody else belongs")
+	fmt.Println("to, and the tasks and projects you created in the others.")
+	fmt.Print("Are you sure? (Y/N): ")
+	confirm, _ := stdin.ReadString('\n')
+	if strings.ToUpper(sanitizeInput(confirm)) != "Y" {
+		fmt.Println("Account deletion cancelled.")
+		return false
+	}
+	fmt.Print("Enter your password to confirm: ")
+	password, _ := stdin.ReadString('\n')
+	err := limiter.AttemptAs(userID, terminalSource, func() error {
+		return store.DeleteAccount(userID, sanitizeInput(password))
+	})
+	if err == ErrInvalidCredentials {
+		fmt.Println("Error: wrong password")
+		return false
+	} else if errors.Is(err, ErrLastOwner) || errors.Is(err, ErrTooManyAttempts) {
+		fmt.Println("Error:", err)
+		return false
+	} else if err != nil {
+		log.Println("Error deleting account:", err)
+		return false
+	}
+	return true
+}
+
+// Helper function to have the user pick the required number of security
+// questions from the catalog and answer them. It returns false if the input
+// ran out or the catalog couldn't be loaded.
+func chooseSecurityQuestions(db queryer, required int) ([]SecurityAnswer, bool) {
+	catalog, err := questionCatalog(db)
+	if err != nil {
+		log.Println("Error loading security questions:", err)
+		return nil, false
+	}
+	fmt.Printf("Pick %d security questions; you will answer them to reset your password:\n", required)
+	for _, question := range catalog {
+		fmt.Printf("%d - %s\n", question.ID, question.Text)
+	}
+
+	var answers []SecurityAnswer
+	for len(answers) < required {
+		fmt.Printf("Question %d of %d: ", len(answers)+1, required)
+		input, err := stdin.ReadString('\n')
+		if err != nil {
+			log.Println("Error reading input:", err)
+			return nil, false
+		}
+		questionID, err := strconv.Atoi(sanitizeInput(input))
+		if err != nil {
+			fmt.Println("Invalid input. Please enter a number.")
+			continue
+		}
+		fmt.Print("Answer: ")
+		answer, err := stdin.ReadString('\n')
+		if err != nil {
+			log.Println("Error reading input:", err)
+			return nil, false
+		}
+
+		// Check each pick against the ones before it as it is made
+		picked := append(answers[:len(answers):len(answers)], SecurityAnswer{QuestionID: questionID, Answer: answer})
+		var validationErr *ValidationError
+		if err := validateAnswers(db, picked, len(picked)); errors.As(err, &validationErr) {
+			fmt.Println("Error:", validationErr.Err)
+			continue
+		} else if err != nil {
+			log.Println("Error checking security questions:", err)
+			return nil, false
+		}
+		answers = picked
+	}
+	return answers, true
+}
+
+// Helper function to print the user's account
+func printAccount(account Account) {
+	email := account.Email
+	if email == "" {
+		email = "(none)"
+	}
+	fmt.Println("---------------------------------")
+	fmt.Println("USERNAME:", account.Username)
+	fmt.Println("EMAIL:", email)
+}
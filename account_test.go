@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChangePassword(t *testing.T) {
+	router := newTestRouter(t)
+	sessions := newSessionStore(router, time.Hour)
+	userID, _ := newTestUser(t, router, "alice")
+	current, err := sessions.Create(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := sessions.Create(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := changePassword(router, userID, current.Token, "Wr0ngpass!", "N3wPassw0rd!"); err != ErrInvalidCredentials {
+		t.Errorf("changing with the wrong current password = %v; want %v", err, ErrInvalidCredentials)
+	}
+	var validationErr *ValidationError
+	if err := changePassword(router, userID, current.Token, "Passw0rd!", "short"); !errors.As(err, &validationErr) || validationErr.Field != "new_password" {
+		t.Errorf("changing to a weak password = %v; want a validation error for new_password", err)
+	}
+	if err := changePassword(router, userID, current.Token, "Passw0rd!", "N3wPassw0rd!"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := authenticate(router, "alice", "N3wPassw0rd!"); err != nil {
+		t.Errorf("logging in with the new password: %v", err)
+	}
+	if _, ok := isValidToken(sessions, current.Token); !ok {
+		t.Error("the session that changed the password was logged out")
+	}
+	if _, ok := isValidToken(sessions, other.Token); ok {
+		t.Error("another session outlived the password change")
+	}
+}
+
+func TestChangeUsername(t *testing.T) {
+	router := newTestRouter(t)
+	limiter := newLoginLimiter(router, testLockout, nil)
+	userID, _ := newTestUser(t, router, "alice")
+	newTestUser(t, router, "bob")
+
+	if err := changeUsername(router, userID, "bob"); err != ErrUsernameTaken {
+		t.Errorf("renaming to a taken username = %v; want %v", err, ErrUsernameTaken)
+	}
+	for i := 0; i < testLockout.MaxFailures; i++ {
+		if err := limiter.Fail("alice", "10.0.0.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := changeUsername(router, userID, "alicia"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := authenticate(router, "alicia", "Passw0rd!"); err != nil {
+		t.Errorf("logging in with the new username: %v", err)
+	}
+	if lockedFor(t, limiter, "alicia", "10.0.0.2") == 0 {
+		t.Error("renaming the account lifted its lockout")
+	}
+}
+
+func TestDeleteAccount(t *testing.T) {
+	router := newTestRouter(t)
+	store := newSQLTaskStore(router, time.Hour)
+	sessions := newSessionStore(router, time.Hour)
+	carolID, workspaceID := newTestUser(t, router, "carol")
+	daveID, daveWorkspaceID := newTestUser(t, router, "dave")
+	carol := Actor{UserID: carolID, WorkspaceID: workspaceID}
+	dave := Actor{UserID: daveID, WorkspaceID: workspaceID}
+	if err := store.AddMember(carol, "dave", RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	authToken, err := sessions.Create(daveID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Dave's task with carol's subtask, his project, and carol's task tied to all three
+	if _, err := store.CreateProject(dave, "Launch"); err != nil {
+		t.Fatal(err)
+	}
+	daves, err := store.Create(dave, Task{Title: "Dave's", Project: "Launch"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	subtask, err := store.Create(carol, Task{Title: "Carol's subtask", ParentID: &daves.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	carols, err := store.Create(carol, Task{Title: "Carol's", BlockedBy: []int{daves.ID}, Project: "Launch", Assignee: "dave"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.DeleteAccount(daveID, "Wr0ngpass!"); err != ErrInvalidCredentials {
+		t.Errorf("deleting with the wrong password = %v; want %v", err, ErrInvalidCredentials)
+	}
+	if err := store.DeleteAccount(carolID, "Passw0rd!"); !errors.Is(err, ErrLastOwner) {
+		t.Errorf("deleting the last owner of a shared workspace = %v; want %v", err, ErrLastOwner)
+	}
+	if err := store.DeleteAccount(daveID, "Passw0rd!"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := authenticate(router, "dave", "Passw0rd!"); err != ErrInvalidCredentials {
+		t.Errorf("logging in as the deleted user = %v; want %v", err, ErrInvalidCredentials)
+	}
+	if _, ok := isValidToken(sessions, authToken.Token); ok {
+		t.Error("a session outlived its account")
+	}
+	if err := router.Primary().QueryRow(`SELECT workspace_id FROM workspace WHERE workspace_id = $1`, daveWorkspaceID).Scan(new(int)); err == nil {
+		t.Error("the deleted user's own workspace is still there")
+	}
+
+	tasks, err := store.List(carol, TaskFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := taskTitles(tasks); !reflect.DeepEqual(got, []string{"Carol's"}) {
+		t.Errorf("tasks left = %q; want only [Carol's]", got)
+	}
+	got := getTask(t, store, carol, carols.ID)
+	if len(got.BlockedBy) != 0 || got.ProjectID != nil || got.AssigneeID != nil {
+		t.Errorf("carol's task still has blockers %v, project %q and assignee %q", got.BlockedBy, got.Project, got.Assignee)
+	}
+	if projects, err := store.Projects(carol); err != nil || len(projects) != 0 {
+		t.Errorf("projects left = %+v, %v; want none", projects, err)
+	}
+
+	// The history tells what happened, with the deleted user's changes kept
+	want := []string{"create by carol", "update by "}
+	if got := historyActions(t, store, carol, carols.ID); !reflect.DeepEqual(got, want) {
+		t.Errorf("history of carol's task = %q; want %q", got, want)
+	}
+	events, err := store.History(carol, daves.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	printHistory(&out, events)
+	if !strings.Contains(out.String(), "(deleted user) created task") || !strings.Contains(out.String(), "purged for good") {
+		t.Errorf("history of the deleted user's task:\n%s\nwant its creation by a deleted user and its purge", out.String())
+	}
+	if got := historyActions(t, store, carol, subtask.ID); !reflect.DeepEqual(got, []string{"create by carol", "purge by "}) {
+		t.Errorf("history of the subtask = %q; want its purge recorded", got)
+	}
+}
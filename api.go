@@ -0,0 +1,1297 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed openapi.yaml
+var openAPISpec []byte
+
+// apiServer exposes accounts and tasks as a JSON REST API. It shares the
+// stores used by the interactive menus, so both see the same data.
+type apiServer struct {
+	router   *dbRouter
+	tasks    TaskStore
+	sessions *sessionStore
+	limiter  *loginLimiter
+	resets   *resetStore
+}
+
+type contextKey int
+
+const actorKey contextKey = iota
+
+// Requests work in the session's workspace unless this header names another
+const workspaceHeader = "X-Workspace-ID"
+
+// Function to build the HTTP handler with every API route
+func (s *apiServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /openapi.yaml", s.handleOpenAPI)
+	mux.HandleFunc("POST /signup", s.handleSignUp)
+	mux.HandleFunc("POST /login", s.handleLogIn)
+	mux.HandleFunc("POST /logout", s.requireSession(s.handleLogOut))
+	mux.HandleFunc("POST /password-reset", s.handleRequestPasswordReset)
+	mux.HandleFunc("POST /password-reset/questions", s.handleResetQuestions)
+	mux.HandleFunc("POST /password-reset/confirm", s.handleResetPassword)
+	mux.HandleFunc("GET /security-questions", s.handleListSecurityQuestions)
+	mux.HandleFunc("GET /account", s.requireSession(s.handleGetAccount))
+	mux.HandleFunc("PATCH /account", s.requireSession(s.handleUpdateAccount))
+	mux.HandleFunc("DELETE /account", s.requireSession(s.handleDeleteAccount))
+	mux.HandleFunc("POST /account/password", s.requireSession(s.handleChangePassword))
+	mux.HandleFunc("GET /account/security-questions", s.requireSession(s.handleGetSecurityQuestions))
+	mux.HandleFunc("PUT /account/security-questions", s.requireSession(s.handleSetSecurityQuestions))
+	mux.HandleFunc("GET /account/2fa", s.requireSession(s.handleTwoFactorStatus))
+	mux.HandleFunc("POST /account/2fa", s.requireSession(s.handleStartTwoFactor))
+	mux.HandleFunc("POST /account/2fa/confirm", s.requireSession(s.handleConfirmTwoFactor))
+	mux.HandleFunc("POST /account/2fa/disable", s.requireSession(s.handleDisableTwoFactor))
+	mux.HandleFunc("GET /tasks", s.requireSession(s.handleListTasks))
+	mux.HandleFunc("POST /tasks", s.requireSession(s.handleCreateTask))
+	mux.HandleFunc("GET /tasks/{id}", s.requireSession(s.handleGetTask))
+	mux.HandleFunc("PATCH /tasks/{id}", s.requireSession(s.handleUpdateTask))
+	mux.HandleFunc("DELETE /tasks/{id}", s.requireSession(s.handleDeleteTask))
+	mux.HandleFunc("POST /tasks/{id}/skip", s.requireSession(s.handleSkipTask))
+	mux.HandleFunc("GET /tasks/{id}/assignments", s.requireSession(s.handleListAssignments))
+	mux.HandleFunc("GET /tasks/{id}/history", s.requireSession(s.handleTaskHistory))
+	mux.HandleFunc("GET /tasks/{id}/comments", s.requireSession(s.handleListComments))
+	mux.HandleFunc("POST /tasks/{id}/comments", s.requireSession(s.handleAddComment))
+	mux.HandleFunc("PATCH /comments/{cid}", s.requireSession(s.handleEditComment))
+	mux.HandleFunc("DELETE /comments/{cid}", s.requireSession(s.handleDeleteComment))
+	mux.HandleFunc("GET /trash", s.requireSession(s.handleListTrash))
+	mux.HandleFunc("POST /trash/{id}/restore", s.requireSession(s.handleRestoreTask))
+	mux.HandleFunc("GET /workflow", s.requireSession(s.handleGetWorkflow))
+	mux.HandleFunc("PUT /workflow", s.requireSession(s.handleSaveWorkflow))
+	mux.HandleFunc("DELETE /workflow", s.requireSession(s.handleResetWorkflow))
+	mux.HandleFunc("GET /tags", s.requireSession(s.handleListTags))
+	mux.HandleFunc("PATCH /tags/{name}", s.requireSession(s.handleRenameTag))
+	mux.HandleFunc("DELETE /tags/{name}", s.requireSession(s.handleDeleteTag))
+	mux.HandleFunc("POST /tags/merge", s.requireSession(s.handleMergeTags))
+	mux.HandleFunc("GET /projects", s.requireSession(s.handleListProjects))
+	mux.HandleFunc("POST /projects", s.requireSession(s.handleCreateProject))
+	mux.HandleFunc("PATCH /projects/{name}", s.requireSession(s.handleUpdateProject))
+	mux.HandleFunc("DELETE /projects/{name}", s.requireSession(s.handleDeleteProject))
+	mux.HandleFunc("GET /workspaces", s.requireSession(s.handleListWorkspaces))
+	mux.HandleFunc("POST /workspaces", s.requireSession(s.handleCreateWorkspace))
+	mux.HandleFunc("PATCH /workspaces/{wid}", s.requireSession(s.handleUpdateWorkspace))
+	mux.HandleFunc("DELETE /workspaces/{wid}", s.requireSession(s.handleDeleteWorkspace))
+	mux.HandleFunc("GET /workspaces/{wid}/members", s.requireSession(s.handleListMembers))
+	mux.HandleFunc("POST /workspaces/{wid}/members", s.requireSession(s.handleAddMember))
+	mux.HandleFunc("PATCH /workspaces/{wid}/members/{username}", s.requireSession(s.handleSetMemberRole))
+	mux.HandleFunc("DELETE /workspaces/{wid}/members/{username}", s.requireSession(s.handleRemoveMember))
+	return mux
+}
+
+// Function to run the API until interrupted, then shut down gracefully
+func serveAPI(addr string, s *apiServer) error {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           s.handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      30 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	errs := make(chan error, 1)
+	go func() {
+		log.Println("Serving the REST API on", addr)
+		errs <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		log.Println("Shutting down the REST API...")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		return srv.Shutdown(shutdownCtx)
+	}
+}
+
+// Helper function to write a JSON response
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if body != nil {
+		if err := json.NewEncoder(w).Encode(body); err != nil {
+			log.Println("Error writing response:", err)
+		}
+	}
+}
+
+// apiError is the body of every error response
+type apiError struct {
+	Error string `json:"error"`
+	Field string `json:"field,omitempty"`
+}
+
+// Helper function to write an error response
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, apiError{Error: message})
+}
+
+// Helper function to map an error from the stores onto a response
+func writeStoreError(w http.ResponseWriter, err error) {
+	var validationErr *ValidationError
+	var lockedErr *LockedError
+	switch {
+	case errors.As(err, &validationErr):
+		writeJSON(w, http.StatusBadRequest, apiError{Error: validationErr.Err.Error(), Field: validationErr.Field})
+	case errors.As(err, &lockedErr):
+		retryAfter := int(time.Until(lockedErr.Until).Seconds()) + 1
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		writeError(w, http.StatusTooManyRequests, err.Error())
+	case errors.Is(err, ErrTaskNotFound):
+		writeError(w, http.StatusNotFound, "task not found")
+	case errors.Is(err, ErrCommentNotFound):
+		writeError(w, http.StatusNotFound, "comment not found")
+	case errors.Is(err, ErrTransitionNotAllowed):
+		writeJSON(w, http.StatusConflict, apiError{Error: err.Error(), Field: "status"})
+	case errors.Is(err, ErrInvalidResetCode):
+		writeJSON(w, http.StatusBadRequest, apiError{Error: err.Error(), Field: "code"})
+	case errors.Is(err, ErrWrongAnswers):
+		writeJSON(w, http.StatusBadRequest, apiError{Error: err.Error(), Field: "security_answers"})
+	case errors.Is(err, ErrInvalidSecondFactor), errors.Is(err, ErrSecondFactorRequired):
+		writeJSON(w, http.StatusForbidden, apiError{Error: err.Error(), Field: "code"})
+	case errors.Is(err, ErrForbidden):
+		writeError(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, ErrTaskConflict), errors.Is(err, ErrTagExists), errors.Is(err, ErrTaskHasSubtasks), errors.Is(err, ErrTaskBlocked),
+		errors.Is(err, ErrProjectExists), errors.Is(err, ErrAlreadyMember), errors.Is(err, ErrLastOwner),
+		errors.Is(err, ErrParentInTrash), errors.Is(err, ErrTwoFactorEnabled), errors.Is(err, ErrTwoFactorDisabled),
+		errors.Is(err, ErrNoTwoFactorSetup), errors.Is(err, ErrQuestionsOff), errors.Is(err, ErrNoNotifier):
+		writeError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, ErrTagNotFound), errors.Is(err, ErrProjectNotFound), errors.Is(err, ErrWorkspaceNotFound),
+		errors.Is(err, ErrMemberNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	default:
+		log.Println("API error:", err)
+		writeError(w, http.StatusInternalServerError, "internal server error")
+	}
+}
+
+// Helper function to decode a JSON request body, rejecting unknown fields
+func decodeJSON(w http.ResponseWriter, r *http.Request, dst interface{}) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(dst); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// Helper function to get the bearer token from the Authorization header
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if token, ok := strings.CutPrefix(header, "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+// requireSession rejects requests without a live session and passes the
+// session's user, working in the workspace named by the X-Workspace-ID header
+// or else the session's own, to the handler through the request context
+func (s *apiServer) requireSession(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := bearerToken(r)
+		if token == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, "missing bearer token")
+			return
+		}
+		userID, ok := isValidToken(s.sessions, token)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			writeError(w, http.StatusUnauthorized, "invalid or expired token")
+			return
+		}
+		var actor Actor
+		if header := r.Header.Get(workspaceHeader); header != "" {
+			workspaceID, err := strconv.Atoi(header)
+			if err != nil || workspaceID <= 0 {
+				writeError(w, http.StatusBadRequest, workspaceHeader+" must be a positive integer")
+				return
+			}
+			actor = Actor{UserID: userID, WorkspaceID: workspaceID}
+		} else {
+			var err error
+			if actor, err = s.sessions.Actor(token, userID); err != nil {
+				writeStoreError(w, err)
+				return
+			}
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), actorKey, actor)))
+	}
+}
+
+// Helper function to get the logged-in user and their workspace from a request that passed requireSession
+func requestActor(r *http.Request) Actor {
+	return r.Context().Value(actorKey).(Actor)
+}
+
+// Helper function to get the logged-in user working in the workspace of the {wid} path segment
+func workspaceActor(w http.ResponseWriter, r *http.Request) (Actor, bool) {
+	workspaceID, err := strconv.Atoi(r.PathValue("wid"))
+	if err != nil || workspaceID <= 0 {
+		writeError(w, http.StatusBadRequest, "workspace id must be a positive integer")
+		return Actor{}, false
+	}
+	return Actor{UserID: requestActor(r).UserID, WorkspaceID: workspaceID}, true
+}
+
+// Helper function to parse the {id} path segment
+func taskIDFromPath(w http.ResponseWriter, r *http.Request) (int, bool) {
+	taskID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || taskID <= 0 {
+		writeError(w, http.StatusBadRequest, "task id must be a positive integer")
+		return 0, false
+	}
+	return taskID, true
+}
+
+// Helper function to parse the {cid} path segment
+func commentIDFromPath(w http.ResponseWriter, r *http.Request) (int, bool) {
+	commentID, err := strconv.Atoi(r.PathValue("cid"))
+	if err != nil || commentID <= 0 {
+		writeError(w, http.StatusBadRequest, "comment id must be a positive integer")
+		return 0, false
+	}
+	return commentID, true
+}
+
+func (s *apiServer) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(openAPISpec)
+}
+
+type signUpRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Email    string `json:"email"` // optional; where password reset codes are sent
+	// One answer for each of password_reset.security_questions questions
+	SecurityAnswers []SecurityAnswer `json:"security_answers"`
+}
+
+func (s *apiServer) handleSignUp(w http.ResponseWriter, r *http.Request) {
+	var req signUpRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	userID, err := createUser(s.router, sanitizeInput(req.Username), req.Password, sanitizeInput(req.Email), req.SecurityAnswers, s.resets.questions)
+	if err == ErrUsernameTaken {
+		writeJSON(w, http.StatusConflict, apiError{Error: err.Error(), Field: "username"})
+		return
+	} else if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]interface{}{"user_id": userID, "username": sanitizeInput(req.Username)})
+}
+
+type logInRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Code     string `json:"code"` // only for accounts with two-factor authentication
+}
+
+type logInResponse struct {
+	UserID    int       `json:"user_id"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Notices   []string  `json:"notices,omitempty"` // e.g. lockouts since the last login
+}
+
+func (s *apiServer) handleLogIn(w http.ResponseWriter, r *http.Request) {
+	var req logInRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	username := sanitizeInput(req.Username)
+	var userID int
+	err := s.limiter.Attempt(username, requestSource(r), func() error {
+		var err error
+		if userID, err = authenticate(s.router, username, req.Password); err == nil {
+			err = checkSecondFactor(s.router, userID, req.Code)
+		}
+		return err
+	})
+	if err == ErrInvalidCredentials {
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	} else if err == ErrSecondFactorRequired || err == ErrInvalidSecondFactor {
+		writeJSON(w, http.StatusUnauthorized, apiError{Error: err.Error(), Field: "code"})
+		return
+	} else if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	authToken, err := s.sessions.Create(userID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	notices, err := takeNotices(s.router, userID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, logInResponse{UserID: userID, Token: authToken.Token, ExpiresAt: authToken.ExpiresAt, Notices: notices})
+}
+
+type passwordResetRequest struct {
+	Username string `json:"username"`
+}
+
+func (s *apiServer) handleRequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var req passwordResetRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	username := sanitizeInput(req.Username)
+	err := s.limiter.Count(username, requestSource(r), func() error {
+		return s.resets.Request(username)
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	// The same answer whether or not the account exists
+	w.WriteHeader(http.StatusAccepted)
+}
+
+type resetQuestionsRequest struct {
+	Username string `json:"username"`
+	Code     string `json:"code"`
+}
+
+// The questions are only shown to someone with a valid reset code
+func (s *apiServer) handleResetQuestions(w http.ResponseWriter, r *http.Request) {
+	var req resetQuestionsRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	username := sanitizeInput(req.Username)
+	var questions []SecurityQuestion
+	err := s.limiter.AttemptStep(username, requestSource(r), func() error {
+		var err error
+		questions, err = s.resets.Questions(username, req.Code)
+		return err
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if questions == nil {
+		questions = []SecurityQuestion{} // encode as [] rather than null
+	}
+	writeJSON(w, http.StatusOK, questions)
+}
+
+type resetPasswordRequest struct {
+	Username        string           `json:"username"`
+	Code            string           `json:"code"`
+	SecurityAnswers []SecurityAnswer `json:"security_answers"` // when security questions are turned on
+	Password        string           `json:"password"`
+}
+
+func (s *apiServer) handleResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req resetPasswordRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	username := sanitizeInput(req.Username)
+	err := s.limiter.Attempt(username, requestSource(r), func() error {
+		return s.resets.Reset(username, req.Code, req.SecurityAnswers, req.Password)
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *apiServer) handleGetAccount(w http.ResponseWriter, r *http.Request) {
+	account, err := loadAccount(s.router, requestActor(r).UserID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, account)
+}
+
+type updateAccountRequest struct {
+	Username *string `json:"username"`
+	Email    *string `json:"email"` // "" removes the address
+}
+
+func (s *apiServer) handleUpdateAccount(w http.ResponseWriter, r *http.Request) {
+	var req updateAccountRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	userID := requestActor(r).UserID
+	if req.Username != nil {
+		err := changeUsername(s.router, userID, sanitizeInput(*req.Username))
+		if err == ErrUsernameTaken {
+			writeJSON(w, http.StatusConflict, apiError{Error: err.Error(), Field: "username"})
+			return
+		} else if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+	}
+	if req.Email != nil {
+		if err := setEmail(s.router, userID, sanitizeInput(*req.Email)); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+	}
+	s.handleGetAccount(w, r)
+}
+
+type changePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// The session making the change stays logged in; the user's others end
+func (s *apiServer) handleChangePassword(w http.ResponseWriter, r *http.Request) {
+	var req changePasswordRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	userID := requestActor(r).UserID
+	err := s.limiter.AttemptAs(userID, requestSource(r), func() error {
+		return changePassword(s.router, userID, bearerToken(r), req.CurrentPassword, req.NewPassword)
+	})
+	if err == ErrInvalidCredentials {
+		writeJSON(w, http.StatusForbidden, apiError{Error: "wrong password", Field: "current_password"})
+		return
+	} else if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type deleteAccountRequest struct {
+	Password string `json:"password"`
+}
+
+func (s *apiServer) handleDeleteAccount(w http.ResponseWriter, r *http.Request) {
+	var req deleteAccountRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	userID := requestActor(r).UserID
+	err := s.limiter.AttemptAs(userID, requestSource(r), func() error {
+		return s.tasks.DeleteAccount(userID, req.Password)
+	})
+	if err == ErrInvalidCredentials {
+		writeJSON(w, http.StatusForbidden, apiError{Error: "wrong password", Field: "password"})
+		return
+	} else if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *apiServer) handleListSecurityQuestions(w http.ResponseWriter, r *http.Request) {
+	questions, err := questionCatalog(s.router.Reader(anonymousSession))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if questions == nil {
+		questions = []SecurityQuestion{} // encode as [] rather than null
+	}
+	writeJSON(w, http.StatusOK, questions)
+}
+
+func (s *apiServer) handleGetSecurityQuestions(w http.ResponseWriter, r *http.Request) {
+	userID := requestActor(r).UserID
+	questions, err := chosenQuestions(s.router.Reader(userID), userID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if questions == nil {
+		questions = []SecurityQuestion{} // encode as [] rather than null
+	}
+	writeJSON(w, http.StatusOK, questions)
+}
+
+type setSecurityQuestionsRequest struct {
+	Password        string           `json:"password"` // the current one
+	SecurityAnswers []SecurityAnswer `json:"security_answers"`
+}
+
+func (s *apiServer) handleSetSecurityQuestions(w http.ResponseWriter, r *http.Request) {
+	var req setSecurityQuestionsRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	userID := requestActor(r).UserID
+	err := s.limiter.AttemptAs(userID, requestSource(r), func() error {
+		return s.resets.SetAnswers(userID, req.Password, req.SecurityAnswers)
+	})
+	if err == ErrInvalidCredentials {
+		writeJSON(w, http.StatusForbidden, apiError{Error: "wrong password", Field: "password"})
+		return
+	} else if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	s.handleGetSecurityQuestions(w, r)
+}
+
+func (s *apiServer) handleTwoFactorStatus(w http.ResponseWriter, r *http.Request) {
+	status, err := twoFactorStatus(s.router, requestActor(r).UserID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (s *apiServer) handleStartTwoFactor(w http.ResponseWriter, r *http.Request) {
+	setup, err := startTwoFactor(s.router, requestActor(r).UserID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, setup)
+}
+
+type twoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+func (s *apiServer) handleConfirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req twoFactorCodeRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	codes, err := confirmTwoFactor(s.router, requestActor(r).UserID, req.Code)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"recovery_codes": codes})
+}
+
+func (s *apiServer) handleDisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req twoFactorCodeRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	userID := requestActor(r).UserID
+	err := s.limiter.AttemptAs(userID, requestSource(r), func() error {
+		return disableTwoFactor(s.router, userID, req.Code)
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *apiServer) handleLogOut(w http.ResponseWriter, r *http.Request) {
+	if err := s.sessions.Revoke(bearerToken(r)); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *apiServer) handleListTasks(w http.ResponseWriter, r *http.Request) {
+	// ?tag=a&tag=b (or ?tag=a,b) lists tasks with any of the tags; add match=all to require every one
+	query := r.URL.Query()
+	filter := TaskFilter{Project: query.Get("project")}
+	for _, tags := range query["tag"] {
+		filter.Tags = append(filter.Tags, splitTags(tags)...)
+	}
+	switch query.Get("match") {
+	case "", "any":
+	case "all":
+		filter.MatchAllTag = true
+	default:
+		writeStoreError(w, &ValidationError{Field: "match", Err: errors.New(`match must be "any" or "all"`)})
+		return
+	}
+	view := query.Get("view")
+	if view != "" && view != "all" && view != "ready" && view != "order" {
+		writeStoreError(w, &ValidationError{Field: "view", Err: errors.New(`view must be "all", "ready" or "order"`)})
+		return
+	}
+	// ?assigned_to_me=true and ?created_by_me=true narrow the list down to the caller's tasks
+	actor := requestActor(r)
+	assignedToMe, err := boolParam(query, "assigned_to_me")
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	createdByMe, err := boolParam(query, "created_by_me")
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if assignedToMe {
+		filter.AssigneeID = actor.UserID
+	}
+	if createdByMe {
+		filter.CreatorID = actor.UserID
+	}
+
+	tasks, err := s.tasks.List(actor, filter)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	sortTasksByDue(tasks)
+	switch view {
+	case "ready":
+		tasks = readyTasks(tasks)
+	case "order":
+		tasks = orderByDependencies(tasks)
+	}
+	if tasks == nil {
+		tasks = []Task{} // encode as [] rather than null
+	}
+	writeJSON(w, http.StatusOK, tasks)
+}
+
+// Helper function to parse an optional true/false query parameter
+func boolParam(query url.Values, field string) (bool, error) {
+	value := query.Get(field)
+	if value == "" {
+		return false, nil
+	}
+	on, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, &ValidationError{Field: field, Err: errors.New(field + " must be true or false")}
+	}
+	return on, nil
+}
+
+// Function to check the fields of a new or changed task
+func validateTaskFields(title, description, status *string) error {
+	if title != nil {
+		*title = sanitizeInput(*title)
+		if *title == "" {
+			return &ValidationError{Field: "title", Err: errors.New("title is required")}
+		}
+		if len(*title) > 50 {
+			return &ValidationError{Field: "title", Err: errors.New("title must be at most 50 characters long")}
+		}
+	}
+	if description != nil {
+		*description = sanitizeInput(*description)
+	}
+	if status != nil {
+		// Whether the status exists and may be entered is checked against the workspace's workflow by the store
+		*status = normalizeStatusName(*status)
+	}
+	return nil
+}
+
+type createTaskRequest struct {
+	Title        string   `json:"title"`
+	Description  string   `json:"description"`
+	Status       string   `json:"status"`
+	Priority     Priority `json:"priority"`
+	Due          string   `json:"due"` // same formats as ParseDue
+	Tags         []string `json:"tags"`
+	ParentID     *int     `json:"parent_id"`
+	AutoComplete bool     `json:"auto_complete"`
+	BlockedBy    []int    `json:"blocked_by"`
+	Recurrence   string   `json:"recurrence"` // RRULE or daily, weekdays, weekly, monthly, yearly
+	Project      string   `json:"project"`    // project name
+	Assignee     string   `json:"assignee"`   // username of a workspace member
+	Watchers     []string `json:"watchers"`   // usernames of workspace members
+}
+
+type updateTaskRequest struct {
+	Title           *string   `json:"title"`
+	Description     *string   `json:"description"`
+	Status          *string   `json:"status"`
+	Priority        *Priority `json:"priority"`
+	Due             *string   `json:"due"` // "" removes the due date
+	AddTags         []string  `json:"add_tags"`
+	RemoveTags      []string  `json:"remove_tags"`
+	ParentID        *int      `json:"parent_id"` // 0 makes the task a top-level task
+	AutoComplete    *bool     `json:"auto_complete"`
+	AddBlockedBy    []int     `json:"add_blocked_by"`
+	RemoveBlockedBy []int     `json:"remove_blocked_by"`
+	Recurrence      *string   `json:"recurrence"` // "" stops the series
+	Project         *string   `json:"project"`    // "" takes the task out of its project
+	Assignee        *string   `json:"assignee"`   // "" unassigns the task
+	AddWatchers     []string  `json:"add_watchers"`
+	RemoveWatchers  []string  `json:"remove_watchers"`
+}
+
+// Helper function to parse a due date from a request body
+func parseDueField(due string) (*time.Time, error) {
+	dueAt, err := ParseDue(due)
+	if err != nil {
+		return nil, &ValidationError{Field: "due", Err: err}
+	}
+	return dueAt, nil
+}
+
+func (s *apiServer) handleCreateTask(w http.ResponseWriter, r *http.Request) {
+	var req createTaskRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if err := validateTaskFields(&req.Title, &req.Description, &req.Status); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	dueAt, err := parseDueField(req.Due)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	task, err := s.tasks.Create(requestActor(r), Task{
+		Title: req.Title, Description: req.Description, Status: req.Status, Priority: req.Priority, DueAt: dueAt, Tags: req.Tags,
+		ParentID: req.ParentID, AutoComplete: req.AutoComplete, BlockedBy: req.BlockedBy,
+		Recurrence: req.Recurrence, Project: req.Project, Assignee: req.Assignee, Watchers: req.Watchers,
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.Header().Set("Location", "/tasks/"+strconv.Itoa(task.ID))
+	writeJSON(w, http.StatusCreated, task)
+}
+
+func (s *apiServer) handleGetTask(w http.ResponseWriter, r *http.Request) {
+	taskID, ok := taskIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	task, err := s.tasks.Get(requestActor(r), taskID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, task)
+}
+
+func (s *apiServer) handleUpdateTask(w http.ResponseWriter, r *http.Request) {
+	taskID, ok := taskIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	var req updateTaskRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if err := validateTaskFields(req.Title, req.Description, req.Status); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	update := TaskUpdate{
+		Title: req.Title, Description: req.Description, Status: req.Status, Priority: req.Priority,
+		AddTags: req.AddTags, RemoveTags: req.RemoveTags,
+		ParentID: req.ParentID, ClearParent: req.ParentID != nil && *req.ParentID == 0, AutoComplete: req.AutoComplete,
+		AddBlockers: req.AddBlockedBy, RemoveBlockers: req.RemoveBlockedBy, Recurrence: req.Recurrence,
+		Project: req.Project, Assignee: req.Assignee, AddWatchers: req.AddWatchers, RemoveWatchers: req.RemoveWatchers,
+	}
+	if req.Due != nil {
+		dueAt, err := parseDueField(*req.Due)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		update.DueAt = dueAt
+		update.ClearDue = dueAt == nil
+	}
+	task, err := s.tasks.Update(requestActor(r), taskID, update)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, task)
+}
+
+func (s *apiServer) handleDeleteTask(w http.ResponseWriter, r *http.Request) {
+	taskID, ok := taskIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	subtasks, err := ParseSubtaskPolicy(r.URL.Query().Get("subtasks"))
+	if err != nil {
+		writeStoreError(w, &ValidationError{Field: "subtasks", Err: err})
+		return
+	}
+	if err := s.tasks.Delete(requestActor(r), taskID, subtasks); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Function to list the workspace's deleted tasks, most recently deleted first
+func (s *apiServer) handleListTrash(w http.ResponseWriter, r *http.Request) {
+	tasks, err := s.tasks.Trash(requestActor(r))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if tasks == nil {
+		tasks = []Task{} // encode as [] rather than null
+	}
+	writeJSON(w, http.StatusOK, tasks)
+}
+
+// Function to take a task out of the trash
+func (s *apiServer) handleRestoreTask(w http.ResponseWriter, r *http.Request) {
+	taskID, ok := taskIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	task, err := s.tasks.Restore(requestActor(r), taskID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, task)
+}
+
+// Function to move a recurring task on to its next occurrence without completing it
+func (s *apiServer) handleSkipTask(w http.ResponseWriter, r *http.Request) {
+	taskID, ok := taskIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	task, err := s.tasks.Update(requestActor(r), taskID, TaskUpdate{SkipOccurrence: true})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, task)
+}
+
+// Function to list who a task has been assigned to, oldest first
+func (s *apiServer) handleListAssignments(w http.ResponseWriter, r *http.Request) {
+	taskID, ok := taskIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	assignments, err := s.tasks.Assignments(requestActor(r), taskID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if assignments == nil {
+		assignments = []Assignment{} // encode as [] rather than null
+	}
+	writeJSON(w, http.StatusOK, assignments)
+}
+
+// Function to list every recorded change to a task, oldest first
+func (s *apiServer) handleTaskHistory(w http.ResponseWriter, r *http.Request) {
+	taskID, ok := taskIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	events, err := s.tasks.History(requestActor(r), taskID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if events == nil {
+		events = []TaskEvent{} // encode as [] rather than null
+	}
+	writeJSON(w, http.StatusOK, events)
+}
+
+type commentRequest struct {
+	Body string `json:"body"`
+}
+
+func (s *apiServer) handleListComments(w http.ResponseWriter, r *http.Request) {
+	taskID, ok := taskIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	comments, err := s.tasks.Comments(requestActor(r), taskID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if comments == nil {
+		comments = []Comment{} // encode as [] rather than null
+	}
+	writeJSON(w, http.StatusOK, comments)
+}
+
+func (s *apiServer) handleAddComment(w http.ResponseWriter, r *http.Request) {
+	taskID, ok := taskIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	var req commentRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	comment, err := s.tasks.AddComment(requestActor(r), taskID, req.Body)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.Header().Set("Location", "/comments/"+strconv.Itoa(comment.ID))
+	writeJSON(w, http.StatusCreated, comment)
+}
+
+func (s *apiServer) handleEditComment(w http.ResponseWriter, r *http.Request) {
+	commentID, ok := commentIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	var req commentRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	comment, err := s.tasks.EditComment(requestActor(r), commentID, req.Body)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, comment)
+}
+
+func (s *apiServer) handleDeleteComment(w http.ResponseWriter, r *http.Request) {
+	commentID, ok := commentIDFromPath(w, r)
+	if !ok {
+		return
+	}
+	if err := s.tasks.DeleteComment(requestActor(r), commentID); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *apiServer) handleGetWorkflow(w http.ResponseWriter, r *http.Request) {
+	workflow, err := s.tasks.Workflow(requestActor(r))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, workflow)
+}
+
+func (s *apiServer) handleSaveWorkflow(w http.ResponseWriter, r *http.Request) {
+	var workflow Workflow
+	if !decodeJSON(w, r, &workflow) {
+		return
+	}
+	for i := range workflow.Statuses {
+		workflow.Statuses[i].Name = normalizeStatusName(workflow.Statuses[i].Name)
+	}
+	if err := s.tasks.SaveWorkflow(requestActor(r), workflow); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	s.handleGetWorkflow(w, r)
+}
+
+func (s *apiServer) handleResetWorkflow(w http.ResponseWriter, r *http.Request) {
+	if err := s.tasks.ResetWorkflow(requestActor(r)); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	s.handleGetWorkflow(w, r)
+}
+
+func (s *apiServer) handleListTags(w http.ResponseWriter, r *http.Request) {
+	tags, err := s.tasks.Tags(requestActor(r))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if tags == nil {
+		tags = []Tag{} // encode as [] rather than null
+	}
+	writeJSON(w, http.StatusOK, tags)
+}
+
+type renameTagRequest struct {
+	Name string `json:"name"`
+}
+
+func (s *apiServer) handleRenameTag(w http.ResponseWriter, r *http.Request) {
+	var req renameTagRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if err := s.tasks.RenameTag(requestActor(r), r.PathValue("name"), req.Name); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	s.handleListTags(w, r)
+}
+
+type mergeTagsRequest struct {
+	Tags []string `json:"tags"`
+	Into string   `json:"into"`
+}
+
+func (s *apiServer) handleMergeTags(w http.ResponseWriter, r *http.Request) {
+	var req mergeTagsRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if err := s.tasks.MergeTags(requestActor(r), req.Tags, req.Into); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	s.handleListTags(w, r)
+}
+
+func (s *apiServer) handleDeleteTag(w http.ResponseWriter, r *http.Request) {
+	if err := s.tasks.DeleteTag(requestActor(r), r.PathValue("name")); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *apiServer) handleListProjects(w http.ResponseWriter, r *http.Request) {
+	projects, err := s.tasks.Projects(requestActor(r))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if projects == nil {
+		projects = []Project{} // encode as [] rather than null
+	}
+	writeJSON(w, http.StatusOK, projects)
+}
+
+type createProjectRequest struct {
+	Name string `json:"name"`
+}
+
+func (s *apiServer) handleCreateProject(w http.ResponseWriter, r *http.Request) {
+	var req createProjectRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	project, err := s.tasks.CreateProject(requestActor(r), req.Name)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, project)
+}
+
+type updateProjectRequest struct {
+	Name     *string `json:"name"`
+	Archived *bool   `json:"archived"`
+}
+
+func (s *apiServer) handleUpdateProject(w http.ResponseWriter, r *http.Request) {
+	var req updateProjectRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	actor := requestActor(r)
+	name := r.PathValue("name")
+	if req.Archived != nil {
+		if err := s.tasks.ArchiveProject(actor, name, *req.Archived); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+	}
+	if req.Name != nil {
+		if err := s.tasks.RenameProject(actor, name, *req.Name); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+	}
+	s.handleListProjects(w, r)
+}
+
+func (s *apiServer) handleDeleteProject(w http.ResponseWriter, r *http.Request) {
+	// ?tasks=delete deletes the project's tasks too; by default they are kept without a project
+	var withTasks bool
+	switch r.URL.Query().Get("tasks") {
+	case "", "keep":
+	case "delete":
+		withTasks = true
+	default:
+		writeStoreError(w, &ValidationError{Field: "tasks", Err: errors.New(`tasks must be "keep" or "delete"`)})
+		return
+	}
+	if err := s.tasks.DeleteProject(requestActor(r), r.PathValue("name"), withTasks); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *apiServer) handleListWorkspaces(w http.ResponseWriter, r *http.Request) {
+	workspaces, err := s.tasks.Workspaces(requestActor(r).UserID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if workspaces == nil {
+		workspaces = []Workspace{} // encode as [] rather than null
+	}
+	writeJSON(w, http.StatusOK, workspaces)
+}
+
+type workspaceRequest struct {
+	Name string `json:"name"`
+}
+
+func (s *apiServer) handleCreateWorkspace(w http.ResponseWriter, r *http.Request) {
+	var req workspaceRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	workspace, err := s.tasks.CreateWorkspace(requestActor(r).UserID, req.Name)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, workspace)
+}
+
+type updateWorkspaceRequest struct {
+	Name             *string `json:"name"`
+	RequireTwoFactor *bool   `json:"require_two_factor"`
+}
+
+func (s *apiServer) handleUpdateWorkspace(w http.ResponseWriter, r *http.Request) {
+	actor, ok := workspaceActor(w, r)
+	if !ok {
+		return
+	}
+	var req updateWorkspaceRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Name != nil {
+		if err := s.tasks.RenameWorkspace(actor, *req.Name); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+	}
+	if req.RequireTwoFactor != nil {
+		if err := s.tasks.SetTwoFactorRequired(actor, *req.RequireTwoFactor); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+	}
+	s.handleListWorkspaces(w, r)
+}
+
+func (s *apiServer) handleDeleteWorkspace(w http.ResponseWriter, r *http.Request) {
+	actor, ok := workspaceActor(w, r)
+	if !ok {
+		return
+	}
+	if err := s.tasks.DeleteWorkspace(actor); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *apiServer) handleListMembers(w http.ResponseWriter, r *http.Request) {
+	actor, ok := workspaceActor(w, r)
+	if !ok {
+		return
+	}
+	members, err := s.tasks.Members(actor)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, members)
+}
+
+type memberRequest struct {
+	Username string `json:"username"`
+	Role     string `json:"role"`
+}
+
+func (s *apiServer) handleAddMember(w http.ResponseWriter, r *http.Request) {
+	actor, ok := workspaceActor(w, r)
+	if !ok {
+		return
+	}
+	var req memberRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	role := RoleMember
+	if req.Role != "" {
+		var err error
+		if role, err = ParseRole(req.Role); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+	}
+	if err := s.tasks.AddMember(actor, sanitizeInput(req.Username), role); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	s.handleListMembers(w, r)
+}
+
+func (s *apiServer) handleSetMemberRole(w http.ResponseWriter, r *http.Request) {
+	actor, ok := workspaceActor(w, r)
+	if !ok {
+		return
+	}
+	var req memberRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	role, err := ParseRole(req.Role)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if err := s.tasks.SetMemberRole(actor, r.PathValue("username"), role); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	s.handleListMembers(w, r)
+}
+
+func (s *apiServer) handleRemoveMember(w http.ResponseWriter, r *http.Request) {
+	actor, ok := workspaceActor(w, r)
+	if !ok {
+		return
+	}
+	if err := s.tasks.RemoveMember(actor, r.PathValue("username")); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
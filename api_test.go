@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Helper function to build the API over an empty in-memory database
+func newTestAPI(t *testing.T) (*apiServer, http.Handler) {
+	t.Helper()
+	router := newTestRouter(t)
+	lockout := LockoutConfig{MaxFailures: 5, SourceMaxFailures: 20, BaseDelay: time.Second, MaxDelay: time.Minute, ResetAfter: time.Hour}
+	s := &apiServer{
+		router:   router,
+		tasks:    newSQLTaskStore(router, time.Hour),
+		sessions: newSessionStore(router, time.Hour),
+		limiter:  newLoginLimiter(router, lockout, nil),
+		resets:   newResetStore(router, nil, ResetConfig{CodeTTL: time.Hour}),
+	}
+	return s, s.handler()
+}
+
+// Helper function to send a request to the API, with the token as bearer
+// token unless it is empty, and decode the JSON response into out if given
+func apiRequest(t *testing.T, handler http.Handler, method, path, token string, body, out interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	var reader *bytes.Reader
+	if raw, ok := body.(string); ok {
+		reader = bytes.NewReader([]byte(raw))
+	} else {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if out != nil && rec.Code < 300 {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: decoding %q: %v", method, path, rec.Body.String(), err)
+		}
+	}
+	return rec
+}
+
+// Helper function to sign up through the API and log in, returning the token
+func apiUser(t *testing.T, handler http.Handler, username string) string {
+	t.Helper()
+	credentials := map[string]string{"username": username, "password": "Passw0rd!"}
+	if rec := apiRequest(t, handler, "POST", "/signup", "", credentials, nil); rec.Code != http.StatusCreated {
+		t.Fatalf("signing up %q: %d %s", username, rec.Code, rec.Body)
+	}
+	var login logInResponse
+	if rec := apiRequest(t, handler, "POST", "/login", "", credentials, &login); rec.Code != http.StatusOK {
+		t.Fatalf("logging in %q: %d %s", username, rec.Code, rec.Body)
+	}
+	return login.Token
+}
+
+func TestAPISignUpAndLogIn(t *testing.T) {
+	_, handler := newTestAPI(t)
+	apiUser(t, handler, "alice")
+
+	tests := []struct {
+		name      string
+		path      string
+		body      interface{}
+		wantCode  int
+		wantField string
+	}{
+		{"taken username", "/signup", map[string]string{"username": "alice", "password": "Passw0rd!"}, http.StatusConflict, "username"},
+		{"weak password", "/signup", map[string]string{"username": "bob", "password": "short"}, http.StatusBadRequest, "password"},
+		{"unknown field", "/signup", `{"username": "bob", "password": "Passw0rd!", "admin": true}`, http.StatusBadRequest, ""},
+		{"malformed JSON", "/login", `{"username":`, http.StatusBadRequest, ""},
+		{"wrong password", "/login", map[string]string{"username": "alice", "password": "Wr0ngpass!"}, http.StatusUnauthorized, ""},
+		{"unknown user", "/login", map[string]string{"username": "nobody", "password": "Passw0rd!"}, http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		rec := apiRequest(t, handler, "POST", tt.path, "", tt.body, nil)
+		var body apiError
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		if rec.Code != tt.wantCode || body.Field != tt.wantField || body.Error == "" {
+			t.Errorf("%s: %d %s; want %d with field %q", tt.name, rec.Code, rec.Body, tt.wantCode, tt.wantField)
+		}
+	}
+}
+
+func TestAPIRequiresSession(t *testing.T) {
+	_, handler := newTestAPI(t)
+	token := apiUser(t, handler, "alice")
+
+	if rec := apiRequest(t, handler, "GET", "/tasks", "", nil, nil); rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("without a token: %d; want 401 with WWW-Authenticate", rec.Code)
+	}
+	if rec := apiRequest(t, handler, "GET", "/tasks", "forged", nil, nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("with an unknown token: %d; want 401", rec.Code)
+	}
+	if rec := apiRequest(t, handler, "GET", "/tasks", token, nil, nil); rec.Code != http.StatusOK {
+		t.Errorf("with a token: %d; want 200", rec.Code)
+	}
+	if rec := apiRequest(t, handler, "POST", "/logout", token, nil, nil); rec.Code >= 300 {
+		t.Errorf("logging out: %d %s", rec.Code, rec.Body)
+	}
+	if rec := apiRequest(t, handler, "GET", "/tasks", token, nil, nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("after logging out: %d; want 401", rec.Code)
+	}
+}
+
+func TestAPITasks(t *testing.T) {
+	_, handler := newTestAPI(t)
+	alice := apiUser(t, handler, "alice")
+	bob := apiUser(t, handler, "bob")
+
+	var task Task
+	rec := apiRequest(t, handler, "POST", "/tasks", alice, map[string]string{"title": "Write the report"}, &task)
+	if rec.Code != http.StatusCreated || rec.Header().Get("Location") != "/tasks/"+strconv.Itoa(task.ID) {
+		t.Fatalf("creating a task: %d, Location %q; want 201 with its location", rec.Code, rec.Header().Get("Location"))
+	}
+	path := "/tasks/" + strconv.Itoa(task.ID)
+
+	rec = apiRequest(t, handler, "POST", "/tasks", alice, map[string]string{"title": " "}, nil)
+	var invalid apiError
+	json.Unmarshal(rec.Body.Bytes(), &invalid)
+	if rec.Code != http.StatusBadRequest || invalid.Field != "title" {
+		t.Errorf("creating a task without a title: %d %s; want 400 for title", rec.Code, rec.Body)
+	}
+	if rec := apiRequest(t, handler, "GET", "/tasks/abc", alice, nil, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("getting task abc: %d; want 400", rec.Code)
+	}
+
+	var got Task
+	if rec := apiRequest(t, handler, "GET", path, alice, nil, &got); rec.Code != http.StatusOK || got.Title != "Write the report" {
+		t.Errorf("getting the task: %d %q; want 200 with its title", rec.Code, got.Title)
+	}
+	var updated Task
+	rec = apiRequest(t, handler, "PATCH", path, alice, map[string]string{"title": "Send the report"}, &updated)
+	if rec.Code != http.StatusOK || updated.Title != "Send the report" {
+		t.Errorf("updating the task: %d %q; want 200 with the new title", rec.Code, updated.Title)
+	}
+
+	// Another user's tasks look the same as tasks that don't exist
+	for _, method := range []string{"GET", "PATCH", "DELETE"} {
+		if rec := apiRequest(t, handler, method, path, bob, map[string]string{"title": "Mine now"}, nil); rec.Code != http.StatusNotFound {
+			t.Errorf("%s of another user's task: %d; want 404", method, rec.Code)
+		}
+	}
+	var bobsTasks []Task
+	if apiRequest(t, handler, "GET", "/tasks", bob, nil, &bobsTasks); len(bobsTasks) != 0 {
+		t.Errorf("another user lists %d tasks; want none", len(bobsTasks))
+	}
+
+	if rec := apiRequest(t, handler, "DELETE", path, alice, nil, nil); rec.Code != http.StatusNoContent {
+		t.Errorf("deleting the task: %d; want 204", rec.Code)
+	}
+	if rec := apiRequest(t, handler, "GET", path, alice, nil, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("getting the deleted task: %d; want 404", rec.Code)
+	}
+	if rec := apiRequest(t, handler, "DELETE", path, alice, nil, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("deleting the task again: %d; want 404", rec.Code)
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Assignment is one entry in a task's assignment history
+type Assignment struct {
+	Assignee   string    `json:"assignee"` // "" when the task was unassigned
+	AssignedBy string    `json:"assigned_by"`
+	AssignedAt time.Time `json:"assigned_at"`
+}
+
+// AssignmentStore reads who a task has been assigned to over time. Tasks are
+// assigned and watched through TaskStore.Create and TaskStore.Update.
+type AssignmentStore interface {
+	Assignments(actor Actor, taskID int) ([]Assignment, error)
+}
+
+// Function to split comma-separated usernames as typed at a prompt or on the command line
+func splitUsernames(input string) []string {
+	return splitTags(input)
+}
+
+// Function to print a task's assignment history, one change per line
+func printAssignments(w io.Writer, assignments []Assignment) {
+	for _, assignment := range assignments {
+		change := "unassigned"
+		if assignment.Assignee != "" {
+			change = "assigned to " + assignment.Assignee
+		}
+		if assignment.AssignedBy != "" {
+			change += " by " + assignment.AssignedBy
+		}
+		fmt.Fprintf(w, "%s  %s\n", formatTime(assignment.AssignedAt), change)
+	}
+}
+
+// Function to look up the user IDs of members of the actor's workspace, for
+// assigning or watching tasks
+func memberIDs(db queryer, actor Actor, field string, usernames []string) ([]int, error) {
+	var ids []int
+	for _, username := range usernames {
+		member, err := findMember(db, actor, username)
+		if errors.Is(err, ErrMemberNotFound) {
+			return nil, &ValidationError{Field: field, Err: fmt.Errorf("%q is not a member of this workspace", strings.TrimSpace(username))}
+		} else if err != nil {
+			return nil, err
+		}
+		ids = append(ids, member.UserID)
+	}
+	return ids, nil
+}
+
+// Helper function to tell whether the actor may work on the task: members and
+// up on any task, everyone else only on the tasks assigned to them
+func canWorkOn(role Role, actor Actor, task Task) bool {
+	return role.AtLeast(RoleMember) || (task.AssigneeID != nil && *task.AssigneeID == actor.UserID)
+}
+
+// Function to check that the actor may make the update. Members and up may
+// change any task and assignees the tasks assigned to them; anyone in the
+// workspace may start or stop watching a task themselves.
+func checkCanUpdate(role Role, actor Actor, task Task, update TaskUpdate, addWatchers, removeWatchers []int) error {
+	switch {
+	case update.Assignee != nil && !role.AtLeast(RoleMember):
+		return fmt.Errorf("%w: needs the %s role or higher to reassign tasks", ErrForbidden, RoleMember)
+	case canWorkOn(role, actor, task):
+		return nil
+	}
+	rest := update
+	rest.AddWatchers, rest.RemoveWatchers = nil, nil
+	onlySelf := reflect.DeepEqual(rest, TaskUpdate{})
+	for _, userIDs := range [][]int{addWatchers, removeWatchers} {
+		for _, userID := range userIDs {
+			onlySelf = onlySelf && userID == actor.UserID
+		}
+	}
+	if !onlySelf {
+		return fmt.Errorf("%w: %ss can only update tasks assigned to them", ErrForbidden, role)
+	}
+	return nil
+}
+
+// Function to change who a task is assigned to and record it in the task's
+// assignment history; a nil assignee unassigns the task
+func assignTask(db queryer, workspaceID, taskID int, assigneeID *int, assignedBy int) error {
+	query := `UPDATE "task" SET assignee_id = $1 WHERE task_id = $2 AND workspace_id = $3`
+	if _, err := db.Exec(query, assigneeID, taskID, workspaceID); err != nil {
+		return err
+	}
+	query = `INSERT INTO task_assignment (task_id, assignee_id, assigned_by) VALUES ($1, $2, $3)`
+	_, err := db.Exec(query, taskID, assigneeID, assignedBy)
+	return err
+}
+
+// Function to add watchers to a task, skipping the ones already watching it
+func addWatchers(db queryer, taskID int, userIDs []int) error {
+	for _, userID := range userIDs {
+		query := `INSERT INTO task_watcher (task_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+		if _, err := db.Exec(query, taskID, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Function to remove watchers from a task
+func removeWatchers(db queryer, taskID int, userIDs []int) error {
+	for _, userID := range userIDs {
+		query := `DELETE FROM task_watcher WHERE task_id = $1 AND user_id = $2`
+		if _, err := db.Exec(query, taskID, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Function to snapshot the tasks in the workspace a user is assigned to or
+// watches, which are the ones unassigning them changes
+func (s *sqlTaskStore) snapshotInvolving(db queryer, workspaceID, userID int) (map[int]Task, error) {
+	query := `
+	SELECT task_id FROM "task" WHERE workspace_id = $1 AND assignee_id = $2
+	UNION SELECT w.task_id FROM task_watcher w JOIN "task" t ON t.task_id = w.task_id
+	WHERE t.workspace_id = $1 AND w.user_id = $2`
+	taskIDs, err := queryIDs(db, query, workspaceID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.snapshot(db, workspaceID, taskIDs)
+}
+
+// Function to unassign a user leaving the workspace from its tasks and stop
+// them watching any
+func unassignMember(db queryer, actor Actor, userID int) error {
+	rows, err := db.Query(`SELECT task_id FROM "task" WHERE workspace_id = $1 AND assignee_id = $2`, actor.WorkspaceID, userID)
+	if err != nil {
+		return err
+	}
+	var taskIDs []int
+	for rows.Next() {
+		var taskID int
+		if err := rows.Scan(&taskID); err != nil {
+			rows.Close()
+			return err
+		}
+		taskIDs = append(taskIDs, taskID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, taskID := range taskIDs {
+		if err := assignTask(db, actor.WorkspaceID, taskID, nil, actor.UserID); err != nil {
+			return err
+		}
+	}
+	query := `DELETE FROM task_watcher WHERE user_id = $1 AND task_id IN (SELECT task_id FROM "task" WHERE workspace_id = $2)`
+	_, err = db.Exec(query, userID, actor.WorkspaceID)
+	return err
+}
+
+// Function to read the usernames of everyone who created or is assigned one
+// of the given tasks, keyed by user ID
+func loadTaskUsers(db queryer, workspaceID int, taskIDs []int) (map[int]string, error) {
+	args := []interface{}{workspaceID}
+	ids := idList(&args, taskIDs)
+	query := `
+	SELECT user_id, username FROM "user" WHERE user_id IN (
+		SELECT user_id FROM "task" WHERE workspace_id = $1 AND task_id IN (` + ids + `)
+		UNION SELECT assignee_id FROM "task" WHERE workspace_id = $1 AND task_id IN (` + ids + `))`
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	users := make(map[int]string)
+	for rows.Next() {
+		var userID int
+		var username string
+		if err := rows.Scan(&userID, &username); err != nil {
+			return nil, err
+		}
+		users[userID] = username
+	}
+	return users, rows.Err()
+}
+
+// Function to read the watchers of the given tasks, keyed by task ID
+func loadWatchers(db queryer, workspaceID int, taskIDs []int) (map[int][]string, error) {
+	args := []interface{}{workspaceID}
+	query := `
+	SELECT w.task_id, u.username FROM task_watcher w
+	JOIN "task" t ON t.task_id = w.task_id JOIN "user" u ON u.user_id = w.user_id
+	WHERE t.workspace_id = $1 AND w.task_id IN (` + idList(&args, taskIDs) + `) ORDER BY u.username`
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	watchers := make(map[int][]string)
+	for rows.Next() {
+		var taskID int
+		var username string
+		if err := rows.Scan(&taskID, &username); err != nil {
+			return nil, err
+		}
+		watchers[taskID] = append(watchers[taskID], username)
+	}
+	return watchers, rows.Err()
+}
+
+// Function to build the SQL conditions for the filter's assignee and creator
+func (f TaskFilter) peopleCondition(args *[]interface{}) string {
+	var condition string
+	if f.AssigneeID != 0 {
+		*args = append(*args, f.AssigneeID)
+		condition += ` AND assignee_id = ` + placeholder(*args)
+	}
+	if f.CreatorID != 0 {
+		*args = append(*args, f.CreatorID)
+		condition += ` AND user_id = ` + placeholder(*args)
+	}
+	return condition
+}
+
+// Assignments returns who the task has been assigned to, oldest first
+func (s *sqlTaskStore) Assignments(actor Actor, taskID int) ([]Assignment, error) {
+	db := s.router.Reader(actor.UserID)
+	if _, err := requireRole(db, actor, RoleViewer); err != nil {
+		return nil, err
+	}
+	if _, err := s.get(db, actor.WorkspaceID, taskID); err != nil {
+		return nil, err
+	}
+	query := `
+	SELECT a.assigned_at, u.username, b.username FROM task_assignment a
+	LEFT JOIN "user" u ON u.user_id = a.assignee_id LEFT JOIN "user" b ON b.user_id = a.assigned_by
+	WHERE a.task_id = $1 ORDER BY a.assignment_id`
+	rows, err := db.Query(query, taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var assignments []Assignment
+	for rows.Next() {
+		var assignment Assignment
+		var assignee, assignedBy sql.NullString
+		if err := rows.Scan(&assignment.AssignedAt, &assignee, &assignedBy); err != nil {
+			return nil, err
+		}
+		assignment.Assignee, assignment.AssignedBy = assignee.String, assignedBy.String
+		assignments = append(assignments, assignment)
+	}
+	return assignments, rows.Err()
+}
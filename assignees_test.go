@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestAssignees(t *testing.T) {
+	router := newTestRouter(t)
+	store := newSQLTaskStore(router, 0)
+	ownerID, workspaceID := newTestUser(t, router, "carol")
+	daveID, _ := newTestUser(t, router, "dave")
+	viewerID, _ := newTestUser(t, router, "vera")
+	newTestUser(t, router, "eve") // not in the workspace
+	owner := Actor{UserID: ownerID, WorkspaceID: workspaceID}
+	dave := Actor{UserID: daveID, WorkspaceID: workspaceID}
+	viewer := Actor{UserID: viewerID, WorkspaceID: workspaceID}
+	if err := store.AddMember(owner, "dave", RoleMember); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddMember(owner, "vera", RoleViewer); err != nil {
+		t.Fatal(err)
+	}
+
+	var validationErr *ValidationError
+	if _, err := store.Create(owner, Task{Title: "Audit", Assignee: "eve"}); !errors.As(err, &validationErr) || validationErr.Field != "assignee" {
+		t.Errorf("assigning an outsider = %v; want a validation error for assignee", err)
+	}
+	task, err := store.Create(owner, Task{Title: "Audit", Assignee: "dave", Watchers: []string{"vera"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Creator != "carol" || task.Assignee != "dave" || !reflect.DeepEqual(task.Watchers, []string{"vera"}) {
+		t.Errorf("task by %q for %q watched by %q; want by carol for dave watched by vera", task.Creator, task.Assignee, task.Watchers)
+	}
+	if _, err := store.Create(dave, Task{Title: "Lunch"}); err != nil {
+		t.Fatal(err)
+	}
+
+	list := func(filter TaskFilter) []string {
+		t.Helper()
+		tasks, err := store.List(owner, filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return taskTitles(tasks)
+	}
+	if got := list(TaskFilter{AssigneeID: daveID}); !reflect.DeepEqual(got, []string{"Audit"}) {
+		t.Errorf("assigned to dave = %q; want [Audit]", got)
+	}
+	if got := list(TaskFilter{CreatorID: daveID}); !reflect.DeepEqual(got, []string{"Lunch"}) {
+		t.Errorf("created by dave = %q; want [Lunch]", got)
+	}
+
+	// Viewers may only update the tasks assigned to them
+	title := "Audit the books"
+	if _, err := store.Update(viewer, task.ID, TaskUpdate{Title: &title}); !errors.Is(err, ErrForbidden) {
+		t.Errorf("a viewer updating someone else's task = %v; want %v", err, ErrForbidden)
+	}
+	vera := "vera"
+	if _, err := store.Update(dave, task.ID, TaskUpdate{Assignee: &vera, RemoveWatchers: []string{"vera"}, AddWatchers: []string{"carol"}}); err != nil {
+		t.Fatal(err)
+	}
+	updated, err := store.Update(viewer, task.ID, TaskUpdate{Title: &title})
+	if err != nil {
+		t.Fatal("the assignee updating their task:", err)
+	}
+	if !reflect.DeepEqual(updated.Watchers, []string{"carol"}) {
+		t.Errorf("watchers = %q; want [carol]", updated.Watchers)
+	}
+
+	// Removing a member unassigns their tasks
+	if err := store.RemoveMember(owner, "vera"); err != nil {
+		t.Fatal(err)
+	}
+	if got := getTask(t, store, owner, task.ID); got.AssigneeID != nil {
+		t.Errorf("task still assigned to %q after they left", got.Assignee)
+	}
+
+	assignments, err := store.Assignments(owner, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var history [][2]string
+	for _, a := range assignments {
+		history = append(history, [2]string{a.Assignee, a.AssignedBy})
+	}
+	want := [][2]string{{"dave", "carol"}, {"vera", "dave"}, {"", "carol"}}
+	if !reflect.DeepEqual(history, want) {
+		t.Errorf("assignments (assignee, by) = %q; want %q", history, want)
+	}
+}
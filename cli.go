@@ -0,0 +1,1713 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// cli runs one non-interactive subcommand such as "task add" or "login" and
+// exits. A successful "login" saves the session token to tokenFile so that
+// later commands run without prompting; TMS_TOKEN overrides the saved token.
+type cli struct {
+	router    *dbRouter
+	tasks     TaskStore
+	sessions  *sessionStore
+	limiter   *loginLimiter
+	resets    *resetStore
+	tokenFile string
+	out       io.Writer
+}
+
+// errUsage marks errors caused by how the command was invoked
+var errUsage = errors.New("usage")
+
+const cliUsage = `Usage: tms [global flags] <command>
+
+Commands:
+  (none)                       start the interactive menu
+  serve                        run the REST API
+  migrate up|down|status       manage the database schema
+  unlock-source <address>      let an IP address locked out by failed logins try again now;
+                               for whoever runs the server, so it needs no login
+  unlock-user <username>       let a username locked out by failed logins try again now;
+                               also for whoever runs the server
+  login [--username U] [--password-stdin] [--code C]
+                               --code is the two-factor code, prompted for if needed
+  logout
+  password forgot <username>   send a password reset code through the configured notifier
+  password reset <username> [--code C] [--password-stdin]
+                               set a new password with the code, answering your security
+                               questions if they are on; logs out all sessions
+  account show [--json]        show your username and email address
+  account email <address|none> where password reset codes are sent
+  account questions [--json]   list the security questions, marking the ones you picked
+  account set-questions        pick new security questions and answer them (prompted)
+  account password [--password-stdin]
+                               change your password (stdin: current, then new, one line each);
+                               logs out your other sessions
+  account rename <username>    change your username
+  account delete [--yes] [--password-stdin]
+                               delete your account for good, with the tasks and projects
+                               you created
+  2fa status [--json]          show whether two-factor authentication is on for your account
+  2fa enable [--code C]        set up an authenticator app, then print your recovery codes
+  2fa disable [--code C] [--leave]
+                               takes a code from the app or a recovery code; --leave first
+                               leaves the workspaces that require two-factor authentication
+  task add --title T [--description D] [--status S] [--priority P] [--due DATE] [--tags a,b]
+           [--parent ID] [--auto-complete] [--blocked-by ID,...] [--repeat RULE] [--project NAME]
+           [--assignee USER] [--watchers u,v] [--json]
+  task list [--tag a,b] [--match any|all] [--project NAME] [--assigned-to-me] [--created-by-me]
+            [--ready | --order] [--json]
+  task show <id> [--json]      with its comments unless --json
+  task assignments <id> [--json]
+                               show who the task has been assigned to over time
+  task update <id> [--title T] [--description D] [--status S] [--priority P] [--due DATE]
+              [--add-tags a,b] [--remove-tags a,b] [--parent ID|none] [--auto-complete true|false]
+              [--add-blocker ID,...] [--remove-blocker ID,...] [--repeat RULE|none] [--project NAME|none]
+              [--assign USER|none] [--add-watchers u,v] [--remove-watchers u,v] [--json]
+  task done <id> [--json]      a recurring task schedules its next occurrence
+  task skip <id> [--json]      move a recurring task on to its next occurrence
+  task delete <id> [--subtasks cascade|promote]
+                               move the task to the trash
+  trash list [--json]          show the deleted tasks and when each will be purged
+  trash restore <id> [--json]  take a task out of the trash with the subtasks deleted with it
+  history <task-id> [--json]   show every change to the task, even after it was deleted
+  comment list <task-id> [--json]
+  comment add <task-id> <text>
+  comment edit <comment-id> <text>
+                               only your own comments can be edited or deleted
+  comment delete <comment-id>
+  status list [--json]         show the statuses and allowed changes
+  status add <name> [--done]
+  status remove <name>
+  status allow <from> <to>
+  status forbid <from> <to>
+  status reset                 go back to the default statuses
+  tag list [--json]            show the tags and how many tasks use each
+  tag rename <old> <new>
+  tag merge <tag>... --into <tag>
+  tag delete <name>            remove the tag from every task
+  project list [--archived] [--json]
+                               show the projects with their open and complete tasks
+  project add <name>
+  project rename <old> <new>
+  project archive <name>       hide the project and its tasks from task lists
+  project unarchive <name>
+  project delete <name> [--with-tasks]
+                               --with-tasks moves its tasks to the trash; without it they
+                               are kept outside any project
+  workspace list [--json]      show your workspaces and your role in each
+  workspace add <name>
+  workspace use <id>           work in another workspace from now on
+  workspace rename <name>      rename the current workspace
+  workspace delete             delete the current workspace with all its tasks
+  workspace members [--json]   show who is in the current workspace
+  workspace add-member <username> [--role viewer|member|admin|owner]
+  workspace set-role <username> <role>
+  workspace remove-member <username>
+  workspace leave
+  workspace require-2fa on|off
+                               only let members with two-factor authentication work in it
+
+Task, trash, status, tag and project commands act on the current workspace. Roles:
+viewers read, update tasks assigned to them and watch tasks; members also
+add, assign and update tasks and delete and restore their own;
+admins also delete and restore any task, manage statuses, tags, projects and members
+and require two-factor authentication;
+owners also manage owners and rename or delete the workspace.
+
+Run "tms -h" for the global flags.`
+
+// Function to run a subcommand and return the process exit code
+func (c *cli) run(args []string) int {
+	err := c.dispatch(args)
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, flag.ErrHelp):
+		fmt.Fprintln(os.Stderr, cliUsage)
+		return 0
+	case errors.Is(err, errUsage):
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		fmt.Fprintln(os.Stderr, cliUsage)
+		return 2
+	default:
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+}
+
+func (c *cli) dispatch(args []string) error {
+	switch args[0] {
+	case "help":
+		return flag.ErrHelp
+	case "login":
+		return c.login(args[1:])
+	case "logout":
+		return c.logout(args[1:])
+	case "password":
+		if len(args) < 2 {
+			return fmt.Errorf("%w: password needs a subcommand", errUsage)
+		}
+		return c.password(args[1], args[2:])
+	case "account":
+		if len(args) < 2 {
+			return fmt.Errorf("%w: account needs a subcommand", errUsage)
+		}
+		return c.account(args[1], args[2:])
+	case "2fa":
+		if len(args) < 2 {
+			return fmt.Errorf("%w: 2fa needs a subcommand", errUsage)
+		}
+		return c.twoFactor(args[1], args[2:])
+	case "task":
+		if len(args) < 2 {
+			return fmt.Errorf("%w: task needs a subcommand", errUsage)
+		}
+		switch args[1] {
+		case "add":
+			return c.taskAdd(args[2:])
+		case "list":
+			return c.taskList(args[2:])
+		case "show":
+			return c.taskShow(args[2:])
+		case "assignments":
+			return c.taskAssignments(args[2:])
+		case "update":
+			return c.taskUpdate(args[2:], nil)
+		case "done":
+			return c.taskDone(args[2:])
+		case "skip":
+			return c.taskSkip(args[2:])
+		case "delete":
+			return c.taskDelete(args[2:])
+		}
+		return fmt.Errorf("%w: unknown task subcommand %q", errUsage, args[1])
+	case "history":
+		return c.history(args[1:])
+	case "trash":
+		if len(args) < 2 {
+			return fmt.Errorf("%w: trash needs a subcommand", errUsage)
+		}
+		return c.trash(args[1], args[2:])
+	case "comment":
+		if len(args) < 2 {
+			return fmt.Errorf("%w: comment needs a subcommand", errUsage)
+		}
+		return c.comment(args[1], args[2:])
+	case "status":
+		if len(args) < 2 {
+			return fmt.Errorf("%w: status needs a subcommand", errUsage)
+		}
+		return c.status(args[1], args[2:])
+	case "tag":
+		if len(args) < 2 {
+			return fmt.Errorf("%w: tag needs a subcommand", errUsage)
+		}
+		return c.tag(args[1], args[2:])
+	case "project":
+		if len(args) < 2 {
+			return fmt.Errorf("%w: project needs a subcommand", errUsage)
+		}
+		return c.project(args[1], args[2:])
+	case "workspace":
+		if len(args) < 2 {
+			return fmt.Errorf("%w: workspace needs a subcommand", errUsage)
+		}
+		return c.workspace(args[1], args[2:])
+	case "unlock-source":
+		if len(args) != 2 {
+			return fmt.Errorf("%w: unlock-source takes an address", errUsage)
+		}
+		if err := c.limiter.UnlockSource(args[1]); err != nil {
+			return err
+		}
+		fmt.Fprintf(c.out, "Unlocked %s.\n", args[1])
+		return nil
+	case "unlock-user":
+		if len(args) != 2 {
+			return fmt.Errorf("%w: unlock-user takes a username", errUsage)
+		}
+		if err := c.limiter.UnlockUser(args[1]); err != nil {
+			return err
+		}
+		fmt.Fprintf(c.out, "Unlocked %s.\n", args[1])
+		return nil
+	}
+	return fmt.Errorf("%w: unknown command %q", errUsage, args[0])
+}
+
+// Helper function to parse a subcommand's flags, allowing them before or after positional arguments
+func parseCommandFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	fs.SetOutput(io.Discard)
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			if err == flag.ErrHelp {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%w: %v", errUsage, err)
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// Helper function to parse the single <id> argument of a task subcommand
+func parseTaskID(positional []string) (int, error) {
+	if len(positional) != 1 {
+		return 0, fmt.Errorf("%w: expected exactly one task id", errUsage)
+	}
+	taskID, err := strconv.Atoi(positional[0])
+	if err != nil || taskID <= 0 {
+		return 0, fmt.Errorf("%w: task id must be a positive integer, got %q", errUsage, positional[0])
+	}
+	return taskID, nil
+}
+
+// Helper function to print a value as indented JSON
+func (c *cli) printJSON(v interface{}) error {
+	encoder := json.NewEncoder(c.out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// Helper function to print tasks as a table, as a tree of subtasks unless the order matters
+func (c *cli) printTasks(tasks []Task, asTree bool) {
+	now := time.Now()
+	var rows []treeTask
+	if asTree {
+		rows = taskTree(tasks)
+	} else {
+		for _, task := range tasks {
+			rows = append(rows, treeTask{Task: task})
+		}
+	}
+	w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATUS\tPRIORITY\tDUE\t\tPROJECT\tASSIGNEE\tTAGS\tTITLE")
+	for _, task := range rows {
+		// Indent subtasks under their parent and show how far along parents are
+		title := strings.Repeat("  ", task.Depth) + task.Title
+		if task.Progress != nil {
+			title += " [" + task.Progress.String() + "]"
+		}
+		if task.Blocked {
+			title += " (blocked by " + joinTaskIDs(task.BlockedBy) + ")"
+		}
+		if task.Recurrence != "" {
+			title += " (repeats)"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", task.ID, task.Status, task.Priority, formatDue(task.DueAt), dueFlag(task.Task, now),
+			task.Project, task.Assignee, strings.Join(task.Tags, ","), title)
+	}
+	w.Flush()
+}
+
+// Helper function to print one task, as JSON or as text
+func (c *cli) printTask(task Task, asJSON bool) error {
+	if asJSON {
+		return c.printJSON(task)
+	}
+	due := formatDue(task.DueAt)
+	if state := dueFlag(task, time.Now()); state != "" {
+		due += " [" + state + "]"
+	}
+	fmt.Fprintf(c.out, "ID: %d\nTITLE: %s\nDESCRIPTION: %s\nSTATUS: %s\nPRIORITY: %s\nDUE: %s\nTAGS: %s\nCREATED: %s\nUPDATED: %s\n",
+		task.ID, task.Title, task.Description, task.Status, task.Priority, due, strings.Join(task.Tags, ", "), formatTime(task.CreatedAt), formatTime(task.UpdatedAt))
+	fmt.Fprintf(c.out, "CREATED BY: %s\n", task.Creator)
+	if task.Assignee != "" {
+		fmt.Fprintf(c.out, "ASSIGNED TO: %s\n", task.Assignee)
+	}
+	if len(task.Watchers) > 0 {
+		fmt.Fprintf(c.out, "WATCHERS: %s\n", strings.Join(task.Watchers, ", "))
+	}
+	if task.Project != "" {
+		fmt.Fprintf(c.out, "PROJECT: %s\n", task.Project)
+	}
+	if task.ParentID != nil {
+		fmt.Fprintf(c.out, "PARENT: %d\n", *task.ParentID)
+	}
+	if task.Progress != nil {
+		fmt.Fprintf(c.out, "SUBTASKS: %s (auto-complete: %t)\n", task.Progress, task.AutoComplete)
+	}
+	if len(task.BlockedBy) > 0 {
+		fmt.Fprintf(c.out, "BLOCKED BY: %s (blocked: %t)\n", joinTaskIDs(task.BlockedBy), task.Blocked)
+	}
+	if task.Recurrence != "" {
+		fmt.Fprintf(c.out, "REPEATS: %s (occurrence %d)\n", task.Recurrence, task.Occurrence)
+	}
+	if task.NextTaskID != nil {
+		fmt.Fprintf(c.out, "NEXT OCCURRENCE: task %d\n", *task.NextTaskID)
+	}
+	return nil
+}
+
+// Function to get the saved session token, or TMS_TOKEN if it is set
+func (c *cli) savedToken() string {
+	if token := os.Getenv("TMS_TOKEN"); token != "" {
+		return token
+	}
+	data, err := os.ReadFile(c.tokenFile)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// Function to get the user of the saved session working in the session's
+// workspace, failing if there is no session
+func (c *cli) currentActor() (Actor, error) {
+	token := c.savedToken()
+	if token == "" {
+		return Actor{}, errors.New(`not logged in; run "tms login" first`)
+	}
+	userID, ok := isValidToken(c.sessions, token)
+	if !ok {
+		return Actor{}, errors.New(`session expired or revoked; run "tms login" again`)
+	}
+	return c.sessions.Actor(token, userID)
+}
+
+func (c *cli) login(args []string) error {
+	fs := flag.NewFlagSet("login", flag.ContinueOnError)
+	username := fs.String("username", "", "account to log in as (prompted if omitted)")
+	passwordStdin := fs.Bool("password-stdin", false, "read the password from the first line of stdin")
+	code := fs.String("code", "", "two-factor code or recovery code (prompted if needed and omitted)")
+	if _, err := parseCommandFlags(fs, args); err != nil {
+		return err
+	}
+
+	if *username == "" {
+		if *passwordStdin {
+			return fmt.Errorf("%w: --password-stdin needs --username", errUsage)
+		}
+		fmt.Fprint(os.Stderr, "Enter username: ")
+		input, err := stdin.ReadString('\n')
+		if err != nil && input == "" {
+			return fmt.Errorf("reading username: %w", err)
+		}
+		*username = sanitizeInput(input)
+	}
+	if !*passwordStdin {
+		fmt.Fprint(os.Stderr, "Enter password: ")
+	}
+	password, err := stdin.ReadString('\n')
+	if err != nil && password == "" {
+		return fmt.Errorf("reading password: %w", err)
+	}
+	password = sanitizeInput(password)
+
+	var userID int
+	err = c.limiter.Attempt(*username, terminalSource, func() error {
+		var err error
+		if userID, err = authenticate(c.router, *username, password); err != nil {
+			return err
+		}
+		err = checkSecondFactor(c.router, userID, *code)
+		if err == ErrSecondFactorRequired {
+			if *code, err = c.promptCode("Enter the code from your authenticator app (or a recovery code): "); err != nil {
+				return err
+			}
+			err = checkSecondFactor(c.router, userID, *code)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	authToken, err := c.sessions.Create(userID)
+	if err != nil {
+		return fmt.Errorf("creating session: %w", err)
+	}
+
+	// Only the owner may read the token file
+	if err := os.MkdirAll(filepath.Dir(c.tokenFile), 0o700); err != nil {
+		return fmt.Errorf("saving session: %w", err)
+	}
+	if err := os.WriteFile(c.tokenFile, []byte(authToken.Token+"\n"), 0o600); err != nil {
+		return fmt.Errorf("saving session: %w", err)
+	}
+	fmt.Fprintf(c.out, "Logged in as %s until %s (renewed while in use).\n", *username, formatTime(authToken.ExpiresAt.Local()))
+	notices, err := takeNotices(c.router, userID)
+	if err != nil {
+		return fmt.Errorf("loading notices: %w", err)
+	}
+	for _, notice := range notices {
+		fmt.Fprintln(os.Stderr, "Notice:", notice)
+	}
+	return nil
+}
+
+func (c *cli) logout(args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("%w: logout takes no arguments", errUsage)
+	}
+	if token := c.savedToken(); token != "" {
+		if err := c.sessions.Revoke(token); err != nil {
+			return fmt.Errorf("revoking session: %w", err)
+		}
+	}
+	if err := os.Remove(c.tokenFile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	fmt.Fprintln(c.out, "Logged out.")
+	return nil
+}
+
+// Function to handle the "password" subcommands that reset a forgotten password
+func (c *cli) password(command string, args []string) error {
+	fs := flag.NewFlagSet("password "+command, flag.ContinueOnError)
+	code := fs.String("code", "", "reset code from the message (prompted if omitted)")
+	passwordStdin := fs.Bool("password-stdin", false, "read the new password from the first line of stdin")
+	positional, err := parseCommandFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("%w: password %s takes a username", errUsage, command)
+	}
+	username := positional[0]
+	if err := c.limiter.Check(username, terminalSource); err != nil {
+		return err
+	}
+
+	switch command {
+	case "forgot":
+		err := c.limiter.Count(username, terminalSource, func() error {
+			return c.resets.Request(username)
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(c.out, "If %s exists, a reset code is on its way to it; then run \"tms password reset %s\".\n", username, username)
+		return nil
+	case "reset":
+		if *code == "" {
+			if *passwordStdin {
+				return fmt.Errorf("%w: --password-stdin needs --code", errUsage)
+			}
+			fmt.Fprint(os.Stderr, "Enter the reset code: ")
+			input, err := stdin.ReadString('\n')
+			if err != nil && input == "" {
+				return fmt.Errorf("reading reset code: %w", err)
+			}
+			*code = sanitizeInput(input)
+		}
+		var questions []SecurityQuestion
+		err := c.limiter.AttemptStep(username, terminalSource, func() error {
+			var err error
+			questions, err = c.resets.Questions(username, *code)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		// With --password-stdin, the answers come first, one line each
+		var answers []SecurityAnswer
+		for _, question := range questions {
+			fmt.Fprintf(os.Stderr, "%s ", question.Text)
+			answer, err := stdin.ReadString('\n')
+			if err != nil && answer == "" {
+				return fmt.Errorf("reading answer: %w", err)
+			}
+			answers = append(answers, SecurityAnswer{QuestionID: question.ID, Answer: answer})
+		}
+		if !*passwordStdin {
+			fmt.Fprint(os.Stderr, "Enter a new password: ")
+		}
+		newPassword, err := stdin.ReadString('\n')
+		if err != nil && newPassword == "" {
+			return fmt.Errorf("reading password: %w", err)
+		}
+		err = c.limiter.Attempt(username, terminalSource, func() error {
+			return c.resets.Reset(username, *code, answers, sanitizeInput(newPassword))
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(c.out, `Password reset; log in again with "tms login".`)
+		return nil
+	}
+	return fmt.Errorf("%w: unknown password subcommand %q", errUsage, command)
+}
+
+// Function to handle the "account" subcommands for the logged-in user's own account
+func (c *cli) account(command string, args []string) error {
+	fs := flag.NewFlagSet("account "+command, flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print as JSON")
+	passwordStdin := fs.Bool("password-stdin", false, "read passwords from stdin without prompting")
+	yes := fs.Bool("yes", false, "delete without asking for confirmation")
+	positional, err := parseCommandFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	wantArgs := map[string]int{"show": 0, "email": 1, "questions": 0, "set-questions": 0, "password": 0, "rename": 1, "delete": 0}
+	n, known := wantArgs[command]
+	if !known {
+		return fmt.Errorf("%w: unknown account subcommand %q", errUsage, command)
+	}
+	if len(positional) != n {
+		return fmt.Errorf("%w: account %s takes %d argument(s)", errUsage, command, n)
+	}
+	actor, err := c.currentActor()
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case "show":
+		account, err := loadAccount(c.router, actor.UserID)
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			return c.printJSON(account)
+		}
+		email := account.Email
+		if email == "" {
+			email = "(none)"
+		}
+		fmt.Fprintf(c.out, "USERNAME: %s\nEMAIL: %s\n", account.Username, email)
+		return nil
+	case "email":
+		email := positional[0]
+		if email == "none" {
+			email = ""
+		}
+		if err := setEmail(c.router, actor.UserID, email); err != nil {
+			return err
+		}
+	case "questions":
+		return c.listSecurityQuestions(actor.UserID, *asJSON)
+	case "set-questions":
+		if err := c.setSecurityQuestions(actor.UserID); err != nil {
+			return err
+		}
+	case "password":
+		currentPassword, err := c.promptPassword("Enter your current password: ", *passwordStdin)
+		if err != nil {
+			return err
+		}
+		newPassword, err := c.promptPassword("Enter a new password: ", *passwordStdin)
+		if err != nil {
+			return err
+		}
+		err = c.limiter.AttemptAs(actor.UserID, terminalSource, func() error {
+			return changePassword(c.router, actor.UserID, c.savedToken(), currentPassword, newPassword)
+		})
+		if err == ErrInvalidCredentials {
+			return errors.New("wrong password")
+		} else if err != nil {
+			return err
+		}
+		fmt.Fprintln(c.out, "Password changed; your other sessions have been logged out.")
+		return nil
+	case "rename":
+		if err := changeUsername(c.router, actor.UserID, positional[0]); err != nil {
+			return err
+		}
+	case "delete":
+		return c.deleteAccount(actor.UserID, *yes, *passwordStdin)
+	}
+	fmt.Fprintln(c.out, "Account updated.")
+	return nil
+}
+
+// Helper function to confirm and delete the logged-in user's account, then forget the saved token
+func (c *cli) deleteAccount(userID int, yes, passwordStdin bool) error {
+	if !yes {
+		if passwordStdin {
+			return fmt.Errorf("%w: --password-stdin needs --yes", errUsage)
+		}
+		fmt.Fprint(os.Stderr, "Delete your account for good? (Y/N): ")
+		confirm, err := stdin.ReadString('\n')
+		if err != nil && confirm == "" {
+			return fmt.Errorf("reading confirmation: %w", err)
+		}
+		if strings.ToUpper(sanitizeInput(confirm)) != "Y" {
+			fmt.Fprintln(c.out, "Account deletion cancelled.")
+			return nil
+		}
+	}
+	password, err := c.promptPassword("Enter your password to confirm: ", passwordStdin)
+	if err != nil {
+		return err
+	}
+	err = c.limiter.AttemptAs(userID, terminalSource, func() error {
+		return c.tasks.DeleteAccount(userID, password)
+	})
+	if err == ErrInvalidCredentials {
+		return errors.New("wrong password")
+	} else if err != nil {
+		return err
+	}
+	if err := os.Remove(c.tokenFile); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("removing token file: %w", err)
+	}
+	fmt.Fprintln(c.out, "Account deleted.")
+	return nil
+}
+
+// Helper function to read a password from stdin, prompting on stderr unless
+// it comes from --password-stdin
+func (c *cli) promptPassword(prompt string, fromStdin bool) (string, error) {
+	if !fromStdin {
+		fmt.Fprint(os.Stderr, prompt)
+	}
+	input, err := stdin.ReadString('\n')
+	if err != nil && input == "" {
+		return "", fmt.Errorf("reading password: %w", err)
+	}
+	return sanitizeInput(input), nil
+}
+
+// Helper function to list the question catalog, marking the questions the user picked
+func (c *cli) listSecurityQuestions(userID int, asJSON bool) error {
+	db := c.router.Reader(userID)
+	catalog, err := questionCatalog(db)
+	if err != nil {
+		return err
+	}
+	chosen, err := chosenQuestions(db, userID)
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		if chosen == nil {
+			chosen = []SecurityQuestion{}
+		}
+		return c.printJSON(map[string]interface{}{"catalog": catalog, "chosen": chosen})
+	}
+	picked := make(map[int]bool, len(chosen))
+	for _, question := range chosen {
+		picked[question.ID] = true
+	}
+	w := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tPICKED\tQUESTION")
+	for _, question := range catalog {
+		mark := ""
+		if picked[question.ID] {
+			mark = "*"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", question.ID, mark, question.Text)
+	}
+	return w.Flush()
+}
+
+// Helper function to prompt for the current password and new security
+// questions with their answers, then save them
+func (c *cli) setSecurityQuestions(userID int) error {
+	if c.resets.questions == 0 {
+		return ErrQuestionsOff
+	}
+	password, err := c.promptPassword("Enter your current password: ", false)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Pick %d questions by ID from \"tms account questions\".\n", c.resets.questions)
+	var answers []SecurityAnswer
+	for len(answers) < c.resets.questions {
+		fmt.Fprintf(os.Stderr, "Question %d of %d: ", len(answers)+1, c.resets.questions)
+		input, err := stdin.ReadString('\n')
+		if err != nil && input == "" {
+			return fmt.Errorf("reading question: %w", err)
+		}
+		questionID, err := strconv.Atoi(sanitizeInput(input))
+		if err != nil {
+			return fmt.Errorf("%w: %q is not a question ID", errUsage, sanitizeInput(input))
+		}
+		fmt.Fprint(os.Stderr, "Answer: ")
+		answer, err := stdin.ReadString('\n')
+		if err != nil && answer == "" {
+			return fmt.Errorf("reading answer: %w", err)
+		}
+		answers = append(answers, SecurityAnswer{QuestionID: questionID, Answer: answer})
+	}
+	err = c.limiter.AttemptAs(userID, terminalSource, func() error {
+		return c.resets.SetAnswers(userID, password, answers)
+	})
+	if err == ErrInvalidCredentials {
+		return errors.New("wrong password")
+	}
+	return err
+}
+
+// Function to handle the "2fa" subcommands that manage two-factor authentication for the logged-in user
+func (c *cli) twoFactor(command string, args []string) error {
+	fs := flag.NewFlagSet("2fa "+command, flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print as JSON")
+	code := fs.String("code", "", "code from your authenticator app (prompted if omitted)")
+	leave := fs.Bool("leave", false, "leave the workspaces that require two-factor authentication")
+	positional, err := parseCommandFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 0 {
+		return fmt.Errorf("%w: 2fa %s takes no arguments", errUsage, command)
+	}
+	actor, err := c.currentActor()
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case "status":
+		status, err := twoFactorStatus(c.router, actor.UserID)
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			return c.printJSON(status)
+		}
+		if !status.Enabled {
+			fmt.Fprintln(c.out, `Two-factor authentication is off; turn it on with "tms 2fa enable".`)
+			return nil
+		}
+		fmt.Fprintf(c.out, "Two-factor authentication is on, with %d recovery codes left.\n", status.RecoveryCodesLeft)
+		return nil
+	case "enable":
+		setup, err := startTwoFactor(c.router, actor.UserID)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(c.out, "Add this account to your authenticator app with the secret key")
+		fmt.Fprintf(c.out, "  %s\n", setup.Secret)
+		fmt.Fprintln(c.out, "or by turning this URI into a QR code:")
+		fmt.Fprintf(c.out, "  %s\n", setup.URI)
+		if *code == "" {
+			if *code, err = c.promptCode("Enter the code the app shows to finish: "); err != nil {
+				return err
+			}
+		}
+		codes, err := confirmTwoFactor(c.router, actor.UserID, *code)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(c.out, "Two-factor authentication is on. Keep these recovery codes somewhere safe;")
+		fmt.Fprintln(c.out, "each logs you in once if you lose your authenticator app:")
+		for _, recoveryCode := range codes {
+			fmt.Fprintf(c.out, "  %s\n", recoveryCode)
+		}
+		return nil
+	case "disable":
+		if *code == "" {
+			if *code, err = c.promptCode("Enter the code from your authenticator app (or a recovery code): "); err != nil {
+				return err
+			}
+		}
+		for {
+			err := c.limiter.AttemptAs(actor.UserID, terminalSource, func() error {
+				return disableTwoFactor(c.router, actor.UserID, *code)
+			})
+			var required *WorkspaceTwoFactorError
+			if !errors.As(err, &required) {
+				if err != nil {
+					return err
+				}
+				break
+			}
+			if !*leave {
+				return fmt.Errorf(`%w, or run "tms 2fa disable --leave"`, err)
+			}
+			if err := c.tasks.LeaveWorkspace(Actor{UserID: actor.UserID, WorkspaceID: required.WorkspaceID}); err != nil {
+				return err
+			}
+			fmt.Fprintf(c.out, "Left workspace %d (%s).\n", required.WorkspaceID, required.Name)
+		}
+		fmt.Fprintln(c.out, "Two-factor authentication is off.")
+		return nil
+	}
+	return fmt.Errorf("%w: unknown 2fa subcommand %q", errUsage, command)
+}
+
+// Helper function to prompt on stderr for a two-factor code and read it from stdin
+func (c *cli) promptCode(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	input, err := stdin.ReadString('\n')
+	if err != nil && input == "" {
+		return "", fmt.Errorf("reading two-factor code: %w", err)
+	}
+	return sanitizeInput(input), nil
+}
+
+func (c *cli) taskAdd(args []string) error {
+	fs := flag.NewFlagSet("task add", flag.ContinueOnError)
+	title := fs.String("title", "", "task title (required)")
+	description := fs.String("description", "", "task description")
+	status := fs.String("status", "", "one of your statuses (default: the first one)")
+	priority := defaultPriority
+	fs.Func("priority", "low, medium, high or urgent (default medium)", func(v string) (err error) {
+		priority, err = ParsePriority(v)
+		return err
+	})
+	var dueAt *time.Time
+	fs.Func("due", "due date: YYYY-MM-DD [HH:MM] [Area/City] or RFC 3339", func(v string) (err error) {
+		dueAt, err = ParseDue(v)
+		return err
+	})
+	tags := fs.String("tags", "", "comma-separated tags")
+	var parentID *int
+	fs.Func("parent", "make the task a subtask of this task ID", func(v string) error {
+		id, err := strconv.Atoi(v)
+		parentID = &id
+		return err
+	})
+	autoComplete := fs.Bool("auto-complete", false, "complete the task once all its subtasks are done")
+	repeat := fs.String("repeat", "", "repeat by an RRULE (FREQ=MONTHLY;BYMONTHDAY=-1) or daily, weekdays, weekly, monthly, yearly")
+	project := fs.String("project", "", "put the task in this project (subtasks go in their parent's)")
+	assignee := fs.String("assignee", "", "assign the task to this member of the workspace")
+	watchers := fs.String("watchers", "", "comma-separated usernames of members to follow the task")
+	var blockedBy []int
+	fs.Func("blocked-by", "comma-separated IDs of tasks that must be done first", func(v string) (err error) {
+		blockedBy, err = splitTaskIDs(v)
+		return err
+	})
+	asJSON := fs.Bool("json", false, "print the created task as JSON")
+	positional, err := parseCommandFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 0 {
+		return fmt.Errorf("%w: unexpected argument %q", errUsage, positional[0])
+	}
+	if err := validateTaskFields(title, description, status); err != nil {
+		return err
+	}
+
+	actor, err := c.currentActor()
+	if err != nil {
+		return err
+	}
+	task, err := c.tasks.Create(actor, Task{Title: *title, Description: *description, Status: *status, Priority: priority, DueAt: dueAt, Tags: splitTags(*tags),
+		ParentID: parentID, AutoComplete: *autoComplete, BlockedBy: blockedBy,
+		Recurrence: *repeat, Project: *project, Assignee: sanitizeInput(*assignee), Watchers: splitUsernames(*watchers)})
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return c.printJSON(task)
+	}
+	fmt.Fprintf(c.out, "Created task %d.\n", task.ID)
+	return nil
+}
+
+func (c *cli) taskList(args []string) error {
+	fs := flag.NewFlagSet("task list", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print tasks as a JSON array")
+	var filter TaskFilter
+	fs.Func("tag", "only tasks with these comma-separated tags (repeatable)", func(v string) error {
+		filter.Tags = append(filter.Tags, splitTags(v)...)
+		return nil
+	})
+	match := fs.String("match", "any", "with several tags: any or all of them")
+	fs.StringVar(&filter.Project, "project", "", "only tasks in this project (also works for archived projects)")
+	assignedToMe := fs.Bool("assigned-to-me", false, "only tasks assigned to you")
+	createdByMe := fs.Bool("created-by-me", false, "only tasks you created")
+	ready := fs.Bool("ready", false, "only open tasks that nothing blocks")
+	order := fs.Bool("order", false, "open tasks in an order that respects their blockers")
+	positional, err := parseCommandFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 0 {
+		return fmt.Errorf("%w: unexpected argument %q", errUsage, positional[0])
+	}
+	switch *match {
+	case "any":
+	case "all":
+		filter.MatchAllTag = true
+	default:
+		return fmt.Errorf("%w: --match must be any or all, got %q", errUsage, *match)
+	}
+	if *ready && *order {
+		return fmt.Errorf("%w: --ready and --order can't be combined", errUsage)
+	}
+
+	actor, err := c.currentActor()
+	if err != nil {
+		return err
+	}
+	if *assignedToMe {
+		filter.AssigneeID = actor.UserID
+	}
+	if *createdByMe {
+		filter.CreatorID = actor.UserID
+	}
+	tasks, err := c.tasks.List(actor, filter)
+	if err != nil {
+		return err
+	}
+	sortTasksByDue(tasks)
+	if *ready {
+		tasks = readyTasks(tasks)
+	} else if *order {
+		tasks = orderByDependencies(tasks)
+	}
+	if *asJSON {
+		if tasks == nil {
+			tasks = []Task{} // print [] rather than null
+		}
+		return c.printJSON(tasks)
+	}
+	c.printTasks(tasks, !*order)
+	return nil
+}
+
+func (c *cli) taskShow(args []string) error {
+	fs := flag.NewFlagSet("task show", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print the task as JSON")
+	positional, err := parseCommandFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	taskID, err := parseTaskID(positional)
+	if err != nil {
+		return err
+	}
+
+	actor, err := c.currentActor()
+	if err != nil {
+		return err
+	}
+	task, err := c.tasks.Get(actor, taskID)
+	if err != nil {
+		return err
+	}
+	if err := c.printTask(task, *asJSON); err != nil || *asJSON {
+		return err
+	}
+	comments, err := c.tasks.Comments(actor, taskID)
+	if err != nil {
+		return err
+	}
+	if len(comments) > 0 {
+		fmt.Fprintln(c.out, "COMMENTS:")
+		printComments(c.out, comments)
+	}
+	return nil
+}
+
+// Function to show who a task has been assigned to over time
+func (c *cli) taskAssignments(args []string) error {
+	fs := flag.NewFlagSet("task assignments", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print the assignment history as JSON")
+	positional, err := parseCommandFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	taskID, err := parseTaskID(positional)
+	if err != nil {
+		return err
+	}
+
+	actor, err := c.currentActor()
+	if err != nil {
+		return err
+	}
+	assignments, err := c.tasks.Assignments(actor, taskID)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		if assignments == nil {
+			assignments = []Assignment{} // print [] rather than null
+		}
+		return c.printJSON(assignments)
+	}
+	if len(assignments) == 0 {
+		fmt.Fprintf(c.out, "Task %d has never been assigned.\n", taskID)
+		return nil
+	}
+	printAssignments(c.out, assignments)
+	return nil
+}
+
+// Function to show every recorded change to a task
+func (c *cli) history(args []string) error {
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print the history as JSON")
+	positional, err := parseCommandFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	taskID, err := parseTaskID(positional)
+	if err != nil {
+		return err
+	}
+
+	actor, err := c.currentActor()
+	if err != nil {
+		return err
+	}
+	events, err := c.tasks.History(actor, taskID)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		if events == nil {
+			events = []TaskEvent{} // print [] rather than null
+		}
+		return c.printJSON(events)
+	}
+	if len(events) == 0 {
+		fmt.Fprintf(c.out, "No changes to task %d have been recorded.\n", taskID)
+		return nil
+	}
+	printHistory(c.out, events)
+	return nil
+}
+
+// Function to handle "task update" and, with status already set, "task done"
+func (c *cli) taskUpdate(args []string, status *string) error {
+	fs := flag.NewFlagSet("task update", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print the updated task as JSON")
+	var update TaskUpdate
+	if status == nil {
+		fs.Func("title", "new task title", func(v string) error { update.Title = &v; return nil })
+		fs.Func("description", "new task description", func(v string) error { update.Description = &v; return nil })
+		fs.Func("status", "new status; must be allowed from the current one", func(v string) error { update.Status = &v; return nil })
+		fs.Func("priority", "new priority: low, medium, high or urgent", func(v string) error {
+			priority, err := ParsePriority(v)
+			update.Priority = &priority
+			return err
+		})
+		fs.Func("due", "new due date, or \"none\" to remove it", func(v string) error {
+			dueAt, err := ParseDue(v)
+			update.DueAt, update.ClearDue = dueAt, dueAt == nil
+			return err
+		})
+		fs.Func("add-tags", "comma-separated tags to add", func(v string) error {
+			update.AddTags = append(update.AddTags, splitTags(v)...)
+			return nil
+		})
+		fs.Func("remove-tags", "comma-separated tags to remove", func(v string) error {
+			update.RemoveTags = append(update.RemoveTags, splitTags(v)...)
+			return nil
+		})
+		fs.Func("parent", "move the task under this task ID, or \"none\" to make it a top-level task", func(v string) error {
+			if v == "none" {
+				update.ClearParent = true
+				return nil
+			}
+			id, err := strconv.Atoi(v)
+			update.ParentID = &id
+			return err
+		})
+		fs.Func("auto-complete", "true or false: complete the task once all its subtasks are done", func(v string) error {
+			autoComplete, err := strconv.ParseBool(v)
+			update.AutoComplete = &autoComplete
+			return err
+		})
+		fs.Func("add-blocker", "comma-separated IDs of tasks that must be done first", func(v string) error {
+			ids, err := splitTaskIDs(v)
+			update.AddBlockers = append(update.AddBlockers, ids...)
+			return err
+		})
+		fs.Func("repeat", "new recurrence rule, or \"none\" to stop repeating", func(v string) error {
+			if v == "none" {
+				v = ""
+			}
+			update.Recurrence = &v
+			return nil
+		})
+		fs.Func("project", "move the task and its subtasks to this project, or \"none\" to take them out of it", func(v string) error {
+			if v == "none" {
+				v = ""
+			}
+			update.Project = &v
+			return nil
+		})
+		fs.Func("remove-blocker", "comma-separated IDs of tasks that no longer block this one", func(v string) error {
+			ids, err := splitTaskIDs(v)
+			update.RemoveBlockers = append(update.RemoveBlockers, ids...)
+			return err
+		})
+		fs.Func("assign", "assign the task to this member, or \"none\" to unassign it", func(v string) error {
+			if v = sanitizeInput(v); v == "none" {
+				v = ""
+			}
+			update.Assignee = &v
+			return nil
+		})
+		fs.Func("add-watchers", "comma-separated usernames of members to follow the task", func(v string) error {
+			update.AddWatchers = append(update.AddWatchers, splitUsernames(v)...)
+			return nil
+		})
+		fs.Func("remove-watchers", "comma-separated usernames of members to stop following the task", func(v string) error {
+			update.RemoveWatchers = append(update.RemoveWatchers, splitUsernames(v)...)
+			return nil
+		})
+	} else {
+		update.Status = status
+	}
+	positional, err := parseCommandFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	taskID, err := parseTaskID(positional)
+	if err != nil {
+		return err
+	}
+	if update.Title == nil && update.Description == nil && update.Status == nil && update.Priority == nil && update.DueAt == nil && !update.ClearDue &&
+		len(update.AddTags) == 0 && len(update.RemoveTags) == 0 && update.ParentID == nil && !update.ClearParent && update.AutoComplete == nil &&
+		len(update.AddBlockers) == 0 && len(update.RemoveBlockers) == 0 && update.Recurrence == nil && update.Project == nil &&
+		update.Assignee == nil && len(update.AddWatchers) == 0 && len(update.RemoveWatchers) == 0 {
+		return fmt.Errorf("%w: nothing to update; pass one of the task fields to change", errUsage)
+	}
+	if err := validateTaskFields(update.Title, update.Description, update.Status); err != nil {
+		return err
+	}
+
+	actor, err := c.currentActor()
+	if err != nil {
+		return err
+	}
+	task, err := c.tasks.Update(actor, taskID, update)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return c.printJSON(task)
+	}
+	fmt.Fprintf(c.out, "Updated task %d.\n", task.ID)
+	if update.Status != nil && task.NextTaskID != nil {
+		c.printNextOccurrence(actor, *task.NextTaskID)
+	}
+	return nil
+}
+
+// Function to move a recurring task on to its next occurrence without completing it
+func (c *cli) taskSkip(args []string) error {
+	fs := flag.NewFlagSet("task skip", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print the updated task as JSON")
+	positional, err := parseCommandFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	taskID, err := parseTaskID(positional)
+	if err != nil {
+		return err
+	}
+
+	actor, err := c.currentActor()
+	if err != nil {
+		return err
+	}
+	task, err := c.tasks.Update(actor, taskID, TaskUpdate{SkipOccurrence: true})
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return c.printJSON(task)
+	}
+	fmt.Fprintf(c.out, "Skipped to occurrence %d of task %d, due %s.\n", task.Occurrence, task.ID, formatDue(task.DueAt))
+	return nil
+}
+
+// Helper function to tell the user when a recurring task's next occurrence is due
+func (c *cli) printNextOccurrence(actor Actor, nextID int) {
+	next, err := c.tasks.Get(actor, nextID)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(c.out, "Next occurrence is task %d, due %s.\n", next.ID, formatDue(next.DueAt))
+}
+
+// Function to move a task to a done status it is allowed to reach
+func (c *cli) taskDone(args []string) error {
+	fs := flag.NewFlagSet("task done", flag.ContinueOnError)
+	fs.Bool("json", false, "print the updated task as JSON")
+	positional, err := parseCommandFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	taskID, err := parseTaskID(positional)
+	if err != nil {
+		return err
+	}
+	actor, err := c.currentActor()
+	if err != nil {
+		return err
+	}
+	task, err := c.tasks.Get(actor, taskID)
+	if err != nil {
+		return err
+	}
+	workflow, err := c.tasks.Workflow(actor)
+	if err != nil {
+		return err
+	}
+	if task.Done {
+		fmt.Fprintf(c.out, "Task %d is already %s.\n", task.ID, task.Status)
+		return nil
+	}
+	done, ok := workflow.DoneStatusFrom(task.Status)
+	if !ok {
+		return fmt.Errorf("%w: no done status can be reached from %q", ErrTransitionNotAllowed, task.Status)
+	}
+	return c.taskUpdate(args, &done)
+}
+
+func (c *cli) taskDelete(args []string) error {
+	fs := flag.NewFlagSet("task delete", flag.ContinueOnError)
+	var subtasks SubtaskPolicy
+	fs.Func("subtasks", "for a task with subtasks: cascade (delete them too) or promote (move them up a level)", func(v string) (err error) {
+		subtasks, err = ParseSubtaskPolicy(v)
+		return err
+	})
+	positional, err := parseCommandFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	taskID, err := parseTaskID(positional)
+	if err != nil {
+		return err
+	}
+
+	actor, err := c.currentActor()
+	if err != nil {
+		return err
+	}
+	if err := c.tasks.Delete(actor, taskID, subtasks); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "Moved task %d to the trash.\n", taskID)
+	return nil
+}
+
+// Function to handle the "trash" subcommands that list and restore deleted tasks
+func (c *cli) trash(command string, args []string) error {
+	fs := flag.NewFlagSet("trash "+command, flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print the tasks as JSON")
+	positional, err := parseCommandFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	switch {
+	case command == "list" && len(positional) != 0:
+		return fmt.Errorf("%w: wrong number of arguments for trash %s", errUsage, command)
+	case command != "list" && command != "restore":
+		return fmt.Errorf("%w: unknown trash subcommand %q", errUsage, command)
+	}
+
+	actor, err := c.currentActor()
+	if err != nil {
+		return err
+	}
+	if command == "restore" {
+		taskID, err := parseTaskID(positional)
+		if err != nil {
+			return err
+		}
+		task, err := c.tasks.Restore(actor, taskID)
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			return c.printJSON(task)
+		}
+		fmt.Fprintf(c.out, "Restored task %d.\n", taskID)
+		return nil
+	}
+
+	tasks, err := c.tasks.Trash(actor)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		if tasks == nil {
+			tasks = []Task{} // print [] rather than null
+		}
+		return c.printJSON(tasks)
+	}
+	w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tDELETED\tPURGED AFTER\tPARENT\tTITLE")
+	for _, task := range tasks {
+		parent := ""
+		if task.ParentID != nil {
+			parent = strconv.Itoa(*task.ParentID)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", task.ID, formatTime(*task.DeletedAt), formatTime(*task.PurgeAt), parent, task.Title)
+	}
+	return w.Flush()
+}
+
+// Function to handle the "comment" subcommands that read and write a task's comment thread
+func (c *cli) comment(command string, args []string) error {
+	fs := flag.NewFlagSet("comment "+command, flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print the comments as JSON")
+	positional, err := parseCommandFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	switch {
+	case command != "list" && command != "add" && command != "edit" && command != "delete":
+		return fmt.Errorf("%w: unknown comment subcommand %q", errUsage, command)
+	case (command == "add" || command == "edit") && len(positional) < 2,
+		(command == "list" || command == "delete") && len(positional) != 1:
+		return fmt.Errorf("%w: wrong number of arguments for comment %s", errUsage, command)
+	}
+	id, err := strconv.Atoi(positional[0])
+	if err != nil || id <= 0 {
+		return fmt.Errorf("%w: id must be a positive integer, got %q", errUsage, positional[0])
+	}
+	text := strings.Join(positional[1:], " ")
+
+	actor, err := c.currentActor()
+	if err != nil {
+		return err
+	}
+	switch command {
+	case "list":
+		comments, err := c.tasks.Comments(actor, id)
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			if comments == nil {
+				comments = []Comment{} // print [] rather than null
+			}
+			return c.printJSON(comments)
+		}
+		printComments(c.out, comments)
+		return nil
+	case "add":
+		comment, err := c.tasks.AddComment(actor, id, text)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(c.out, "Added comment %d.\n", comment.ID)
+	case "edit":
+		if _, err := c.tasks.EditComment(actor, id, text); err != nil {
+			return err
+		}
+		fmt.Fprintf(c.out, "Edited comment %d.\n", id)
+	case "delete":
+		if err := c.tasks.DeleteComment(actor, id); err != nil {
+			return err
+		}
+		fmt.Fprintf(c.out, "Deleted comment %d.\n", id)
+	}
+	return nil
+}
+
+// Function to handle the "status" subcommands that view and edit the workflow
+func (c *cli) status(command string, args []string) error {
+	fs := flag.NewFlagSet("status "+command, flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print the workflow as JSON")
+	done := fs.Bool("done", false, "tasks in the new status count as complete")
+	positional, err := parseCommandFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	wantArgs := map[string]int{"list": 0, "reset": 0, "add": 1, "remove": 1, "allow": 2, "forbid": 2}
+	n, known := wantArgs[command]
+	if !known {
+		return fmt.Errorf("%w: unknown status subcommand %q", errUsage, command)
+	}
+	if len(positional) != n {
+		return fmt.Errorf("%w: status %s takes %d argument(s)", errUsage, command, n)
+	}
+	for i := range positional {
+		positional[i] = normalizeStatusName(positional[i])
+	}
+
+	actor, err := c.currentActor()
+	if err != nil {
+		return err
+	}
+	if command == "reset" {
+		if err := c.tasks.ResetWorkflow(actor); err != nil {
+			return err
+		}
+		fmt.Fprintln(c.out, "Statuses reset to the defaults.")
+		return nil
+	}
+
+	workflow, err := c.tasks.Workflow(actor)
+	if err != nil {
+		return err
+	}
+	switch command {
+	case "list":
+		if *asJSON {
+			return c.printJSON(workflow)
+		}
+		w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "STATUS\tCOMPLETE\tCAN MOVE TO")
+		for _, status := range workflow.Statuses {
+			fmt.Fprintf(w, "%s\t%t\t%s\n", status.Name, status.Done, strings.Join(workflow.Transitions[status.Name], ", "))
+		}
+		return w.Flush()
+	case "add":
+		workflow.Statuses = append(workflow.Statuses, WorkflowStatus{Name: positional[0], Done: *done})
+	case "remove":
+		if !workflow.removeStatus(positional[0]) {
+			return fmt.Errorf("%w %q", ErrUnknownStatus, positional[0])
+		}
+	case "allow":
+		workflow.allow(positional[0], positional[1])
+	case "forbid":
+		if !workflow.forbid(positional[0], positional[1]) {
+			return fmt.Errorf("moving from %q to %q isn't allowed now anyway", positional[0], positional[1])
+		}
+	}
+	if err := c.tasks.SaveWorkflow(actor, workflow); err != nil {
+		return err
+	}
+	fmt.Fprintln(c.out, "Statuses updated.")
+	return nil
+}
+
+// Function to handle the "tag" subcommands that list, rename, merge and delete tags
+func (c *cli) tag(command string, args []string) error {
+	fs := flag.NewFlagSet("tag "+command, flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print the tags as JSON")
+	into := fs.String("into", "", "tag to merge into")
+	positional, err := parseCommandFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	switch {
+	case command == "list" && len(positional) != 0,
+		command == "rename" && len(positional) != 2,
+		command == "delete" && len(positional) != 1:
+		return fmt.Errorf("%w: wrong number of arguments for tag %s", errUsage, command)
+	case command == "merge" && (len(positional) == 0 || *into == ""):
+		return fmt.Errorf("%w: tag merge needs the tags to merge and --into", errUsage)
+	case command != "list" && command != "rename" && command != "merge" && command != "delete":
+		return fmt.Errorf("%w: unknown tag subcommand %q", errUsage, command)
+	}
+
+	actor, err := c.currentActor()
+	if err != nil {
+		return err
+	}
+	switch command {
+	case "list":
+		tags, err := c.tasks.Tags(actor)
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			if tags == nil {
+				tags = []Tag{} // print [] rather than null
+			}
+			return c.printJSON(tags)
+		}
+		w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "TAG\tTASKS")
+		for _, tag := range tags {
+			fmt.Fprintf(w, "%s\t%d\n", tag.Name, tag.Tasks)
+		}
+		return w.Flush()
+	case "rename":
+		err = c.tasks.RenameTag(actor, positional[0], positional[1])
+	case "merge":
+		var sources []string
+		for _, arg := range positional {
+			sources = append(sources, splitTags(arg)...)
+		}
+		err = c.tasks.MergeTags(actor, sources, *into)
+	case "delete":
+		err = c.tasks.DeleteTag(actor, positional[0])
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(c.out, "Tags updated.")
+	return nil
+}
+
+// Function to handle the "project" subcommands that list, create, rename, archive and delete projects
+func (c *cli) project(command string, args []string) error {
+	fs := flag.NewFlagSet("project "+command, flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print the projects as JSON")
+	archived := fs.Bool("archived", false, "include archived projects")
+	withTasks := fs.Bool("with-tasks", false, "delete the project's tasks too")
+	positional, err := parseCommandFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	wantArgs := map[string]int{"list": 0, "add": 1, "rename": 2, "archive": 1, "unarchive": 1, "delete": 1}
+	n, known := wantArgs[command]
+	if !known {
+		return fmt.Errorf("%w: unknown project subcommand %q", errUsage, command)
+	}
+	if len(positional) != n {
+		return fmt.Errorf("%w: project %s takes %d argument(s)", errUsage, command, n)
+	}
+
+	actor, err := c.currentActor()
+	if err != nil {
+		return err
+	}
+	switch command {
+	case "list":
+		projects, err := c.tasks.Projects(actor)
+		if err != nil {
+			return err
+		}
+		shown := []Project{} // print [] rather than null
+		for _, project := range projects {
+			if *archived || !project.Archived {
+				shown = append(shown, project)
+			}
+		}
+		if *asJSON {
+			return c.printJSON(shown)
+		}
+		w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "PROJECT\tOPEN\tDONE\tARCHIVED")
+		for _, project := range shown {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%t\n", project.Name, project.Open, project.Done, project.Archived)
+		}
+		return w.Flush()
+	case "add":
+		project, err := c.tasks.CreateProject(actor, positional[0])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(c.out, "Created project %s.\n", project.Name)
+		return nil
+	case "rename":
+		err = c.tasks.RenameProject(actor, positional[0], positional[1])
+	case "archive", "unarchive":
+		err = c.tasks.ArchiveProject(actor, positional[0], command == "archive")
+	case "delete":
+		err = c.tasks.DeleteProject(actor, positional[0], *withTasks)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(c.out, "Projects updated.")
+	return nil
+}
+
+// Function to handle the "workspace" subcommands that switch between and manage workspaces and their members
+func (c *cli) workspace(command string, args []string) error {
+	fs := flag.NewFlagSet("workspace "+command, flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print as JSON")
+	roleFlag := fs.String("role", string(RoleMember), "role of the new member")
+	positional, err := parseCommandFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	wantArgs := map[string]int{"list": 0, "add": 1, "use": 1, "rename": 1, "delete": 0, "members": 0,
+		"add-member": 1, "set-role": 2, "remove-member": 1, "leave": 0, "require-2fa": 1}
+	n, known := wantArgs[command]
+	if !known {
+		return fmt.Errorf("%w: unknown workspace subcommand %q", errUsage, command)
+	}
+	if len(positional) != n {
+		return fmt.Errorf("%w: workspace %s takes %d argument(s)", errUsage, command, n)
+	}
+
+	actor, err := c.currentActor()
+	if err != nil {
+		return err
+	}
+	switch command {
+	case "list":
+		workspaces, err := c.tasks.Workspaces(actor.UserID)
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			if workspaces == nil {
+				workspaces = []Workspace{} // print [] rather than null
+			}
+			return c.printJSON(workspaces)
+		}
+		w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "\tID\tWORKSPACE\tROLE\t2FA")
+		for _, workspace := range workspaces {
+			current, twoFactor := "", ""
+			if workspace.ID == actor.WorkspaceID {
+				current = "*"
+			}
+			if workspace.RequireTwoFactor {
+				twoFactor = "required"
+			}
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\n", current, workspace.ID, workspace.Name, workspace.Role, twoFactor)
+		}
+		return w.Flush()
+	case "add":
+		workspace, err := c.tasks.CreateWorkspace(actor.UserID, positional[0])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(c.out, "Created workspace %d (%s); switch to it with \"tms workspace use %d\".\n", workspace.ID, workspace.Name, workspace.ID)
+		return nil
+	case "use":
+		workspaceID, err := strconv.Atoi(positional[0])
+		if err != nil || workspaceID <= 0 {
+			return fmt.Errorf("%w: invalid workspace ID %q", errUsage, positional[0])
+		}
+		if err := c.sessions.UseWorkspace(c.savedToken(), Actor{UserID: actor.UserID, WorkspaceID: workspaceID}); err != nil {
+			return err
+		}
+		fmt.Fprintf(c.out, "Now working in workspace %d.\n", workspaceID)
+		return nil
+	case "members":
+		members, err := c.tasks.Members(actor)
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			return c.printJSON(members)
+		}
+		w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "USER\tROLE")
+		for _, member := range members {
+			fmt.Fprintf(w, "%s\t%s\n", member.Username, member.Role)
+		}
+		return w.Flush()
+	case "rename":
+		err = c.tasks.RenameWorkspace(actor, positional[0])
+	case "delete":
+		err = c.tasks.DeleteWorkspace(actor)
+	case "add-member":
+		var role Role
+		if role, err = ParseRole(*roleFlag); err == nil {
+			err = c.tasks.AddMember(actor, positional[0], role)
+		}
+	case "set-role":
+		var role Role
+		if role, err = ParseRole(positional[1]); err == nil {
+			err = c.tasks.SetMemberRole(actor, positional[0], role)
+		}
+	case "remove-member":
+		err = c.tasks.RemoveMember(actor, positional[0])
+	case "leave":
+		err = c.tasks.LeaveWorkspace(actor)
+	case "require-2fa":
+		switch positional[0] {
+		case "on":
+			err = c.tasks.SetTwoFactorRequired(actor, true)
+		case "off":
+			err = c.tasks.SetTwoFactorRequired(actor, false)
+		default:
+			return fmt.Errorf("%w: require-2fa takes on or off", errUsage)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(c.out, "Workspace updated.")
+	return nil
+}
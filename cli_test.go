@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Helper function to build the command line tool over an empty in-memory
+// database, saving its token in the test's temporary directory
+func newTestCLI(t *testing.T) (*cli, *bytes.Buffer) {
+	t.Helper()
+	t.Setenv("TMS_TOKEN", "")
+	router := newTestRouter(t)
+	lockout := LockoutConfig{MaxFailures: 5, SourceMaxFailures: 20, BaseDelay: time.Second, MaxDelay: time.Minute, ResetAfter: time.Hour}
+	out := new(bytes.Buffer)
+	c := &cli{
+		router:    router,
+		tasks:     newSQLTaskStore(router, time.Hour),
+		sessions:  newSessionStore(router, time.Hour),
+		limiter:   newLoginLimiter(router, lockout, nil),
+		resets:    newResetStore(router, nil, ResetConfig{CodeTTL: time.Hour}),
+		tokenFile: filepath.Join(t.TempDir(), "tms", "token"),
+		out:       out,
+	}
+	return c, out
+}
+
+// Helper function to feed the lines to the commands' stdin
+func setStdin(t *testing.T, lines ...string) {
+	t.Helper()
+	saved := stdin
+	t.Cleanup(func() { stdin = saved })
+	stdin = bufio.NewReader(strings.NewReader(strings.Join(lines, "\n") + "\n"))
+}
+
+func TestCLILogin(t *testing.T) {
+	c, out := newTestCLI(t)
+	newTestUser(t, c.router, "alice")
+
+	if err := c.dispatch([]string{"task", "list"}); err == nil || !strings.Contains(err.Error(), "not logged in") {
+		t.Errorf("task list before logging in = %v; want not logged in", err)
+	}
+
+	setStdin(t, "Wr0ngpass!")
+	if err := c.dispatch([]string{"login", "--username", "alice", "--password-stdin"}); err != ErrInvalidCredentials {
+		t.Errorf("login with the wrong password = %v; want %v", err, ErrInvalidCredentials)
+	}
+	if _, err := os.Stat(c.tokenFile); !os.IsNotExist(err) {
+		t.Error("a failed login saved a token")
+	}
+
+	setStdin(t, "Passw0rd!")
+	if err := c.dispatch([]string{"login", "--username", "alice", "--password-stdin"}); err != nil {
+		t.Fatal("login:", err)
+	}
+	info, err := os.Stat(c.tokenFile)
+	if err != nil {
+		t.Fatal("the token was not saved:", err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("token file mode = %v; want 0600", info.Mode().Perm())
+	}
+	token := c.savedToken()
+	if _, ok := isValidToken(c.sessions, token); !ok {
+		t.Error("the saved token is not a live session")
+	}
+
+	// Later commands use the saved session
+	out.Reset()
+	if err := c.dispatch([]string{"task", "add", "--title", "Buy milk", "--json"}); err != nil {
+		t.Fatal("task add:", err)
+	}
+	var task Task
+	if err := json.Unmarshal(out.Bytes(), &task); err != nil || task.Title != "Buy milk" || task.Creator != "alice" {
+		t.Errorf("task add --json = %s (%v); want the new task by alice", out, err)
+	}
+	out.Reset()
+	if err := c.dispatch([]string{"task", "list", "--json"}); err != nil {
+		t.Fatal("task list:", err)
+	}
+	var tasks []Task
+	if err := json.Unmarshal(out.Bytes(), &tasks); err != nil || len(tasks) != 1 || tasks[0].ID != task.ID {
+		t.Errorf("task list --json = %s (%v); want the new task", out, err)
+	}
+
+	// TMS_TOKEN takes precedence over the saved token
+	t.Setenv("TMS_TOKEN", "forged")
+	if err := c.dispatch([]string{"task", "list"}); err == nil || !strings.Contains(err.Error(), "expired or revoked") {
+		t.Errorf("task list with a forged TMS_TOKEN = %v; want a rejected session", err)
+	}
+	t.Setenv("TMS_TOKEN", "")
+
+	if err := c.dispatch([]string{"logout"}); err != nil {
+		t.Fatal("logout:", err)
+	}
+	if _, err := os.Stat(c.tokenFile); !os.IsNotExist(err) {
+		t.Error("logout left the token file")
+	}
+	if _, ok := isValidToken(c.sessions, token); ok {
+		t.Error("logout didn't revoke the session")
+	}
+}
+
+func TestCLIUsageErrors(t *testing.T) {
+	c, _ := newTestCLI(t)
+	for _, args := range [][]string{
+		{"login", "--password-stdin"},
+		{"logout", "now"},
+		{"password"},
+	} {
+		if code := c.run(args); code != 2 {
+			t.Errorf("tms %s exited with %d; want 2", strings.Join(args, " "), code)
+		}
+	}
+}
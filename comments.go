@@ -0,0 +1,206 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Comment is one entry in a task's comment thread
+type Comment struct {
+	ID        int        `json:"id"`
+	TaskID    int        `json:"task_id"`
+	Author    string     `json:"author"` // "" once the author's account is deleted
+	Body      string     `json:"body"`
+	CreatedAt time.Time  `json:"created_at"`
+	EditedAt  *time.Time `json:"edited_at,omitempty"`
+}
+
+// ErrCommentNotFound is returned when a comment does not exist or belongs to another workspace
+var ErrCommentNotFound = errors.New("comment not found")
+
+// CommentStore keeps the comment thread of each task. Anyone who may work on
+// a task may comment on it; comments can only be edited or deleted by their author.
+type CommentStore interface {
+	Comments(actor Actor, taskID int) ([]Comment, error)
+	AddComment(actor Actor, taskID int, body string) (Comment, error)
+	EditComment(actor Actor, commentID int, body string) (Comment, error)
+	DeleteComment(actor Actor, commentID int) error
+}
+
+const commentQuery = `
+	SELECT c.comment_id, c.task_id, c.user_id, u.username, c.body, c.created_at, c.edited_at
+	FROM task_comment c JOIN "task" t ON t.task_id = c.task_id LEFT JOIN "user" u ON u.user_id = c.user_id
+	WHERE t.workspace_id = $1 AND t.deleted_at IS NULL`
+
+// Function to normalise the text of a comment
+func normalizeCommentBody(body string) (string, error) {
+	body = strings.TrimSpace(body)
+	switch {
+	case body == "":
+		return "", &ValidationError{Field: "body", Err: errors.New("comment must not be empty")}
+	case len(body) > 2000:
+		return "", &ValidationError{Field: "body", Err: errors.New("comment must be at most 2000 characters long")}
+	}
+	return body, nil
+}
+
+// Function to read comments selected with commentQuery, along with the user ID of each author
+func queryComments(db queryer, query string, args ...interface{}) ([]Comment, []int, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	var comments []Comment
+	var authorIDs []int
+	for rows.Next() {
+		var comment Comment
+		var authorID sql.NullInt64
+		var author sql.NullString
+		var editedAt sql.NullTime
+		if err := rows.Scan(&comment.ID, &comment.TaskID, &authorID, &author, &comment.Body, &comment.CreatedAt, &editedAt); err != nil {
+			return nil, nil, err
+		}
+		comment.Author = author.String
+		if editedAt.Valid {
+			comment.EditedAt = &editedAt.Time
+		}
+		comments = append(comments, comment)
+		authorIDs = append(authorIDs, int(authorID.Int64))
+	}
+	return comments, authorIDs, rows.Err()
+}
+
+// Function to look up one comment in the workspace and check that the actor wrote it
+func findOwnComment(db queryer, actor Actor, commentID int, action string) (Comment, error) {
+	comments, authorIDs, err := queryComments(db, commentQuery+` AND c.comment_id = $2`, actor.WorkspaceID, commentID)
+	if err != nil {
+		return Comment{}, err
+	}
+	if len(comments) == 0 {
+		return Comment{}, ErrCommentNotFound
+	}
+	if authorIDs[0] != actor.UserID {
+		return Comment{}, fmt.Errorf("%w: you can only %s your own comments", ErrForbidden, action)
+	}
+	return comments[0], nil
+}
+
+// Function to print a task's comments, oldest first
+func printComments(w io.Writer, comments []Comment) {
+	for _, comment := range comments {
+		author := comment.Author
+		if author == "" {
+			author = "(deleted user)"
+		}
+		edited := ""
+		if comment.EditedAt != nil {
+			edited = " (edited " + formatTime(*comment.EditedAt) + ")"
+		}
+		fmt.Fprintf(w, "#%d %s, %s%s:\n", comment.ID, author, formatTime(comment.CreatedAt), edited)
+		fmt.Fprintf(w, "  %s\n", strings.ReplaceAll(comment.Body, "\n", "\n  "))
+	}
+}
+
+// Comments returns the task's comment thread, oldest first
+func (s *sqlTaskStore) Comments(actor Actor, taskID int) ([]Comment, error) {
+	db := s.router.Reader(actor.UserID)
+	if _, err := requireRole(db, actor, RoleViewer); err != nil {
+		return nil, err
+	}
+	if _, err := s.get(db, actor.WorkspaceID, taskID); err != nil {
+		return nil, err
+	}
+	comments, _, err := queryComments(db, commentQuery+` AND c.task_id = $2 ORDER BY c.comment_id`, actor.WorkspaceID, taskID)
+	return comments, err
+}
+
+// AddComment appends a comment by the actor to the task's thread
+func (s *sqlTaskStore) AddComment(actor Actor, taskID int, body string) (Comment, error) {
+	body, err := normalizeCommentBody(body)
+	if err != nil {
+		return Comment{}, err
+	}
+
+	tx, err := s.router.Writer(actor.UserID).Begin()
+	if err != nil {
+		return Comment{}, err
+	}
+	defer tx.Rollback()
+
+	role, err := requireRole(tx, actor, RoleViewer)
+	if err != nil {
+		return Comment{}, err
+	}
+	task, err := s.get(tx, actor.WorkspaceID, taskID)
+	if err != nil {
+		return Comment{}, err
+	}
+	if !canWorkOn(role, actor, task) {
+		return Comment{}, fmt.Errorf("%w: %ss can only comment on tasks assigned to them", ErrForbidden, role)
+	}
+	var commentID int
+	query := `INSERT INTO task_comment (task_id, user_id, body) VALUES ($1, $2, $3) RETURNING comment_id`
+	if err := tx.QueryRow(query, taskID, actor.UserID, body).Scan(&commentID); err != nil {
+		return Comment{}, err
+	}
+	comment, err := findOwnComment(tx, actor, commentID, "add")
+	if err != nil {
+		return Comment{}, err
+	}
+	return comment, tx.Commit()
+}
+
+// EditComment replaces the text of one of the actor's comments
+func (s *sqlTaskStore) EditComment(actor Actor, commentID int, body string) (Comment, error) {
+	body, err := normalizeCommentBody(body)
+	if err != nil {
+		return Comment{}, err
+	}
+
+	tx, err := s.router.Writer(actor.UserID).Begin()
+	if err != nil {
+		return Comment{}, err
+	}
+	defer tx.Rollback()
+
+	if _, err := requireRole(tx, actor, RoleViewer); err != nil {
+		return Comment{}, err
+	}
+	if _, err := findOwnComment(tx, actor, commentID, "edit"); err != nil {
+		return Comment{}, err
+	}
+	query := `UPDATE task_comment SET body = $1, edited_at = CURRENT_TIMESTAMP WHERE comment_id = $2`
+	if _, err := tx.Exec(query, body, commentID); err != nil {
+		return Comment{}, err
+	}
+	comment, err := findOwnComment(tx, actor, commentID, "edit")
+	if err != nil {
+		return Comment{}, err
+	}
+	return comment, tx.Commit()
+}
+
+// DeleteComment removes one of the actor's comments from its thread
+func (s *sqlTaskStore) DeleteComment(actor Actor, commentID int) error {
+	tx, err := s.router.Writer(actor.UserID).Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := requireRole(tx, actor, RoleViewer); err != nil {
+		return err
+	}
+	if _, err := findOwnComment(tx, actor, commentID, "delete"); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM task_comment WHERE comment_id = $1`, commentID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
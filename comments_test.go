@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestComments(t *testing.T) {
+	router := newTestRouter(t)
+	store := newSQLTaskStore(router, 0)
+	ownerID, workspaceID := newTestUser(t, router, "carol")
+	daveID, _ := newTestUser(t, router, "dave")
+	viewerID, _ := newTestUser(t, router, "vera")
+	outsiderID, outsiderWorkspaceID := newTestUser(t, router, "eve")
+	owner := Actor{UserID: ownerID, WorkspaceID: workspaceID}
+	dave := Actor{UserID: daveID, WorkspaceID: workspaceID}
+	viewer := Actor{UserID: viewerID, WorkspaceID: workspaceID}
+	outsider := Actor{UserID: outsiderID, WorkspaceID: outsiderWorkspaceID}
+	if err := store.AddMember(owner, "dave", RoleMember); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddMember(owner, "vera", RoleViewer); err != nil {
+		t.Fatal(err)
+	}
+	task, err := store.Create(owner, Task{Title: "Plan the offsite"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var validationErr *ValidationError
+	if _, err := store.AddComment(owner, task.ID, "  "); !errors.As(err, &validationErr) || validationErr.Field != "body" {
+		t.Errorf("an empty comment = %v; want a validation error for body", err)
+	}
+	first, err := store.AddComment(owner, task.ID, " Somewhere warm? ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Author != "carol" || first.Body != "Somewhere warm?" || first.EditedAt != nil {
+		t.Errorf("comment = %+v; want carol's trimmed, unedited text", first)
+	}
+	if _, err := store.AddComment(dave, task.ID, "Lisbon"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.AddComment(viewer, task.ID, "Me too"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("a viewer commenting on a task not assigned to them = %v; want %v", err, ErrForbidden)
+	}
+	if _, err := store.Comments(outsider, task.ID); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("reading the comments of another workspace's task = %v; want %v", err, ErrTaskNotFound)
+	}
+
+	// Only the author may change a comment
+	if _, err := store.EditComment(dave, first.ID, "Somewhere cold"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("editing someone else's comment = %v; want %v", err, ErrForbidden)
+	}
+	if err := store.DeleteComment(dave, first.ID); !errors.Is(err, ErrForbidden) {
+		t.Errorf("deleting someone else's comment = %v; want %v", err, ErrForbidden)
+	}
+	edited, err := store.EditComment(owner, first.ID, "Somewhere sunny?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edited.Body != "Somewhere sunny?" || edited.EditedAt == nil {
+		t.Errorf("edited comment = %+v; want the new text marked as edited", edited)
+	}
+	if err := store.DeleteComment(owner, first.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteComment(owner, first.ID); !errors.Is(err, ErrCommentNotFound) {
+		t.Errorf("deleting a deleted comment = %v; want %v", err, ErrCommentNotFound)
+	}
+
+	comments, err := store.Comments(viewer, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(comments) != 1 || comments[0].Author != "dave" || comments[0].Body != "Lisbon" {
+		t.Errorf("comments = %+v; want only dave's", comments)
+	}
+}
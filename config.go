@@ -0,0 +1,542 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every setting needed to start the program. Values come from
+// defaultConfig, then the YAML file, then TMS_* environment variables, then
+// command-line flags, each source overriding the one before it.
+type Config struct {
+	Backend    string         `yaml:"backend"`     // postgres, sqlite or memory
+	SQLitePath string         `yaml:"sqlite_path"` // database file for the sqlite backend
+	Database   DatabaseConfig `yaml:"database"`
+	Retry      RetryConfig    `yaml:"retry"`
+	Session    SessionConfig  `yaml:"session"`
+	API        APIConfig      `yaml:"api"`
+	Trash      TrashConfig    `yaml:"trash"`
+	Lockout    LockoutConfig  `yaml:"lockout"`
+	Notify     NotifyConfig   `yaml:"notify"`
+	Reset      ResetConfig    `yaml:"password_reset"`
+}
+
+// DatabaseConfig describes the Postgres primary, its optional replica and the connection pools
+type DatabaseConfig struct {
+	PrimaryDSN      string        `yaml:"primary_dsn"`
+	ReplicaDSN      string        `yaml:"replica_dsn"`   // empty means read from the primary
+	Password        string        `yaml:"password"`      // added to both DSNs when set
+	PasswordFile    string        `yaml:"password_file"` // file holding the password, e.g. a mounted secret
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+	ReplicaMaxLag   time.Duration `yaml:"replica_max_lag"` // replica reads are skipped beyond this lag
+}
+
+// RetryConfig controls how often connecting to Postgres is attempted
+type RetryConfig struct {
+	MaxAttempts int           `yaml:"max_attempts"`
+	Backoff     time.Duration `yaml:"backoff"`
+}
+
+// SessionConfig controls how long a login lasts
+type SessionConfig struct {
+	TTL       time.Duration `yaml:"ttl"`        // idle time after which a session expires
+	TokenFile string        `yaml:"token_file"` // where "tms login" saves the token for later commands
+}
+
+// APIConfig controls the REST API started with "serve"
+type APIConfig struct {
+	Addr string `yaml:"addr"` // listen address, e.g. :8080
+}
+
+// TrashConfig controls how long deleted tasks can be restored
+type TrashConfig struct {
+	Retention     time.Duration `yaml:"retention"`      // time in the trash before a task is purged
+	SweepInterval time.Duration `yaml:"sweep_interval"` // how often the trash is checked for tasks to purge
+}
+
+// LockoutConfig controls how failed attempts to log in or reset a password are limited
+type LockoutConfig struct {
+	MaxFailures       int           `yaml:"max_failures"`        // failures in a row before a username is locked out
+	SourceMaxFailures int           `yaml:"source_max_failures"` // failures in a row before a client address is locked out
+	BaseDelay         time.Duration `yaml:"base_delay"`          // first lockout; each further failure doubles it
+	MaxDelay          time.Duration `yaml:"max_delay"`           // longest lockout
+	ResetAfter        time.Duration `yaml:"reset_after"`         // failures are forgotten after this long without another
+}
+
+// NotifyConfig controls how messages such as password reset codes reach users
+type NotifyConfig struct {
+	Sink string     `yaml:"sink"` // smtp, file or log; none by default
+	File string     `yaml:"file"` // where the file sink appends messages
+	SMTP SMTPConfig `yaml:"smtp"`
+}
+
+// SMTPConfig describes the mail server used by the smtp sink
+type SMTPConfig struct {
+	Addr         string `yaml:"addr"` // host:port
+	From         string `yaml:"from"`
+	Username     string `yaml:"username"` // empty means no authentication
+	Password     string `yaml:"password"`
+	PasswordFile string `yaml:"password_file"` // file holding the password, e.g. a mounted secret
+}
+
+// ResetConfig controls password reset codes
+type ResetConfig struct {
+	CodeTTL time.Duration `yaml:"code_ttl"` // how long a code works
+	// How many questions from the catalog each user picks at sign-up and
+	// answers, besides giving the code, to reset their password; 0 turns them off
+	SecurityQuestions int `yaml:"security_questions"`
+}
+
+// Function to get the settings used when nothing else is configured
+func defaultConfig() Config {
+	return Config{
+		Backend:    backendPostgres,
+		SQLitePath: "tms.db",
+		Database: DatabaseConfig{
+			PrimaryDSN:      "postgres://postgres@localhost:5432/tms?sslmode=disable",
+			MaxOpenConns:    10,
+			MaxIdleConns:    5,
+			ConnMaxLifetime: 30 * time.Minute,
+			ReplicaMaxLag:   5 * time.Second,
+		},
+		Retry: RetryConfig{
+			MaxAttempts: 3,
+			Backoff:     2 * time.Second,
+		},
+		Session: SessionConfig{
+			TTL:       24 * time.Hour,
+			TokenFile: defaultTokenFile(),
+		},
+		API: APIConfig{
+			Addr: ":8080",
+		},
+		Trash: TrashConfig{
+			Retention:     30 * 24 * time.Hour,
+			SweepInterval: time.Hour,
+		},
+		Lockout: LockoutConfig{
+			MaxFailures:       5,
+			SourceMaxFailures: 20,
+			BaseDelay:         30 * time.Second,
+			MaxDelay:          time.Hour,
+			ResetAfter:        24 * time.Hour,
+		},
+		Notify: NotifyConfig{
+			File: "tms-messages.log",
+		},
+		Reset: ResetConfig{
+			CodeTTL: time.Hour,
+		},
+	}
+}
+
+// Function to get the default token file, e.g. ~/.config/tms/token
+func defaultTokenFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ".tms_token"
+	}
+	return filepath.Join(dir, "tms", "token")
+}
+
+// configSetting is one value that can be set from the environment or a flag.
+// The environment variable is the flag name in upper case with a TMS_ prefix,
+// e.g. -primary-dsn and TMS_PRIMARY_DSN.
+type configSetting struct {
+	name  string
+	usage string
+	set   func(cfg *Config, value string) error
+}
+
+var configSettings = []configSetting{
+	{"backend", "storage backend: postgres, sqlite or memory", func(cfg *Config, v string) error {
+		cfg.Backend = v
+		return nil
+	}},
+	{"sqlite-path", "database file used by the sqlite backend", func(cfg *Config, v string) error {
+		cfg.SQLitePath = v
+		return nil
+	}},
+	{"primary-dsn", "Postgres connection string for writes", func(cfg *Config, v string) error {
+		cfg.Database.PrimaryDSN = v
+		return nil
+	}},
+	{"replica-dsn", "Postgres connection string for reads (defaults to the primary)", func(cfg *Config, v string) error {
+		cfg.Database.ReplicaDSN = v
+		return nil
+	}},
+	// A password and a password file override each other, whichever comes later
+	{"db-password", "Postgres password (prefer -db-password-file)", func(cfg *Config, v string) error {
+		cfg.Database.Password = v
+		cfg.Database.PasswordFile = ""
+		return nil
+	}},
+	{"db-password-file", "file containing the Postgres password", func(cfg *Config, v string) error {
+		cfg.Database.PasswordFile = v
+		cfg.Database.Password = ""
+		return nil
+	}},
+	{"max-open-conns", "maximum open connections per pool", func(cfg *Config, v string) error {
+		return setInt(&cfg.Database.MaxOpenConns, v)
+	}},
+	{"max-idle-conns", "maximum idle connections per pool", func(cfg *Config, v string) error {
+		return setInt(&cfg.Database.MaxIdleConns, v)
+	}},
+	{"conn-max-lifetime", "maximum lifetime of a pooled connection, e.g. 30m", func(cfg *Config, v string) error {
+		return setDuration(&cfg.Database.ConnMaxLifetime, v)
+	}},
+	{"replica-max-lag", "replica lag after which reads go to the primary, e.g. 5s", func(cfg *Config, v string) error {
+		return setDuration(&cfg.Database.ReplicaMaxLag, v)
+	}},
+	{"retry-attempts", "number of attempts to connect to Postgres", func(cfg *Config, v string) error {
+		return setInt(&cfg.Retry.MaxAttempts, v)
+	}},
+	{"retry-backoff", "wait between connection attempts, e.g. 2s", func(cfg *Config, v string) error {
+		return setDuration(&cfg.Retry.Backoff, v)
+	}},
+	{"session-ttl", "idle time after which a login session expires, e.g. 24h", func(cfg *Config, v string) error {
+		return setDuration(&cfg.Session.TTL, v)
+	}},
+	{"token-file", "where \"tms login\" saves the session token", func(cfg *Config, v string) error {
+		cfg.Session.TokenFile = v
+		return nil
+	}},
+	{"api-addr", "listen address of the REST API started with \"serve\"", func(cfg *Config, v string) error {
+		cfg.API.Addr = v
+		return nil
+	}},
+	{"trash-retention", "how long deleted tasks can be restored before they are purged, e.g. 720h", func(cfg *Config, v string) error {
+		return setDuration(&cfg.Trash.Retention, v)
+	}},
+	{"trash-sweep-interval", "how often tasks past the trash retention are purged, e.g. 1h", func(cfg *Config, v string) error {
+		return setDuration(&cfg.Trash.SweepInterval, v)
+	}},
+	{"lockout-max-failures", "failed logins in a row before a username is locked out", func(cfg *Config, v string) error {
+		return setInt(&cfg.Lockout.MaxFailures, v)
+	}},
+	{"lockout-source-max-failures", "failed logins in a row before a client address is locked out", func(cfg *Config, v string) error {
+		return setInt(&cfg.Lockout.SourceMaxFailures, v)
+	}},
+	{"lockout-base-delay", "first lockout, doubled for every further failure, e.g. 30s", func(cfg *Config, v string) error {
+		return setDuration(&cfg.Lockout.BaseDelay, v)
+	}},
+	{"lockout-max-delay", "longest lockout, e.g. 1h", func(cfg *Config, v string) error {
+		return setDuration(&cfg.Lockout.MaxDelay, v)
+	}},
+	{"lockout-reset-after", "time without failures after which they are forgotten, e.g. 24h", func(cfg *Config, v string) error {
+		return setDuration(&cfg.Lockout.ResetAfter, v)
+	}},
+	{"notify-sink", "how messages such as password reset codes are sent: smtp, file or log (only while serving the API); none by default", func(cfg *Config, v string) error {
+		cfg.Notify.Sink = v
+		return nil
+	}},
+	{"notify-file", "file the file sink appends messages to", func(cfg *Config, v string) error {
+		cfg.Notify.File = v
+		return nil
+	}},
+	{"smtp-addr", "mail server of the smtp sink, as host:port", func(cfg *Config, v string) error {
+		cfg.Notify.SMTP.Addr = v
+		return nil
+	}},
+	{"smtp-from", "sender address of the smtp sink", func(cfg *Config, v string) error {
+		cfg.Notify.SMTP.From = v
+		return nil
+	}},
+	{"smtp-username", "user to authenticate to the mail server as", func(cfg *Config, v string) error {
+		cfg.Notify.SMTP.Username = v
+		return nil
+	}},
+	{"smtp-password", "password for the mail server (prefer -smtp-password-file)", func(cfg *Config, v string) error {
+		cfg.Notify.SMTP.Password = v
+		cfg.Notify.SMTP.PasswordFile = ""
+		return nil
+	}},
+	{"smtp-password-file", "file containing the password for the mail server", func(cfg *Config, v string) error {
+		cfg.Notify.SMTP.PasswordFile = v
+		cfg.Notify.SMTP.Password = ""
+		return nil
+	}},
+	{"reset-code-ttl", "how long a password reset code works, e.g. 1h", func(cfg *Config, v string) error {
+		return setDuration(&cfg.Reset.CodeTTL, v)
+	}},
+	{"reset-security-questions", "how many security questions a password reset asks besides the code; 0 turns them off", func(cfg *Config, v string) error {
+		return setInt(&cfg.Reset.SecurityQuestions, v)
+	}},
+}
+
+func setInt(dst *int, value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("%q is not a whole number", value)
+	}
+	*dst = n
+	return nil
+}
+
+func setDuration(dst *time.Duration, value string) error {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("%q is not a duration such as 30s or 5m", value)
+	}
+	*dst = d
+	return nil
+}
+
+// Helper function to get the environment variable name for a setting
+func (s configSetting) envName() string {
+	return "TMS_" + strings.ToUpper(strings.ReplaceAll(s.name, "-", "_"))
+}
+
+// Function to build the configuration from the file, environment and flags.
+// It returns the arguments left over after the flags (e.g. "migrate up").
+func loadConfig(args []string) (Config, []string, error) {
+	fs := flag.NewFlagSet("tms", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv("TMS_CONFIG"), "path to a YAML configuration file (env TMS_CONFIG)")
+	for _, s := range configSettings {
+		fs.String(s.name, "", s.usage+" (env "+s.envName()+")")
+	}
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), cliUsage)
+		fmt.Fprintln(fs.Output(), "\nGlobal flags:")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, nil, err
+	}
+
+	cfg := defaultConfig()
+
+	// 1. Configuration file
+	if *configPath != "" {
+		data, err := os.ReadFile(*configPath)
+		if err != nil {
+			return Config{}, nil, fmt.Errorf("reading config file: %w", err)
+		}
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true) // report misspelt keys instead of ignoring them
+		if err := decoder.Decode(&cfg); err != nil && err != io.EOF {
+			return Config{}, nil, fmt.Errorf("parsing config file %s: %w", *configPath, err)
+		}
+		if cfg.Database.Password != "" && cfg.Database.PasswordFile != "" {
+			return Config{}, nil, passwordConflict("the config file", "database")
+		}
+		if cfg.Notify.SMTP.Password != "" && cfg.Notify.SMTP.PasswordFile != "" {
+			return Config{}, nil, passwordConflict("the config file", "smtp")
+		}
+	}
+
+	// 2. Environment variables
+	for _, secret := range []string{"db", "smtp"} {
+		_, envPassword := os.LookupEnv("TMS_" + strings.ToUpper(secret) + "_PASSWORD")
+		_, envPasswordFile := os.LookupEnv("TMS_" + strings.ToUpper(secret) + "_PASSWORD_FILE")
+		if envPassword && envPasswordFile {
+			return Config{}, nil, passwordConflict("the environment", secretNames[secret])
+		}
+	}
+	for _, s := range configSettings {
+		if value, ok := os.LookupEnv(s.envName()); ok {
+			if err := s.set(&cfg, value); err != nil {
+				return Config{}, nil, fmt.Errorf("%s: %w", s.envName(), err)
+			}
+		}
+	}
+
+	// 3. Flags given on the command line
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	for _, secret := range []string{"db", "smtp"} {
+		if given[secret+"-password"] && given[secret+"-password-file"] {
+			return Config{}, nil, passwordConflict("the command line", secretNames[secret])
+		}
+	}
+	var flagErr error
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range configSettings {
+			if s.name == f.Name && flagErr == nil {
+				if err := s.set(&cfg, f.Value.String()); err != nil {
+					flagErr = fmt.Errorf("-%s: %w", s.name, err)
+				}
+			}
+		}
+	})
+	if flagErr != nil {
+		return Config{}, nil, flagErr
+	}
+
+	if err := cfg.resolveSecrets(); err != nil {
+		return Config{}, nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return Config{}, nil, err
+	}
+	return cfg, fs.Args(), nil
+}
+
+// Names of the secrets that can be given as a password or a password file,
+// keyed by their setting prefix
+var secretNames = map[string]string{
+	"db":   "database",
+	"smtp": "smtp",
+}
+
+// Helper function to refuse a password and a password file from the same
+// source, where neither can override the other
+func passwordConflict(source, secret string) error {
+	return fmt.Errorf("invalid configuration: %s sets both the %s password and the password file", source, secret)
+}
+
+// Function to read the password files, if any are configured. A password set
+// after one, by the environment or a flag, has already replaced it.
+func (cfg *Config) resolveSecrets() error {
+	if err := readPasswordFile(&cfg.Database.Password, cfg.Database.PasswordFile, "database"); err != nil {
+		return err
+	}
+	return readPasswordFile(&cfg.Notify.SMTP.Password, cfg.Notify.SMTP.PasswordFile, "smtp")
+}
+
+// Helper function to read a password from a file, leaving it alone when no
+// file is set
+func readPasswordFile(password *string, path, secret string) error {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading %s password file: %w", secret, err)
+	}
+	*password = strings.TrimRight(string(data), "\r\n")
+	return nil
+}
+
+// Validate reports every invalid setting at once so they can all be fixed in one go
+func (cfg Config) Validate() error {
+	var problems []string
+	switch cfg.Backend {
+	case backendPostgres:
+		if cfg.Database.PrimaryDSN == "" {
+			problems = append(problems, "database.primary_dsn is required for the postgres backend")
+		}
+		for _, dsn := range []struct{ key, value string }{
+			{"database.primary_dsn", cfg.Database.PrimaryDSN},
+			{"database.replica_dsn", cfg.Database.ReplicaDSN},
+		} {
+			if strings.Contains(dsn.value, "://") {
+				if _, err := url.Parse(dsn.value); err != nil {
+					problems = append(problems, dsn.key+" is not a valid URL: "+err.Error())
+				}
+			}
+		}
+	case backendSQLite:
+		if cfg.SQLitePath == "" {
+			problems = append(problems, "sqlite_path is required for the sqlite backend")
+		}
+	case backendMemory:
+	default:
+		problems = append(problems, fmt.Sprintf("backend %q must be one of %s, %s or %s", cfg.Backend, backendPostgres, backendSQLite, backendMemory))
+	}
+	if cfg.Database.MaxOpenConns < 1 {
+		problems = append(problems, "database.max_open_conns must be at least 1")
+	}
+	if cfg.Database.MaxIdleConns < 0 {
+		problems = append(problems, "database.max_idle_conns must not be negative")
+	} else if cfg.Database.MaxIdleConns > cfg.Database.MaxOpenConns {
+		problems = append(problems, "database.max_idle_conns must not be greater than database.max_open_conns")
+	}
+	if cfg.Database.ConnMaxLifetime < 0 {
+		problems = append(problems, "database.conn_max_lifetime must not be negative")
+	}
+	if cfg.Database.ReplicaMaxLag <= 0 {
+		problems = append(problems, "database.replica_max_lag must be positive")
+	}
+	if cfg.Retry.MaxAttempts < 1 {
+		problems = append(problems, "retry.max_attempts must be at least 1")
+	}
+	if cfg.Retry.Backoff < 0 {
+		problems = append(problems, "retry.backoff must not be negative")
+	}
+
+	if cfg.Session.TTL <= 0 {
+		problems = append(problems, "session.ttl must be positive")
+	}
+	if cfg.Session.TokenFile == "" {
+		problems = append(problems, "session.token_file must not be empty")
+	}
+	if cfg.API.Addr == "" {
+		problems = append(problems, "api.addr must not be empty")
+	}
+	if cfg.Trash.Retention <= 0 {
+		problems = append(problems, "trash.retention must be positive")
+	}
+	if cfg.Trash.SweepInterval <= 0 {
+		problems = append(problems, "trash.sweep_interval must be positive")
+	}
+	if cfg.Lockout.MaxFailures < 1 {
+		problems = append(problems, "lockout.max_failures must be at least 1")
+	}
+	if cfg.Lockout.SourceMaxFailures < 1 {
+		problems = append(problems, "lockout.source_max_failures must be at least 1")
+	}
+	if cfg.Lockout.BaseDelay <= 0 {
+		problems = append(problems, "lockout.base_delay must be positive")
+	} else if cfg.Lockout.MaxDelay < cfg.Lockout.BaseDelay {
+		problems = append(problems, "lockout.max_delay must not be shorter than lockout.base_delay")
+	}
+	if cfg.Lockout.ResetAfter <= 0 {
+		problems = append(problems, "lockout.reset_after must be positive")
+	}
+	switch cfg.Notify.Sink {
+	case sinkSMTP:
+		if cfg.Notify.SMTP.Addr == "" || cfg.Notify.SMTP.From == "" {
+			problems = append(problems, "notify.smtp.addr and notify.smtp.from are required for the smtp sink")
+		}
+	case sinkFile:
+		if cfg.Notify.File == "" {
+			problems = append(problems, "notify.file is required for the file sink")
+		}
+	case sinkLog, "":
+	default:
+		problems = append(problems, fmt.Sprintf("notify.sink %q must be one of %s, %s or %s", cfg.Notify.Sink, sinkSMTP, sinkFile, sinkLog))
+	}
+	if cfg.Reset.CodeTTL <= 0 {
+		problems = append(problems, "password_reset.code_ttl must be positive")
+	}
+	if cfg.Reset.SecurityQuestions < 0 || cfg.Reset.SecurityQuestions > maxSecurityQuestions {
+		problems = append(problems, fmt.Sprintf("password_reset.security_questions must be between 0 and %d", maxSecurityQuestions))
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration:\n  - " + strings.Join(problems, "\n  - "))
+	}
+	return nil
+}
+
+// Function to add the configured password to a Postgres connection string
+func dsnWithPassword(dsn, password string) string {
+	if password == "" {
+		return dsn
+	}
+	if strings.Contains(dsn, "://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return dsn // already reported by Validate
+		}
+		u.User = url.UserPassword(u.User.Username(), password)
+		return u.String()
+	}
+	// key=value form
+	escaped := strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(password)
+	return dsn + " password='" + escaped + "'"
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Helper function to clear every TMS_ variable for the duration of the test,
+// so the environment the tests run in can't change their outcome
+func clearConfigEnv(t *testing.T) {
+	t.Helper()
+	names := []string{"TMS_CONFIG"}
+	for _, s := range configSettings {
+		names = append(names, s.envName())
+	}
+	for _, name := range names {
+		t.Setenv(name, "") // restores the variable when the test ends
+		os.Unsetenv(name)
+	}
+}
+
+// Helper function to write a file in the test's temporary directory, returning its path
+func writeTestFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConfigDefaults(t *testing.T) {
+	clearConfigEnv(t)
+	cfg, args, err := loadConfig([]string{"migrate", "up"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Database.MaxOpenConns != 10 || cfg.Database.MaxIdleConns != 5 || cfg.Database.ConnMaxLifetime != 30*time.Minute {
+		t.Errorf("pool settings = %d open, %d idle, %s; want 10, 5, 30m",
+			cfg.Database.MaxOpenConns, cfg.Database.MaxIdleConns, cfg.Database.ConnMaxLifetime)
+	}
+	if cfg.Retry.MaxAttempts != 3 || cfg.Retry.Backoff != 2*time.Second {
+		t.Errorf("retry = %d attempts, %s backoff; want 3, 2s", cfg.Retry.MaxAttempts, cfg.Retry.Backoff)
+	}
+	if strings.Join(args, " ") != "migrate up" {
+		t.Errorf("remaining arguments = %q; want migrate up", args)
+	}
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	clearConfigEnv(t)
+	path := writeTestFile(t, "tms.yaml", `
+database:
+  primary_dsn: postgres://file@db/tms
+  replica_dsn: postgres://file@replica/tms
+  max_open_conns: 20
+retry:
+  max_attempts: 7
+`)
+	t.Setenv("TMS_CONFIG", path)
+	t.Setenv("TMS_REPLICA_DSN", "postgres://env@replica/tms")
+	t.Setenv("TMS_RETRY_ATTEMPTS", "4")
+
+	cfg, _, err := loadConfig([]string{"-retry-attempts", "9"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Database.PrimaryDSN != "postgres://file@db/tms" {
+		t.Errorf("primary_dsn = %q; want the file's", cfg.Database.PrimaryDSN)
+	}
+	if cfg.Database.MaxOpenConns != 20 {
+		t.Errorf("max_open_conns = %d; want the file's 20", cfg.Database.MaxOpenConns)
+	}
+	if cfg.Database.ReplicaDSN != "postgres://env@replica/tms" {
+		t.Errorf("replica_dsn = %q; want the environment's", cfg.Database.ReplicaDSN)
+	}
+	if cfg.Retry.MaxAttempts != 9 {
+		t.Errorf("max_attempts = %d; want the flag's 9", cfg.Retry.MaxAttempts)
+	}
+}
+
+func TestConfigRejectsInvalidSettings(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		file string
+		want string
+	}{
+		{"misspelt key", nil, "databse:\n  primary_dsn: x\n", "databse"},
+		{"bad number", []string{"-max-open-conns", "ten"}, "", "not a whole number"},
+		{"bad duration", []string{"-retry-backoff", "soon"}, "", "not a duration"},
+		{"idle above open", []string{"-max-open-conns", "2", "-max-idle-conns", "3"}, "", "max_idle_conns"},
+		{"unknown backend", []string{"-backend", "mysql"}, "", "backend"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearConfigEnv(t)
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"-config", writeTestFile(t, "tms.yaml", tt.file)}, args...)
+			}
+			_, _, err := loadConfig(args)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("loadConfig(%q) = %v; want an error about %s", args, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigPasswords(t *testing.T) {
+	secret := writeTestFile(t, "password", "from-file\n")
+	secrets := []struct {
+		prefix   string // of the flags and, in upper case, the environment variables
+		name     string // as the conflict error calls it
+		yaml     string // the password and password_file keys in a config file
+		password func(cfg Config) (string, string)
+	}{
+		{"db", "database", "database:\n  password: a\n  password_file: %s\n", func(cfg Config) (string, string) {
+			return cfg.Database.Password, cfg.Database.PasswordFile
+		}},
+		{"smtp", "smtp", "notify:\n  smtp:\n    password: a\n    password_file: %s\n", func(cfg Config) (string, string) {
+			return cfg.Notify.SMTP.Password, cfg.Notify.SMTP.PasswordFile
+		}},
+	}
+	for _, s := range secrets {
+		flagName, envName := "-"+s.prefix+"-password", "TMS_"+strings.ToUpper(s.prefix)+"_PASSWORD"
+
+		t.Run(s.prefix+" file read and trimmed", func(t *testing.T) {
+			clearConfigEnv(t)
+			cfg, _, err := loadConfig([]string{flagName + "-file", secret})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if password, _ := s.password(cfg); password != "from-file" {
+				t.Errorf("password = %q; want from-file", password)
+			}
+		})
+
+		t.Run(s.prefix+" later source overrides earlier", func(t *testing.T) {
+			clearConfigEnv(t)
+			t.Setenv(envName+"_FILE", secret)
+			cfg, _, err := loadConfig([]string{flagName, "from-flag"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if password, file := s.password(cfg); password != "from-flag" || file != "" {
+				t.Errorf("password = %q, file %q; want the flag's password and no file", password, file)
+			}
+
+			clearConfigEnv(t)
+			t.Setenv(envName, "from-env")
+			cfg, _, err = loadConfig([]string{flagName + "-file", secret})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if password, _ := s.password(cfg); password != "from-file" {
+				t.Errorf("password = %q; want the flag's file to replace the environment's password", password)
+			}
+		})
+
+		conflicts := []struct {
+			source string
+			setup  func(t *testing.T) []string
+		}{
+			{"the config file", func(t *testing.T) []string {
+				return []string{"-config", writeTestFile(t, "tms.yaml", fmt.Sprintf(s.yaml, secret))}
+			}},
+			{"the environment", func(t *testing.T) []string {
+				t.Setenv(envName, "a")
+				t.Setenv(envName+"_FILE", secret)
+				return nil
+			}},
+			{"the command line", func(t *testing.T) []string {
+				return []string{flagName, "a", flagName + "-file", secret}
+			}},
+		}
+		for _, tt := range conflicts {
+			t.Run(s.prefix+" conflict in "+tt.source, func(t *testing.T) {
+				clearConfigEnv(t)
+				_, _, err := loadConfig(tt.setup(t))
+				want := tt.source + " sets both the " + s.name + " password and the password file"
+				if err == nil || !strings.Contains(err.Error(), want) {
+					t.Errorf("loadConfig = %v; want %q", err, want)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Storage backends that can be chosen with -backend
+const (
+	backendPostgres = "postgres"
+	backendSQLite   = "sqlite"
+	backendMemory   = "memory"
+)
+
+// Function to open the read and write databases for the configured backend.
+// SQLite and memory have no replica, so both handles point at the same database.
+func openDatabases(cfg Config) (readDB, writeDB *sql.DB, err error) {
+	switch cfg.Backend {
+	case backendPostgres:
+		readDB, writeDB = connectPostgres(cfg)
+		return readDB, writeDB, nil
+	case backendSQLite:
+		db, err := openSQLite("file:" + cfg.SQLitePath + "?_foreign_keys=on&_busy_timeout=5000&_journal_mode=WAL&_txlock=immediate")
+		return db, db, err
+	case backendMemory:
+		db, err := openSQLite("file::memory:?_foreign_keys=on")
+		if err != nil {
+			return nil, nil, err
+		}
+		// Every connection to ":memory:" gets its own empty database, so keep exactly one open
+		db.SetMaxOpenConns(1)
+		db.SetConnMaxLifetime(0)
+		return db, db, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown backend %q (expected %s, %s or %s)", cfg.Backend, backendPostgres, backendSQLite, backendMemory)
+	}
+}
+
+// Function to open an embedded SQLite database
+func openSQLite(dsn string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	log.Println("Opened the SQLite database")
+	return db, nil
+}
+
+// Function to connect to the Postgres replica (reads) and master (writes).
+// Without a replica DSN both handles share the primary's pool.
+func connectPostgres(cfg Config) (readDB, writeDB *sql.DB) {
+	writeConnStr := dsnWithPassword(cfg.Database.PrimaryDSN, cfg.Database.Password)
+	writeDB = connectPostgresWithRetry("write", writeConnStr, cfg)
+	if cfg.Database.ReplicaDSN == "" {
+		log.Println("No replica configured; reading from the primary")
+		return writeDB, writeDB
+	}
+	readConnStr := dsnWithPassword(cfg.Database.ReplicaDSN, cfg.Database.Password)
+	readDB = connectPostgresWithRetry("read", readConnStr, cfg)
+	return readDB, writeDB
+}
+
+// Function to open and ping one Postgres pool, retrying as configured
+func connectPostgresWithRetry(name, connStr string, cfg Config) *sql.DB {
+	maxRetries := cfg.Retry.MaxAttempts
+	var db *sql.DB
+	var err error
+
+	for i := 0; i < maxRetries; i++ {
+		db, err = sql.Open("postgres", connStr)
+		if err != nil {
+			log.Printf("Error initializing %s database connection: %v", name, err)
+			if i < maxRetries-1 {
+				log.Printf("Retrying to connect to the %s database...", name)
+				time.Sleep(cfg.Retry.Backoff) // wait before retrying
+				continue
+			}
+			log.Fatalf("Unable to connect to the %s database after retries: %v", name, err)
+		}
+		db.SetMaxOpenConns(cfg.Database.MaxOpenConns)       // maximum number of open connections (pooling)
+		db.SetMaxIdleConns(cfg.Database.MaxIdleConns)       // maximum number of idle connections (pooling)
+		db.SetConnMaxLifetime(cfg.Database.ConnMaxLifetime) // maximum lifetime of a connection
+
+		// Ping the database to ensure it is available
+		if err = db.Ping(); err != nil {
+			log.Printf("Error pinging the %s database: %v", name, err)
+			db.Close()
+			if i < maxRetries-1 {
+				log.Printf("Retrying to ping the %s database...", name)
+				time.Sleep(cfg.Retry.Backoff) // wait before retrying
+				continue
+			}
+			log.Fatalf("Unable to ping the %s database after retries: %v", name, err)
+		}
+
+		// Connection successful
+		log.Printf("Connected to the %s database successfully", name)
+		break
+	}
+	return db
+}
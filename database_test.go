@@ -0,0 +1,89 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// Helper function to open an empty in-memory database with every migration
+// applied, closed when the test ends
+func newTestRouter(t *testing.T) *dbRouter {
+	t.Helper()
+	readDB, writeDB, err := openDatabases(Config{Backend: backendMemory})
+	if err != nil {
+		t.Fatal("opening the database:", err)
+	}
+	t.Cleanup(func() { writeDB.Close() })
+	if err := migrateUp(writeDB, backendMemory); err != nil {
+		t.Fatal("migrating the database:", err)
+	}
+	return newDBRouter(writeDB, readDB, backendMemory, 0)
+}
+
+// Helper function to sign up a user, returning their ID and the ID of the
+// workspace they own
+func newTestUser(t *testing.T, router *dbRouter, username string) (int, int) {
+	t.Helper()
+	userID, err := createUser(router, username, "Passw0rd!", "", nil, 0)
+	if err != nil {
+		t.Fatalf("creating user %q: %v", username, err)
+	}
+	workspaceID, err := defaultWorkspace(router.Primary(), userID)
+	if err != nil {
+		t.Fatalf("finding the workspace of %q: %v", username, err)
+	}
+	return userID, workspaceID
+}
+
+func TestEmbeddedBackends(t *testing.T) {
+	for _, backend := range []string{backendMemory, backendSQLite} {
+		t.Run(backend, func(t *testing.T) {
+			cfg := Config{Backend: backend, SQLitePath: filepath.Join(t.TempDir(), "tms.db")}
+			readDB, writeDB, err := openDatabases(cfg)
+			if err != nil {
+				t.Fatal("opening the database:", err)
+			}
+			defer writeDB.Close()
+			if readDB != writeDB {
+				t.Error("an embedded backend has a separate read database")
+			}
+			if err := migrateUp(writeDB, backend); err != nil {
+				t.Fatal("migrating the database:", err)
+			}
+			router := newDBRouter(writeDB, readDB, backend, 0)
+			store := newSQLTaskStore(router, 0)
+
+			userID, workspaceID := newTestUser(t, router, "alice")
+			actor := Actor{UserID: userID, WorkspaceID: workspaceID}
+			task, err := store.Create(actor, Task{Title: "Water the plants"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := store.Get(actor, task.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Title != "Water the plants" || got.Creator != "alice" {
+				t.Errorf("Get = %q by %q; want %q by alice", got.Title, got.Creator, "Water the plants")
+			}
+
+			// Removing the user removes their tasks with them, as the Postgres schema does
+			if _, err := writeDB.Exec(`DELETE FROM "user" WHERE user_id = $1`, userID); err != nil {
+				t.Fatal(err)
+			}
+			var left int
+			if err := writeDB.QueryRow(`SELECT COUNT(*) FROM "task" WHERE task_id = $1`, task.ID).Scan(&left); err != nil {
+				t.Fatal(err)
+			}
+			if left != 0 {
+				t.Error("the user's task outlived them")
+			}
+		})
+	}
+}
+
+func TestUnknownBackend(t *testing.T) {
+	if _, _, err := openDatabases(Config{Backend: "mysql"}); err == nil {
+		t.Error("openDatabases accepted an unknown backend")
+	}
+}
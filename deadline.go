@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Priority ranks how urgent a task is. Higher values sort first.
+type Priority int
+
+const (
+	PriorityLow Priority = iota + 1
+	PriorityMedium
+	PriorityHigh
+	PriorityUrgent
+)
+
+// Priority given to tasks created without one
+const defaultPriority = PriorityMedium
+
+var priorityNames = map[Priority]string{
+	PriorityLow:    "low",
+	PriorityMedium: "medium",
+	PriorityHigh:   "high",
+	PriorityUrgent: "urgent",
+}
+
+func (p Priority) String() string {
+	if name, ok := priorityNames[p]; ok {
+		return name
+	}
+	return strconv.Itoa(int(p))
+}
+
+// ParsePriority accepts a priority name (low, medium, high, urgent) or its number 1-4
+func ParsePriority(s string) (Priority, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for p, name := range priorityNames {
+		if s == name || s == strconv.Itoa(int(p)) {
+			return p, nil
+		}
+	}
+	return 0, errors.New("priority must be low, medium, high or urgent")
+}
+
+func (p Priority) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.String())
+}
+
+// UnmarshalJSON accepts a priority name or its number, as a string or not
+func (p *Priority) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		var n int
+		if json.Unmarshal(data, &n) != nil {
+			return errors.New("priority must be a name or a number from 1 to 4")
+		}
+		s = strconv.Itoa(n)
+	}
+	parsed, err := ParsePriority(s)
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
+}
+
+// Layouts accepted for due dates, with or without a time of day
+var dueLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// ParseDue reads a due date such as "2024-06-30", "2024-06-30 17:00",
+// "2024-06-30 17:00 Europe/London" or an RFC 3339 timestamp. Without a zone
+// the date is in the local time zone; a date without a time is due by the end
+// of that day. It returns nil for "" or "none", meaning no due date.
+func ParseDue(s string) (*time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.EqualFold(s, "none") {
+		return nil, nil
+	}
+
+	// A trailing IANA zone name, e.g. "Europe/London" or "UTC"
+	loc := time.Local
+	if i := strings.LastIndex(s, " "); i > 0 {
+		if name := s[i+1:]; strings.Contains(name, "/") || name == "UTC" {
+			zone, err := time.LoadLocation(name)
+			if err != nil {
+				return nil, fmt.Errorf("unknown time zone %q", name)
+			}
+			loc = zone
+			s = s[:i]
+		}
+	}
+
+	for _, layout := range dueLayouts {
+		var due time.Time
+		var err error
+		if layout == time.RFC3339 {
+			due, err = time.Parse(layout, s)
+			if err == nil && loc != time.Local {
+				due = due.In(loc)
+			}
+		} else {
+			due, err = time.ParseInLocation(layout, s, loc)
+		}
+		if err != nil {
+			continue
+		}
+		if layout == "2006-01-02" {
+			due = endOfDay(due)
+		}
+		return &due, nil
+	}
+	return nil, errors.New("due date must look like 2024-06-30, 2024-06-30 17:00 or 2024-06-30 17:00 Europe/London")
+}
+
+// Helper function to get the last second of t's calendar day in t's zone
+func endOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 23, 59, 59, 0, t.Location())
+}
+
+// Helper function to get the IANA name to store for a due date's zone, or
+// its UTC offset such as "+02:00" for an RFC 3339 date in an unnamed zone
+func zoneName(t time.Time) string {
+	if t.Location() == time.Local {
+		return "" // the server's zone, whatever it is called
+	}
+	if name := t.Location().String(); name != "" {
+		return name
+	}
+	return t.Format("-07:00")
+}
+
+// Helper function to load a stored zone name or offset, falling back to the local zone
+func loadZone(name string) *time.Location {
+	if name == "" {
+		return time.Local
+	}
+	if name[0] == '+' || name[0] == '-' {
+		offset, err := time.Parse("-07:00", name)
+		if err != nil {
+			return time.Local
+		}
+		_, seconds := offset.Zone()
+		return time.FixedZone(name, seconds)
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// IsComplete reports whether the task is in a done status
+func (t Task) IsComplete() bool {
+	return t.Done
+}
+
+// Overdue reports whether an open task's due date has passed
+func (t Task) Overdue(now time.Time) bool {
+	return t.DueAt != nil && !t.IsComplete() && t.DueAt.Before(now)
+}
+
+// DueToday reports whether an open task is due later on the current day in
+// the due date's own time zone
+func (t Task) DueToday(now time.Time) bool {
+	if t.DueAt == nil || t.IsComplete() || t.Overdue(now) {
+		return false
+	}
+	y1, m1, d1 := t.DueAt.Date()
+	y2, m2, d2 := now.In(t.DueAt.Location()).Date()
+	return y1 == y2 && m1 == m2 && d1 == d2
+}
+
+// Helper function to describe a task's deadline state for display
+func dueFlag(t Task, now time.Time) string {
+	switch {
+	case t.Overdue(now):
+		return "OVERDUE"
+	case t.DueToday(now):
+		return "DUE TODAY"
+	}
+	return ""
+}
+
+// Helper function to format a due date for display
+func formatDue(due *time.Time) string {
+	if due == nil {
+		return "-"
+	}
+	return due.Format("2006-01-02 15:04 MST")
+}
+
+// Function to sort tasks by due date (soonest first, undated last), then
+// by priority (most urgent first), then by ID
+func sortTasksByDue(tasks []Task) {
+	sort.SliceStable(tasks, func(i, j int) bool {
+		a, b := tasks[i], tasks[j]
+		switch {
+		case a.DueAt != nil && b.DueAt == nil:
+			return true
+		case a.DueAt == nil && b.DueAt != nil:
+			return false
+		case a.DueAt != nil && !a.DueAt.Equal(*b.DueAt):
+			return a.DueAt.Before(*b.DueAt)
+		case a.Priority != b.Priority:
+			return a.Priority > b.Priority
+		}
+		return a.ID < b.ID
+	})
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrDependencyCycle is returned when a new blocker would make a task (indirectly) block itself
+	ErrDependencyCycle = errors.New("dependency would create a cycle")
+	// ErrTaskBlocked is returned when completing a task while tasks blocking it are still open
+	ErrTaskBlocked = errors.New("task is blocked by open tasks")
+)
+
+// Function to read the tasks blocking each of the given tasks, keyed by the
+// blocked task, and which of them still have an open blocker
+func loadDependencies(db queryer, workspaceID int, taskIDs []int, workflow Workflow) (map[int][]int, map[int]bool, error) {
+	args := []interface{}{workspaceID}
+	query := `
+	SELECT d.task_id, d.blocker_id, b.status FROM task_dependency d
+	JOIN "task" b ON b.task_id = d.blocker_id
+	WHERE b.workspace_id = $1 AND d.task_id IN (` + idList(&args, taskIDs) + `) AND b.deleted_at IS NULL
+	ORDER BY d.blocker_id`
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	blockers := make(map[int][]int)
+	blocked := make(map[int]bool)
+	for rows.Next() {
+		var taskID, blockerID int
+		var status string
+		if err := rows.Scan(&taskID, &blockerID, &status); err != nil {
+			return nil, nil, err
+		}
+		blockers[taskID] = append(blockers[taskID], blockerID)
+		if !workflow.IsDone(status) {
+			blocked[taskID] = true
+		}
+	}
+	return blockers, blocked, rows.Err()
+}
+
+// Function to record that tasks block a task, rejecting unknown tasks and cycles
+func addBlockers(db queryer, workspaceID, taskID int, blockers []int) error {
+	invalid := func(err error) error {
+		return &ValidationError{Field: "blocked_by", Err: err}
+	}
+	for _, blockerID := range blockers {
+		if blockerID == taskID {
+			return invalid(errors.New("a task can't block itself"))
+		}
+		var exists int
+		err := db.QueryRow(`SELECT COUNT(*) FROM "task" WHERE task_id = $1 AND workspace_id = $2 AND deleted_at IS NULL`, blockerID, workspaceID).Scan(&exists)
+		if err != nil {
+			return err
+		} else if exists == 0 {
+			return invalid(fmt.Errorf("blocking task %d not found", blockerID))
+		}
+
+		// Follow what blocks the blocker; reaching the task means it would wait on itself
+		cycle, err := blockedBy(db, blockerID, taskID)
+		if err != nil {
+			return err
+		} else if cycle {
+			return invalid(fmt.Errorf("%w: task %d already waits on task %d", ErrDependencyCycle, blockerID, taskID))
+		}
+
+		query := `INSERT INTO task_dependency (task_id, blocker_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+		if _, err := db.Exec(query, taskID, blockerID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Function to check whether a task waits on another, directly or through other tasks
+func blockedBy(db queryer, taskID, blockerID int) (bool, error) {
+	seen := map[int]bool{taskID: true}
+	queue := []int{taskID}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+
+		// Read the whole level before querying again, so this works on a single connection
+		rows, err := db.Query(`SELECT blocker_id FROM task_dependency WHERE task_id = $1`, id)
+		if err != nil {
+			return false, err
+		}
+		var next []int
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return false, err
+			}
+			next = append(next, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return false, err
+		}
+
+		for _, id := range next {
+			if id == blockerID {
+				return true, nil
+			}
+			if !seen[id] {
+				seen[id] = true
+				queue = append(queue, id)
+			}
+		}
+	}
+	return false, nil
+}
+
+// Function to stop tasks from blocking a task
+func removeBlockers(db queryer, taskID int, blockers []int) error {
+	for _, blockerID := range blockers {
+		query := `DELETE FROM task_dependency WHERE task_id = $1 AND blocker_id = $2`
+		if _, err := db.Exec(query, taskID, blockerID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Function to refuse completing a task while tasks blocking it are still open
+func checkBlockers(db queryer, taskID int, workflow Workflow) error {
+	query := `
+	SELECT d.blocker_id, b.status FROM task_dependency d
+	JOIN "task" b ON b.task_id = d.blocker_id
+	WHERE d.task_id = $1 AND b.deleted_at IS NULL ORDER BY d.blocker_id`
+	rows, err := db.Query(query, taskID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var open []string
+	for rows.Next() {
+		var blockerID int
+		var status string
+		if err := rows.Scan(&blockerID, &status); err != nil {
+			return err
+		}
+		if !workflow.IsDone(status) {
+			open = append(open, strconv.Itoa(blockerID))
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(open) > 0 {
+		return fmt.Errorf("%w: finish task(s) %s first", ErrTaskBlocked, strings.Join(open, ", "))
+	}
+	return nil
+}
+
+// Function to keep only the tasks that can be worked on now: open and not blocked
+func readyTasks(tasks []Task) []Task {
+	var ready []Task
+	for _, task := range tasks {
+		if !task.Done && !task.Blocked {
+			ready = append(ready, task)
+		}
+	}
+	return ready
+}
+
+// Function to order the open tasks so that every task comes after the tasks
+// blocking it. Among the tasks that could go next, the one that sorts first
+// by due date and priority wins.
+func orderByDependencies(tasks []Task) []Task {
+	var open []Task
+	for _, task := range tasks {
+		if !task.Done {
+			open = append(open, task)
+		}
+	}
+	sortTasksByDue(open)
+
+	isOpen := make(map[int]bool)
+	for _, task := range open {
+		isOpen[task.ID] = true
+	}
+	waiting := make(map[int]int) // open blockers not yet placed, per task
+	dependents := make(map[int][]int)
+	for _, task := range open {
+		for _, blockerID := range task.BlockedBy {
+			if isOpen[blockerID] {
+				waiting[task.ID]++
+				dependents[blockerID] = append(dependents[blockerID], task.ID)
+			}
+		}
+	}
+
+	ordered := make([]Task, 0, len(open))
+	placed := make(map[int]bool)
+	for len(ordered) < len(open) {
+		next := -1
+		for i, task := range open {
+			if !placed[task.ID] && waiting[task.ID] == 0 {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			break // only possible with a cycle, which addBlockers rejects
+		}
+		task := open[next]
+		ordered = append(ordered, task)
+		placed[task.ID] = true
+		for _, id := range dependents[task.ID] {
+			waiting[id]--
+		}
+	}
+	return ordered
+}
+
+// Function to split comma-separated task IDs as typed at a prompt or on the command line
+func splitTaskIDs(input string) ([]int, error) {
+	var ids []int
+	for _, field := range strings.Split(input, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		id, err := strconv.Atoi(field)
+		if err != nil || id <= 0 {
+			return nil, fmt.Errorf("invalid task ID %q", field)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Helper function to list task IDs for display
+func joinTaskIDs(ids []int) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.Itoa(id)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDependencies(t *testing.T) {
+	router := newTestRouter(t)
+	store := newSQLTaskStore(router, 0)
+	userID, workspaceID := newTestUser(t, router, "alice")
+	actor := Actor{UserID: userID, WorkspaceID: workspaceID}
+
+	design, err := store.Create(actor, Task{Title: "Design"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	build, err := store.Create(actor, Task{Title: "Build", BlockedBy: []int{design.ID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ship, err := store.Create(actor, Task{Title: "Ship", BlockedBy: []int{build.ID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := getTask(t, store, actor, build.ID); !got.Blocked || !reflect.DeepEqual(got.BlockedBy, []int{design.ID}) {
+		t.Errorf("Build: blocked %v by %v; want blocked by %d", got.Blocked, got.BlockedBy, design.ID)
+	}
+
+	// Design -> Build -> Ship: Design can't also wait on Ship, nor a task on itself
+	for _, blockerID := range []int{ship.ID, design.ID} {
+		_, err := store.Update(actor, design.ID, TaskUpdate{AddBlockers: []int{blockerID}})
+		var validationErr *ValidationError
+		if !errors.As(err, &validationErr) || validationErr.Field != "blocked_by" {
+			t.Errorf("making Design wait on task %d = %v; want a validation error for blocked_by", blockerID, err)
+		}
+	}
+	if _, err := store.Update(actor, design.ID, TaskUpdate{AddBlockers: []int{ship.ID}}); !errors.Is(err, ErrDependencyCycle) {
+		t.Errorf("a cycle = %v; want %v", err, ErrDependencyCycle)
+	}
+
+	status := statusDone
+	if _, err := store.Update(actor, build.ID, TaskUpdate{Status: &status}); !errors.Is(err, ErrTaskBlocked) {
+		t.Errorf("completing a blocked task = %v; want %v", err, ErrTaskBlocked)
+	}
+
+	tasks, err := store.List(actor, TaskFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := taskTitles(readyTasks(tasks)); !reflect.DeepEqual(got, []string{"Design"}) {
+		t.Errorf("ready tasks = %q; want [Design]", got)
+	}
+	var order []string
+	for _, task := range orderByDependencies(tasks) {
+		order = append(order, task.Title)
+	}
+	if !reflect.DeepEqual(order, []string{"Design", "Build", "Ship"}) {
+		t.Errorf("order = %q; want [Design Build Ship]", order)
+	}
+
+	completeTask(t, store, actor, design.ID)
+	if got := getTask(t, store, actor, build.ID); got.Blocked {
+		t.Error("Build is still blocked after Design was completed")
+	}
+	completeTask(t, store, actor, build.ID)
+
+	if _, err := store.Update(actor, ship.ID, TaskUpdate{RemoveBlockers: []int{build.ID}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := getTask(t, store, actor, ship.ID); len(got.BlockedBy) != 0 {
+		t.Errorf("Ship is blocked by %v after removing its blocker", got.BlockedBy)
+	}
+}
+
+func TestSplitTaskIDs(t *testing.T) {
+	if got, err := splitTaskIDs(" 3, 14,,15 "); err != nil || !reflect.DeepEqual(got, []int{3, 14, 15}) {
+		t.Errorf("splitTaskIDs = %v, %v; want [3 14 15]", got, err)
+	}
+	for _, bad := range []string{"3,x", "0", "-2"} {
+		if _, err := splitTaskIDs(bad); err == nil {
+			t.Errorf("splitTaskIDs(%q) accepted it", bad)
+		}
+	}
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Actions recorded in a task's history
+const (
+	EventCreate  = "create"
+	EventUpdate  = "update"
+	EventDelete  = "delete" // moved to the trash
+	EventRestore = "restore"
+	EventPurge   = "purge" // deleted for good, by the trash sweeper or with its creator's account
+)
+
+// FieldChange is the old and new value of one task field; values are shown
+// the way the menus show them, and "" means the field was empty
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// TaskEvent is one create, update, delete, restore or purge of a task
+type TaskEvent struct {
+	ID      int           `json:"id"`
+	TaskID  int           `json:"task_id"`
+	Action  string        `json:"action"` // create, update, delete, restore or purge
+	Actor   string        `json:"actor"`  // "" for purges, and once the user's account is deleted
+	At      time.Time     `json:"at"`
+	Changes []FieldChange `json:"changes"`
+}
+
+// HistoryStore reads the audit history of a task. Every change to a task is
+// recorded by the store that makes it, including changes that follow from
+// others, such as a parent completing itself or a renamed tag.
+type HistoryStore interface {
+	History(actor Actor, taskID int) ([]TaskEvent, error)
+}
+
+// auditedFields lists the task fields the history tracks, in the order they are shown
+var auditedFields = []string{
+	"title", "description", "status", "priority", "due", "tags", "project", "parent", "auto_complete",
+	"blocked_by", "recurrence", "next_task", "assignee", "watchers",
+}
+
+// Function to get the audited fields of a task as text; a nil task has none
+func auditValues(task *Task) map[string]string {
+	values := make(map[string]string)
+	if task == nil {
+		return values
+	}
+	values["title"] = task.Title
+	values["description"] = task.Description
+	values["status"] = task.Status
+	values["priority"] = task.Priority.String()
+	if task.DueAt != nil {
+		values["due"] = formatDue(task.DueAt)
+	}
+	values["tags"] = strings.Join(task.Tags, ", ")
+	values["project"] = task.Project
+	if task.ParentID != nil {
+		values["parent"] = strconv.Itoa(*task.ParentID)
+	}
+	if task.AutoComplete {
+		values["auto_complete"] = "yes"
+	}
+	values["blocked_by"] = joinTaskIDs(task.BlockedBy)
+	values["recurrence"] = task.Recurrence
+	if task.NextTaskID != nil {
+		values["next_task"] = strconv.Itoa(*task.NextTaskID)
+	}
+	values["assignee"] = task.Assignee
+	values["watchers"] = strings.Join(task.Watchers, ", ")
+	return values
+}
+
+// Function to read the IDs a query selects
+func queryIDs(db queryer, query string, args ...interface{}) ([]int, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// Function to get the tasks a change to the given tasks can touch: the tasks
+// themselves, their subtasks and parents, and the tasks they or their
+// subtasks block
+func taskFamily(db queryer, workspaceID int, taskIDs ...int) ([]int, error) {
+	if len(taskIDs) == 0 {
+		return nil, nil
+	}
+	args := []interface{}{workspaceID}
+	query := `
+	WITH RECURSIVE below (task_id) AS (
+		SELECT task_id FROM "task" WHERE workspace_id = $1 AND task_id IN (` + idList(&args, taskIDs) + `)
+		UNION
+		SELECT t.task_id FROM "task" t JOIN below b ON t.parent_id = b.task_id
+	), above (task_id, parent_id) AS (
+		SELECT task_id, parent_id FROM "task" WHERE workspace_id = $1 AND task_id IN (SELECT task_id FROM below)
+		UNION
+		SELECT t.task_id, t.parent_id FROM "task" t JOIN above a ON t.task_id = a.parent_id
+	)
+	SELECT task_id FROM above
+	UNION SELECT d.task_id FROM task_dependency d JOIN below b ON b.task_id = d.blocker_id`
+	return queryIDs(db, query, args...)
+}
+
+// Helper function to read rows of a task ID and a name, keyed by task ID
+func taskNames(db queryer, query string, args ...interface{}) (map[int][]string, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	names := make(map[int][]string)
+	for rows.Next() {
+		var taskID int
+		var name string
+		if err := rows.Scan(&taskID, &name); err != nil {
+			return nil, err
+		}
+		names[taskID] = append(names[taskID], name)
+	}
+	return names, rows.Err()
+}
+
+// Function to read the given tasks, including those in the trash, keyed by
+// ID, to compare against after a change. Only the audited fields are filled in.
+func (s *sqlTaskStore) snapshot(db queryer, workspaceID int, taskIDs []int) (map[int]Task, error) {
+	byID := make(map[int]Task, len(taskIDs))
+	if len(taskIDs) == 0 {
+		return byID, nil
+	}
+	args := []interface{}{workspaceID}
+	ids := idList(&args, taskIDs)
+	rows, err := db.Query(`SELECT `+taskColumns+` FROM "task" WHERE workspace_id = $1 AND task_id IN (`+ids+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		byID[task.ID] = task
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	tags, err := taskNames(db, `
+	SELECT tt.task_id, g.name FROM task_tag tt JOIN tag g ON g.tag_id = tt.tag_id
+	WHERE g.workspace_id = $1 AND tt.task_id IN (`+ids+`) ORDER BY g.name`, args...)
+	if err != nil {
+		return nil, err
+	}
+	projects, err := taskNames(db, `
+	SELECT t.task_id, p.name FROM "task" t JOIN project p ON p.project_id = t.project_id
+	WHERE t.workspace_id = $1 AND t.task_id IN (`+ids+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	assignees, err := taskNames(db, `
+	SELECT t.task_id, u.username FROM "task" t JOIN "user" u ON u.user_id = t.assignee_id
+	WHERE t.workspace_id = $1 AND t.task_id IN (`+ids+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	watchers, err := taskNames(db, `
+	SELECT w.task_id, u.username FROM task_watcher w
+	JOIN "task" t ON t.task_id = w.task_id JOIN "user" u ON u.user_id = w.user_id
+	WHERE t.workspace_id = $1 AND w.task_id IN (`+ids+`) ORDER BY u.username`, args...)
+	if err != nil {
+		return nil, err
+	}
+	rows, err = db.Query(`
+	SELECT d.task_id, d.blocker_id FROM task_dependency d JOIN "task" b ON b.task_id = d.blocker_id
+	WHERE b.workspace_id = $1 AND b.deleted_at IS NULL AND d.task_id IN (`+ids+`) ORDER BY d.blocker_id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	blockers := make(map[int][]int)
+	for rows.Next() {
+		var taskID, blockerID int
+		if err := rows.Scan(&taskID, &blockerID); err != nil {
+			return nil, err
+		}
+		blockers[taskID] = append(blockers[taskID], blockerID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for id, task := range byID {
+		task.Tags = tags[id]
+		task.Project = stringJoin(projects[id], "")
+		task.Assignee = stringJoin(assignees[id], "")
+		task.Watchers = watchers[id]
+		task.BlockedBy = blockers[id]
+		byID[id] = task
+	}
+	return byID, nil
+}
+
+// Function to record an event by the actor for every task that was created,
+// changed, deleted or restored since the snapshot was taken. The snapshot's
+// tasks are compared along with the tasks created since, which are those
+// given and any next occurrence a completed recurring task scheduled.
+func (s *sqlTaskStore) recordChanges(db queryer, actor Actor, before map[int]Task, created ...int) error {
+	taskIDs := append([]int(nil), created...)
+	for id := range before {
+		taskIDs = append(taskIDs, id)
+	}
+	after, err := s.snapshot(db, actor.WorkspaceID, taskIDs)
+	if err != nil {
+		return err
+	}
+	var scheduled []int
+	for _, task := range after {
+		if task.NextTaskID != nil && !sameID(task.NextTaskID, before[task.ID].NextTaskID) {
+			scheduled = append(scheduled, *task.NextTaskID)
+		}
+	}
+	next, err := s.snapshot(db, actor.WorkspaceID, scheduled)
+	if err != nil {
+		return err
+	}
+	for id, task := range next {
+		after[id] = task
+		taskIDs = append(taskIDs, id)
+	}
+	sort.Ints(taskIDs)
+
+	for _, taskID := range taskIDs {
+		prev, existed := before[taskID]
+		next, exists := after[taskID]
+		wasLive := existed && prev.DeletedAt == nil
+		isLive := exists && next.DeletedAt == nil
+		if !wasLive && !isLive {
+			continue // stayed in the trash
+		}
+
+		// A restored task is compared with how it was in the trash
+		var old, updated *Task
+		if existed {
+			old = &prev
+		}
+		if isLive {
+			updated = &next
+		}
+		action := EventUpdate
+		switch {
+		case !existed:
+			action = EventCreate
+		case !wasLive:
+			action = EventRestore
+		case !isLive:
+			action = EventDelete
+		}
+
+		oldValues, newValues := auditValues(old), auditValues(updated)
+		var changes []FieldChange
+		for _, field := range auditedFields {
+			if oldValues[field] != newValues[field] {
+				changes = append(changes, FieldChange{Field: field, Old: oldValues[field], New: newValues[field]})
+			}
+		}
+		if action == EventUpdate && len(changes) == 0 {
+			continue
+		}
+
+		var eventID int
+		query := `INSERT INTO task_event (workspace_id, task_id, user_id, action) VALUES ($1, $2, $3, $4) RETURNING event_id`
+		if err := db.QueryRow(query, actor.WorkspaceID, taskID, actor.UserID, action).Scan(&eventID); err != nil {
+			return err
+		}
+		for _, change := range changes {
+			query := `INSERT INTO task_event_change (event_id, field, old_value, new_value) VALUES ($1, $2, $3, $4)`
+			if _, err := db.Exec(query, eventID, change.Field, change.Old, change.New); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Function to print a task's history, each event followed by what it changed
+func printHistory(w io.Writer, events []TaskEvent) {
+	for _, event := range events {
+		// Purges are done by the trash sweeper or along with a deleted account
+		if event.Action == EventPurge {
+			fmt.Fprintf(w, "%s  task %d purged for good\n", formatTime(event.At), event.TaskID)
+			continue
+		}
+		actor := event.Actor
+		if actor == "" {
+			actor = "(deleted user)"
+		}
+		fmt.Fprintf(w, "%s  %s %sd task %d\n", formatTime(event.At), actor, event.Action, event.TaskID)
+		for _, change := range event.Changes {
+			switch event.Action {
+			case EventCreate:
+				fmt.Fprintf(w, "    %s: %q\n", change.Field, change.New)
+			case EventDelete:
+				fmt.Fprintf(w, "    %s: %q\n", change.Field, change.Old)
+			default:
+				fmt.Fprintf(w, "    %s: %q -> %q\n", change.Field, change.Old, change.New)
+			}
+		}
+	}
+}
+
+// History returns every recorded change to the task, oldest first. The
+// history stays readable after the task is deleted, even once it is purged.
+func (s *sqlTaskStore) History(actor Actor, taskID int) ([]TaskEvent, error) {
+	db := s.router.Reader(actor.UserID)
+	if _, err := requireRole(db, actor, RoleViewer); err != nil {
+		return nil, err
+	}
+
+	query := `
+	SELECT e.event_id, e.task_id, e.action, u.username, e.created_at FROM task_event e
+	LEFT JOIN "user" u ON u.user_id = e.user_id
+	WHERE e.workspace_id = $1 AND e.task_id = $2 ORDER BY e.event_id`
+	rows, err := db.Query(query, actor.WorkspaceID, taskID)
+	if err != nil {
+		return nil, err
+	}
+	var events []TaskEvent
+	index := make(map[int]int) // event ID to its position in events
+	for rows.Next() {
+		var event TaskEvent
+		var actorName sql.NullString
+		if err := rows.Scan(&event.ID, &event.TaskID, &event.Action, &actorName, &event.At); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		event.Actor = actorName.String
+		event.Changes = []FieldChange{} // encode as [] rather than null
+		index[event.ID] = len(events)
+		events = append(events, event)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Tasks created before the history was kept have no events of their own yet
+	if len(events) == 0 {
+		if _, err := s.get(db, actor.WorkspaceID, taskID); err != nil {
+			return nil, err
+		}
+		return events, nil
+	}
+
+	query = `
+	SELECT c.event_id, c.field, c.old_value, c.new_value FROM task_event_change c
+	JOIN task_event e ON e.event_id = c.event_id
+	WHERE e.workspace_id = $1 AND e.task_id = $2`
+	rows, err = db.Query(query, actor.WorkspaceID, taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var eventID int
+		var change FieldChange
+		if err := rows.Scan(&eventID, &change.Field, &change.Old, &change.New); err != nil {
+			return nil, err
+		}
+		event := &events[index[eventID]]
+		event.Changes = append(event.Changes, change)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Show the changes in the same order as the task's fields
+	position := make(map[string]int)
+	for i, field := range auditedFields {
+		position[field] = i
+	}
+	for _, event := range events {
+		sort.Slice(event.Changes, func(i, j int) bool {
+			return position[event.Changes[i].Field] < position[event.Changes[j].Field]
+		})
+	}
+	return events, nil
+}
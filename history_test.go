@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// Helper function to get the actions of a task's history, each with its actor
+func historyActions(t *testing.T, store TaskStore, actor Actor, taskID int) []string {
+	t.Helper()
+	events, err := store.History(actor, taskID)
+	if err != nil {
+		t.Fatalf("history of task %d: %v", taskID, err)
+	}
+	var actions []string
+	for _, event := range events {
+		actions = append(actions, event.Action+" by "+event.Actor)
+	}
+	return actions
+}
+
+func TestHistory(t *testing.T) {
+	router := newTestRouter(t)
+	store := newSQLTaskStore(router, 0)
+	ownerID, workspaceID := newTestUser(t, router, "carol")
+	daveID, _ := newTestUser(t, router, "dave")
+	outsiderID, outsiderWorkspaceID := newTestUser(t, router, "eve")
+	owner := Actor{UserID: ownerID, WorkspaceID: workspaceID}
+	dave := Actor{UserID: daveID, WorkspaceID: workspaceID}
+	outsider := Actor{UserID: outsiderID, WorkspaceID: outsiderWorkspaceID}
+	if err := store.AddMember(owner, "dave", RoleMember); err != nil {
+		t.Fatal(err)
+	}
+
+	task, err := store.Create(owner, Task{Title: "Draft", Tags: []string{"docs"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	title := "Final draft"
+	if _, err := store.Update(dave, task.ID, TaskUpdate{Title: &title, RemoveTags: []string{"docs"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(owner, task.ID, SubtasksRefuse); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Restore(owner, task.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := store.History(owner, task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"create by carol", "update by dave", "delete by carol", "restore by carol"}
+	if got := historyActions(t, store, owner, task.ID); !reflect.DeepEqual(got, want) {
+		t.Fatalf("history = %q; want %q", got, want)
+	}
+	update := events[1].Changes
+	wantUpdate := []FieldChange{{"title", "Draft", "Final draft"}, {"tags", "docs", ""}}
+	if !reflect.DeepEqual(update, wantUpdate) {
+		t.Errorf("update changed %+v; want %+v", update, wantUpdate)
+	}
+
+	if _, err := store.History(outsider, task.ID); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("the history from another workspace = %v; want %v", err, ErrTaskNotFound)
+	}
+}
+
+func TestHistoryRecordsFollowOnChanges(t *testing.T) {
+	router := newTestRouter(t)
+	store := newSQLTaskStore(router, 0)
+	userID, workspaceID := newTestUser(t, router, "alice")
+	actor := Actor{UserID: userID, WorkspaceID: workspaceID}
+
+	parent, err := store.Create(actor, Task{Title: "Trip", AutoComplete: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	child, err := store.Create(actor, Task{Title: "Book hotel", ParentID: &parent.ID, Tags: []string{"travel"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	completeTask(t, store, actor, child.ID)
+	if err := store.RenameTag(actor, "travel", "trips"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"create by alice", "update by alice"}
+	if got := historyActions(t, store, actor, parent.ID); !reflect.DeepEqual(got, want) {
+		t.Errorf("parent's history = %q; want its auto-completion recorded: %q", got, want)
+	}
+	want = []string{"create by alice", "update by alice", "update by alice"}
+	if got := historyActions(t, store, actor, child.ID); !reflect.DeepEqual(got, want) {
+		t.Errorf("subtask's history = %q; want the tag rename recorded: %q", got, want)
+	}
+}
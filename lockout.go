@@ -0,0 +1,335 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Failed attempts to log in or reset a password are counted per username and
+// per source in the login_throttle table, so a restart or another terminal
+// doesn't reset them. Once either count reaches its limit the username or
+// source is locked, first for LockoutConfig.BaseDelay and twice as long for
+// every further failure, up to MaxDelay.
+const (
+	throttleUser   = "user"
+	throttleSource = "source"
+
+	// Source of the attempts made at this machine, through the menu or the
+	// CLI. Everyone at the machine shares it, so it is never locked; only the
+	// usernames tried from it are.
+	terminalSource = "terminal"
+)
+
+// ErrTooManyAttempts is wrapped by LockedError
+var ErrTooManyAttempts = errors.New("too many failed attempts")
+
+// LockedError is returned while a username or source is locked out
+type LockedError struct {
+	Until time.Time
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("%v; try again after %s", ErrTooManyAttempts, formatTime(e.Until.Local()))
+}
+
+func (e *LockedError) Unwrap() error {
+	return ErrTooManyAttempts
+}
+
+type loginLimiter struct {
+	router   *dbRouter
+	cfg      LockoutConfig
+	notifier Notifier // tells users their account was locked
+}
+
+func newLoginLimiter(router *dbRouter, cfg LockoutConfig, notifier Notifier) *loginLimiter {
+	return &loginLimiter{router: router, cfg: cfg, notifier: notifier}
+}
+
+// Helper function to get the source of an API request: the client's IP address
+func requestSource(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Helper function to get the kinds and subjects an attempt is counted against
+func throttleSubjects(username, source string) [][2]string {
+	subjects := [][2]string{{throttleUser, username}}
+	if source != terminalSource {
+		subjects = append(subjects, [2]string{throttleSource, source})
+	}
+	return subjects
+}
+
+// Check refuses an attempt while the username or the source is locked out
+func (l *loginLimiter) Check(username, source string) error {
+	db := l.router.Primary()
+	now := time.Now().UTC()
+	var locked *LockedError
+	for _, subject := range throttleSubjects(username, source) {
+		var until sql.NullTime
+		query := `SELECT locked_until FROM login_throttle WHERE kind = $1 AND subject = $2`
+		err := db.QueryRow(query, subject[0], subject[1]).Scan(&until)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if until.Valid && until.Time.After(now) && (locked == nil || until.Time.After(locked.Until)) {
+			locked = &LockedError{Until: until.Time}
+		}
+	}
+	if locked != nil {
+		return locked
+	}
+	return nil
+}
+
+// Fail counts a failed attempt against the username and the source, locking
+// them out once they reach their limits
+func (l *loginLimiter) Fail(username, source string) error {
+	tx, err := l.router.Writer(anonymousSession).Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	failures, until, err := l.recordFailure(tx, throttleUser, username, l.cfg.MaxFailures, now)
+	if err != nil {
+		return err
+	}
+	var notice string
+	if until != nil {
+		log.Printf("Locked out user %q until %s after %d failed attempts", username, formatTime(until.Local()), failures)
+		notice = fmt.Sprintf("Your account was locked until %s UTC after %d failed attempts to log in or reset your password, the last from %s.",
+			formatTime(until.UTC()), failures, source)
+		if err := leaveNotice(tx, username, notice); err != nil {
+			return err
+		}
+	}
+	if source != terminalSource {
+		sourceFailures, sourceUntil, err := l.recordFailure(tx, throttleSource, source, l.cfg.SourceMaxFailures, now)
+		if err != nil {
+			return err
+		}
+		if sourceUntil != nil {
+			log.Printf("Locked out source %s until %s after %d failed attempts", source, formatTime(sourceUntil.Local()), sourceFailures)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if notice != "" {
+		l.sendNotice(username, notice)
+	}
+	return nil
+}
+
+// Function to send a lockout notice through the notifier as well, for users
+// who may never log in again to read it. Failures are only logged.
+func (l *loginLimiter) sendNotice(username, notice string) {
+	if l.notifier == nil {
+		return // the notice waits for the next login
+	}
+	var email sql.NullString
+	err := l.router.Primary().QueryRow(`SELECT email FROM "user" WHERE username = $1`, username).Scan(&email)
+	if err == sql.ErrNoRows {
+		return
+	}
+	if err == nil {
+		body := notice + "\n\nIf it wasn't you, someone may be guessing your password. The server's operator can lift the lock early.\n"
+		err = l.notifier.Notify(Recipient{Username: username, Email: email.String}, "Your tms account was locked", body)
+	}
+	if err != nil && err != ErrNoEmail {
+		log.Printf("Error sending the lockout notice to %q: %v", username, err)
+	}
+}
+
+// Function to add a failure to one username's or source's count, returning
+// the new count and when the lockout it caused ends, if it caused one. The
+// count goes up in the same statement that reads it, and the row stays locked
+// until the transaction ends, so concurrent failures are all counted.
+func (l *loginLimiter) recordFailure(db queryer, kind, subject string, limit int, now time.Time) (int, *time.Time, error) {
+	// Failures long ago are forgiven
+	query := `
+	INSERT INTO login_throttle (kind, subject, failures, last_failure_at) VALUES ($1, $2, 1, $3)
+	ON CONFLICT (kind, subject) DO UPDATE SET
+		failures = CASE WHEN login_throttle.last_failure_at < $4 THEN 1 ELSE login_throttle.failures + 1 END,
+		last_failure_at = $3
+	RETURNING failures`
+	var failures int
+	if err := db.QueryRow(query, kind, subject, now, now.Add(-l.cfg.ResetAfter)).Scan(&failures); err != nil {
+		return 0, nil, err
+	}
+
+	var until *time.Time
+	if failures >= limit {
+		lockedUntil := now.Add(l.lockDuration(failures - limit))
+		until = &lockedUntil
+	}
+	query = `UPDATE login_throttle SET locked_until = $1 WHERE kind = $2 AND subject = $3`
+	if _, err := db.Exec(query, until, kind, subject); err != nil {
+		return 0, nil, err
+	}
+	return failures, until, nil
+}
+
+// Helper function to get how long to lock out for, doubling for every failure past the limit
+func (l *loginLimiter) lockDuration(pastLimit int) time.Duration {
+	delay := l.cfg.BaseDelay
+	for i := 0; i < pastLimit && delay < l.cfg.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > l.cfg.MaxDelay {
+		delay = l.cfg.MaxDelay
+	}
+	return delay
+}
+
+// UnlockSource lifts a source's lockout, and forgets its failures, before it
+// runs out. Sources aren't anyone's to unlock but the server's operator's.
+func (l *loginLimiter) UnlockSource(source string) error {
+	query := `DELETE FROM login_throttle WHERE kind = $1 AND subject = $2`
+	result, err := l.router.Writer(anonymousSession).Exec(query, throttleSource, source)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("no failed attempts recorded from %s", source)
+	}
+	return nil
+}
+
+// UnlockUser lifts a username's lockout, and forgets its failures, before it
+// runs out. Like sources, usernames are only the server's operator's to
+// unlock: anyone can add an account to a workspace they own, so a workspace
+// role says nothing about whose guesses are being cut short.
+func (l *loginLimiter) UnlockUser(username string) error {
+	query := `DELETE FROM login_throttle WHERE kind = $1 AND subject = $2`
+	result, err := l.router.Writer(anonymousSession).Exec(query, throttleUser, username)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("no failed attempts recorded for %s", username)
+	}
+	return nil
+}
+
+// Succeed clears the username's failures after it logged in or reset its
+// password. The source's failures stay until they are forgiven, or until the
+// operator unlocks the source.
+func (l *loginLimiter) Succeed(username string) error {
+	query := `DELETE FROM login_throttle WHERE kind = $1 AND subject = $2`
+	_, err := l.router.Writer(anonymousSession).Exec(query, throttleUser, username)
+	return err
+}
+
+// Count runs action unless the username or source is locked out, counting it
+// as a failure once it went through. It is for actions that cost something
+// each time whether or not they are meant well, such as sending a password
+// reset code, so they can't be repeated without limit.
+func (l *loginLimiter) Count(username, source string, action func() error) error {
+	if err := l.Check(username, source); err != nil {
+		return err
+	}
+	if err := action(); err != nil {
+		return err
+	}
+	return l.Fail(username, source)
+}
+
+// Attempt runs check unless the username or source is locked out, counting
+// a wrong password, two-factor code, reset code or security answer as a failure
+func (l *loginLimiter) Attempt(username, source string, check func() error) error {
+	return l.attempt(username, source, check, true)
+}
+
+// AttemptAs is Attempt for a logged-in user confirming a change with their
+// password or a two-factor code, which is counted like a login of theirs
+func (l *loginLimiter) AttemptAs(userID int, source string, check func() error) error {
+	var username string
+	if err := l.router.Primary().QueryRow(`SELECT username FROM "user" WHERE user_id = $1`, userID).Scan(&username); err != nil {
+		return err
+	}
+	return l.Attempt(username, source, check)
+}
+
+// AttemptStep is Attempt for an early step of a check made in several, such
+// as the reset code before the security questions: getting it right doesn't
+// clear the username's failures, or the later steps could be guessed forever
+func (l *loginLimiter) AttemptStep(username, source string, check func() error) error {
+	return l.attempt(username, source, check, false)
+}
+
+func (l *loginLimiter) attempt(username, source string, check func() error, last bool) error {
+	if err := l.Check(username, source); err != nil {
+		return err
+	}
+	err := check()
+	switch {
+	case err == nil:
+		if !last {
+			return nil
+		}
+		return l.Succeed(username)
+	case err == ErrInvalidCredentials, err == ErrInvalidSecondFactor, err == ErrInvalidResetCode, err == ErrWrongAnswers:
+		if failErr := l.Fail(username, source); failErr != nil {
+			return failErr
+		}
+	}
+	return err
+}
+
+// Function to leave a notice for a user, shown the next time they log in.
+// Unknown usernames are locked out all the same but get no notice.
+func leaveNotice(db queryer, username, message string) error {
+	query := `
+	INSERT INTO account_notice (user_id, message, created_at)
+	SELECT user_id, $1, $2 FROM "user" WHERE username = $3`
+	_, err := db.Exec(query, message, time.Now().UTC(), username)
+	return err
+}
+
+// Function to get the notices a user hasn't seen yet, oldest first, and mark them seen
+func takeNotices(router *dbRouter, userID int) ([]string, error) {
+	db := router.Writer(userID)
+	query := `SELECT message FROM account_notice WHERE user_id = $1 AND seen_at IS NULL ORDER BY created_at, notice_id`
+	rows, err := db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var notices []string
+	for rows.Next() {
+		var message string
+		if err := rows.Scan(&message); err != nil {
+			return nil, err
+		}
+		notices = append(notices, message)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close() // the memory backend has a single connection
+
+	if len(notices) > 0 {
+		query := `UPDATE account_notice SET seen_at = $1 WHERE user_id = $2 AND seen_at IS NULL`
+		if _, err := db.Exec(query, time.Now().UTC(), userID); err != nil {
+			return nil, err
+		}
+	}
+	return notices, nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+var testLockout = LockoutConfig{
+	MaxFailures:       3,
+	SourceMaxFailures: 5,
+	BaseDelay:         time.Minute,
+	MaxDelay:          10 * time.Minute,
+	ResetAfter:        time.Hour,
+}
+
+func TestLockDuration(t *testing.T) {
+	limiter := newLoginLimiter(nil, testLockout, nil)
+	tests := []struct {
+		pastLimit int
+		want      time.Duration
+	}{
+		{0, time.Minute},
+		{1, 2 * time.Minute},
+		{2, 4 * time.Minute},
+		{3, 8 * time.Minute},
+		{4, 10 * time.Minute},
+		{50, 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := limiter.lockDuration(tt.pastLimit); got != tt.want {
+			t.Errorf("lockDuration(%d) = %s; want %s", tt.pastLimit, got, tt.want)
+		}
+	}
+}
+
+// Helper function to get how long from now a lockout lasts; 0 when not locked out
+func lockedFor(t *testing.T, limiter *loginLimiter, username, source string) time.Duration {
+	t.Helper()
+	err := limiter.Check(username, source)
+	var locked *LockedError
+	switch {
+	case err == nil:
+		return 0
+	case !errors.As(err, &locked) || !errors.Is(err, ErrTooManyAttempts):
+		t.Fatalf("checking %s from %s: %v", username, source, err)
+	}
+	// Round off the time the test took
+	return time.Until(locked.Until).Round(time.Minute)
+}
+
+func TestLoginLimiterBackoff(t *testing.T) {
+	router := newTestRouter(t)
+	limiter := newLoginLimiter(router, testLockout, nil)
+	carolID, _ := newTestUser(t, router, "carol")
+	newTestUser(t, router, "dave")
+
+	steps := []struct {
+		name     string
+		username string
+		source   string // empty for a success
+		times    int
+		want     map[[2]string]time.Duration // lockouts of usernames and sources afterwards
+	}{
+		{"failures below the limit", "carol", "10.0.0.1", 2, map[[2]string]time.Duration{{"carol", "10.0.0.1"}: 0}},
+		{"failures reach the limit", "carol", "10.0.0.1", 1, map[[2]string]time.Duration{
+			{"carol", "10.0.0.1"}: time.Minute,
+			{"carol", "10.0.0.2"}: time.Minute, // the username is locked wherever it is tried from
+			{"dave", "10.0.0.1"}:  0,           // the source isn't yet
+		}},
+		{"each further failure doubles the lockout", "carol", "10.0.0.2", 1, map[[2]string]time.Duration{{"carol", "10.0.0.1"}: 2 * time.Minute}},
+		{"and again", "carol", "10.0.0.2", 1, map[[2]string]time.Duration{{"carol", "10.0.0.1"}: 4 * time.Minute}},
+		{"a success clears the username", "carol", "", 1, map[[2]string]time.Duration{{"carol", "10.0.0.3"}: 0}},
+		{"another username from the same source", "mallory", "10.0.0.2", 2, map[[2]string]time.Duration{{"dave", "10.0.0.2"}: 0}},
+		{"the source reaches its limit", "trent", "10.0.0.2", 1, map[[2]string]time.Duration{
+			{"dave", "10.0.0.2"}:  time.Minute, // 2 from carol, 2 from mallory and 1 from trent
+			{"trent", "10.0.0.1"}: 0,
+		}},
+		{"the terminal is never locked", "dave", terminalSource, 2, map[[2]string]time.Duration{{"carol", terminalSource}: 0}},
+		{"but usernames tried at it are", "dave", terminalSource, 1, map[[2]string]time.Duration{{"dave", terminalSource}: time.Minute}},
+	}
+	for _, step := range steps {
+		for i := 0; i < step.times; i++ {
+			var err error
+			if step.source == "" {
+				err = limiter.Succeed(step.username)
+			} else {
+				err = limiter.Fail(step.username, step.source)
+			}
+			if err != nil {
+				t.Fatalf("%s: %v", step.name, err)
+			}
+		}
+		for subject, want := range step.want {
+			if got := lockedFor(t, limiter, subject[0], subject[1]); got != want {
+				t.Fatalf("%s: %s from %s is locked for %s; want %s", step.name, subject[0], subject[1], got, want)
+			}
+		}
+	}
+
+	if err := limiter.UnlockSource("10.0.0.2"); err != nil {
+		t.Fatal(err)
+	}
+	if got := lockedFor(t, limiter, "carol", "10.0.0.2"); got != 0 {
+		t.Errorf("carol from an unlocked source is locked for %s", got)
+	}
+	if err := limiter.UnlockUser("dave"); err != nil {
+		t.Fatal(err)
+	}
+	if got := lockedFor(t, limiter, "dave", terminalSource); got != 0 {
+		t.Errorf("unlocked dave is locked for %s", got)
+	}
+	if err := limiter.UnlockUser("dave"); err == nil {
+		t.Error("unlocking a username without failed attempts succeeded")
+	}
+	notices, err := takeNotices(router, carolID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notices) != 3 {
+		t.Errorf("carol got %d lockout notices; want 3", len(notices))
+	}
+}
+
+func TestLoginLimiterAttempt(t *testing.T) {
+	router := newTestRouter(t)
+	limiter := newLoginLimiter(router, testLockout, nil)
+	userID, _ := newTestUser(t, router, "carol")
+	unexpected := errors.New("database is down")
+
+	steps := []struct {
+		name  string
+		check error
+		want  error
+	}{
+		{"wrong password", ErrInvalidCredentials, ErrInvalidCredentials},
+		{"wrong two-factor code", ErrInvalidSecondFactor, ErrInvalidSecondFactor},
+		{"other errors aren't counted", unexpected, unexpected},
+		{"right password", nil, nil},
+		{"wrong password", ErrInvalidCredentials, ErrInvalidCredentials},
+		{"wrong reset code", ErrInvalidResetCode, ErrInvalidResetCode},
+		{"wrong security answer", ErrWrongAnswers, ErrWrongAnswers},
+		{"locked out", nil, ErrTooManyAttempts},
+	}
+	for _, step := range steps {
+		err := limiter.AttemptAs(userID, "10.0.0.1", func() error { return step.check })
+		if !errors.Is(err, step.want) {
+			t.Fatalf("%s: %v; want %v", step.name, err, step.want)
+		}
+	}
+}
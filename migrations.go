@@ -0,0 +1,694 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// migration is one numbered schema change. up and down are written for
+// Postgres; dialectSQL rewrites them for SQLite unless sqliteUp/sqliteDown
+// give statements of their own.
+type migration struct {
+	version    int
+	name       string
+	up         string
+	down       string
+	sqliteUp   string
+	sqliteDown string
+}
+
+// migrations lists every schema change in the order it must be applied.
+// Never edit or renumber an entry once it has shipped; add a new one instead.
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "create user table",
+		// IF NOT EXISTS lets databases created before migrations existed adopt this version
+		up: `CREATE TABLE IF NOT EXISTS "user" (
+			user_id SERIAL PRIMARY KEY,
+			username VARCHAR(50) UNIQUE NOT NULL,
+			password VARCHAR(255) NOT NULL,
+			fanswer VARCHAR(255),
+			sanswer VARCHAR(255)
+		)`,
+		down: `DROP TABLE "user"`,
+	},
+	{
+		version: 2,
+		name:    "create task table",
+		up: `CREATE TABLE IF NOT EXISTS "task" (
+			task_id SERIAL PRIMARY KEY,
+			user_id INT NOT NULL REFERENCES "user"(user_id) ON DELETE CASCADE,
+			title VARCHAR(50) NOT NULL,
+			description TEXT,
+			status CHAR(1) DEFAULT 'N', -- 'N' for Not Done, 'C' for Complete
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		down: `DROP TABLE "task"`,
+	},
+	{
+		version: 3,
+		name:    "create sessions table",
+		up: `CREATE TABLE sessions (
+			session_id SERIAL PRIMARY KEY,
+			token_hash CHAR(64) UNIQUE NOT NULL, -- SHA-256 of the token, never the token itself
+			user_id INT NOT NULL REFERENCES "user"(user_id) ON DELETE CASCADE,
+			created_at TIMESTAMP NOT NULL,
+			last_seen_at TIMESTAMP NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			revoked_at TIMESTAMP
+		);
+		CREATE INDEX sessions_user_id_idx ON sessions (user_id)`,
+		down: `DROP TABLE sessions`,
+	},
+	{
+		version: 4,
+		name:    "add task priority and due date",
+		up: `ALTER TABLE "task" ADD COLUMN priority SMALLINT NOT NULL DEFAULT 2; -- 1 low, 2 medium, 3 high, 4 urgent
+		ALTER TABLE "task" ADD COLUMN due_at TIMESTAMPTZ;
+		ALTER TABLE "task" ADD COLUMN due_tz VARCHAR(64); -- IANA zone the due date was given in
+		CREATE INDEX task_user_due_idx ON "task" (user_id, due_at)`,
+		down: `DROP INDEX task_user_due_idx;
+		ALTER TABLE "task" DROP COLUMN due_tz;
+		ALTER TABLE "task" DROP COLUMN due_at;
+		ALTER TABLE "task" DROP COLUMN priority`,
+	},
+	{
+		version: 5,
+		name:    "replace C/N task status with named workflow statuses",
+		up: `CREATE TABLE workflow_status (
+			status_id SERIAL PRIMARY KEY,
+			user_id INT NOT NULL REFERENCES "user"(user_id) ON DELETE CASCADE,
+			name VARCHAR(30) NOT NULL,
+			position INT NOT NULL,
+			is_done BOOLEAN NOT NULL DEFAULT FALSE,
+			UNIQUE (user_id, name)
+		);
+		CREATE TABLE workflow_transition (
+			user_id INT NOT NULL REFERENCES "user"(user_id) ON DELETE CASCADE,
+			from_status VARCHAR(30) NOT NULL,
+			to_status VARCHAR(30) NOT NULL,
+			PRIMARY KEY (user_id, from_status, to_status)
+		);
+		ALTER TABLE "task" ALTER COLUMN status DROP DEFAULT;
+		ALTER TABLE "task" ALTER COLUMN status TYPE VARCHAR(30);
+		UPDATE "task" SET status = CASE status WHEN 'C' THEN 'done' ELSE 'todo' END;
+		ALTER TABLE "task" ALTER COLUMN status SET DEFAULT 'todo';
+		ALTER TABLE "task" ALTER COLUMN status SET NOT NULL`,
+		down: `UPDATE "task" SET status = CASE
+			WHEN status = 'done' OR status IN (
+				SELECT name FROM workflow_status ws WHERE ws.user_id = "task".user_id AND ws.is_done
+			) THEN 'C' ELSE 'N' END;
+		ALTER TABLE "task" ALTER COLUMN status DROP NOT NULL;
+		ALTER TABLE "task" ALTER COLUMN status TYPE CHAR(1);
+		ALTER TABLE "task" ALTER COLUMN status SET DEFAULT 'N';
+		DROP TABLE workflow_transition;
+		DROP TABLE workflow_status`,
+		// SQLite can't change a column's type, but it doesn't enforce CHAR(1) either;
+		// the store always sets status explicitly, so the old 'N' default is never used
+		sqliteUp: `CREATE TABLE workflow_status (
+			status_id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INT NOT NULL REFERENCES "user"(user_id) ON DELETE CASCADE,
+			name VARCHAR(30) NOT NULL,
+			position INT NOT NULL,
+			is_done BOOLEAN NOT NULL DEFAULT FALSE,
+			UNIQUE (user_id, name)
+		);
+		CREATE TABLE workflow_transition (
+			user_id INT NOT NULL REFERENCES "user"(user_id) ON DELETE CASCADE,
+			from_status VARCHAR(30) NOT NULL,
+			to_status VARCHAR(30) NOT NULL,
+			PRIMARY KEY (user_id, from_status, to_status)
+		);
+		UPDATE "task" SET status = CASE status WHEN 'C' THEN 'done' ELSE 'todo' END`,
+		sqliteDown: `UPDATE "task" SET status = CASE
+			WHEN status = 'done' OR status IN (
+				SELECT name FROM workflow_status ws WHERE ws.user_id = "task".user_id AND ws.is_done
+			) THEN 'C' ELSE 'N' END;
+		DROP TABLE workflow_transition;
+		DROP TABLE workflow_status`,
+	},
+	{
+		version: 6,
+		name:    "create tag tables",
+		up: `CREATE TABLE tag (
+			tag_id SERIAL PRIMARY KEY,
+			user_id INT NOT NULL REFERENCES "user"(user_id) ON DELETE CASCADE,
+			name VARCHAR(50) NOT NULL,
+			UNIQUE (user_id, name)
+		);
+		CREATE TABLE task_tag (
+			task_id INT NOT NULL REFERENCES "task"(task_id) ON DELETE CASCADE,
+			tag_id INT NOT NULL REFERENCES tag(tag_id) ON DELETE CASCADE,
+			PRIMARY KEY (task_id, tag_id)
+		);
+		CREATE INDEX task_tag_tag_id_idx ON task_tag (tag_id)`,
+		down: `DROP TABLE task_tag;
+		DROP TABLE tag`,
+	},
+	{
+		version: 7,
+		name:    "add subtasks",
+		up: `ALTER TABLE "task" ADD COLUMN parent_id INT REFERENCES "task"(task_id) ON DELETE CASCADE;
+		ALTER TABLE "task" ADD COLUMN auto_complete BOOLEAN NOT NULL DEFAULT FALSE;
+		CREATE INDEX task_parent_id_idx ON "task" (parent_id)`,
+		down: `DROP INDEX task_parent_id_idx;
+		ALTER TABLE "task" DROP COLUMN auto_complete;
+		ALTER TABLE "task" DROP COLUMN parent_id`,
+	},
+	{
+		version: 8,
+		name:    "create task dependency table",
+		up: `CREATE TABLE task_dependency (
+			task_id INT NOT NULL REFERENCES "task"(task_id) ON DELETE CASCADE,
+			blocker_id INT NOT NULL REFERENCES "task"(task_id) ON DELETE CASCADE, -- must be done before task_id
+			PRIMARY KEY (task_id, blocker_id)
+		);
+		CREATE INDEX task_dependency_blocker_id_idx ON task_dependency (blocker_id)`,
+		down: `DROP TABLE task_dependency`,
+	},
+	{
+		version: 9,
+		name:    "add recurring tasks",
+		up: `ALTER TABLE "task" ADD COLUMN recurrence VARCHAR(255); -- RRULE, e.g. FREQ=WEEKLY;BYDAY=MO
+		ALTER TABLE "task" ADD COLUMN occurrence INT NOT NULL DEFAULT 1;
+		ALTER TABLE "task" ADD COLUMN next_task_id INT REFERENCES "task"(task_id) ON DELETE SET NULL`,
+		down: `ALTER TABLE "task" DROP COLUMN next_task_id;
+		ALTER TABLE "task" DROP COLUMN occurrence;
+		ALTER TABLE "task" DROP COLUMN recurrence`,
+	},
+	{
+		version: 10,
+		name:    "create project table",
+		up: `CREATE TABLE project (
+			project_id SERIAL PRIMARY KEY,
+			user_id INT NOT NULL REFERENCES "user"(user_id) ON DELETE CASCADE,
+			name VARCHAR(50) NOT NULL,
+			archived_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE UNIQUE INDEX project_user_name_idx ON project (user_id, LOWER(name));
+		ALTER TABLE "task" ADD COLUMN project_id INT REFERENCES project(project_id) ON DELETE SET NULL;
+		CREATE INDEX task_project_id_idx ON "task" (project_id)`,
+		down: `DROP INDEX task_project_id_idx;
+		ALTER TABLE "task" DROP COLUMN project_id;
+		DROP TABLE project`,
+	},
+	{
+		version: 11,
+		name:    "add shared workspaces",
+		up: workspacesUp + `;
+		ALTER TABLE "task" ALTER COLUMN workspace_id SET NOT NULL;
+		ALTER TABLE project ALTER COLUMN workspace_id SET NOT NULL;
+		ALTER TABLE workflow_status DROP CONSTRAINT workflow_status_user_id_fkey;
+		UPDATE workflow_status SET user_id = (SELECT workspace_id FROM workspace w WHERE w.created_by = workflow_status.user_id);
+		ALTER TABLE workflow_status RENAME COLUMN user_id TO workspace_id;
+		ALTER TABLE workflow_status ADD FOREIGN KEY (workspace_id) REFERENCES workspace(workspace_id) ON DELETE CASCADE;
+		ALTER TABLE workflow_transition DROP CONSTRAINT workflow_transition_user_id_fkey;
+		UPDATE workflow_transition SET user_id = (SELECT workspace_id FROM workspace w WHERE w.created_by = workflow_transition.user_id);
+		ALTER TABLE workflow_transition RENAME COLUMN user_id TO workspace_id;
+		ALTER TABLE workflow_transition ADD FOREIGN KEY (workspace_id) REFERENCES workspace(workspace_id) ON DELETE CASCADE;
+		ALTER TABLE tag DROP CONSTRAINT tag_user_id_fkey;
+		UPDATE tag SET user_id = (SELECT workspace_id FROM workspace w WHERE w.created_by = tag.user_id);
+		ALTER TABLE tag RENAME COLUMN user_id TO workspace_id;
+		ALTER TABLE tag ADD FOREIGN KEY (workspace_id) REFERENCES workspace(workspace_id) ON DELETE CASCADE`,
+		// Going back keeps the statuses and tags of each user's first workspace
+		down: `ALTER TABLE tag DROP CONSTRAINT tag_workspace_id_fkey;
+		DELETE FROM tag WHERE workspace_id NOT IN (` + personalWorkspaces + `);
+		UPDATE tag SET workspace_id = (SELECT created_by FROM workspace w WHERE w.workspace_id = tag.workspace_id);
+		ALTER TABLE tag RENAME COLUMN workspace_id TO user_id;
+		ALTER TABLE tag ADD FOREIGN KEY (user_id) REFERENCES "user"(user_id) ON DELETE CASCADE;
+		ALTER TABLE workflow_transition DROP CONSTRAINT workflow_transition_workspace_id_fkey;
+		DELETE FROM workflow_transition WHERE workspace_id NOT IN (` + personalWorkspaces + `);
+		UPDATE workflow_transition SET workspace_id = (SELECT created_by FROM workspace w WHERE w.workspace_id = workflow_transition.workspace_id);
+		ALTER TABLE workflow_transition RENAME COLUMN workspace_id TO user_id;
+		ALTER TABLE workflow_transition ADD FOREIGN KEY (user_id) REFERENCES "user"(user_id) ON DELETE CASCADE;
+		ALTER TABLE workflow_status DROP CONSTRAINT workflow_status_workspace_id_fkey;
+		DELETE FROM workflow_status WHERE workspace_id NOT IN (` + personalWorkspaces + `);
+		UPDATE workflow_status SET workspace_id = (SELECT created_by FROM workspace w WHERE w.workspace_id = workflow_status.workspace_id);
+		ALTER TABLE workflow_status RENAME COLUMN workspace_id TO user_id;
+		ALTER TABLE workflow_status ADD FOREIGN KEY (user_id) REFERENCES "user"(user_id) ON DELETE CASCADE;
+		` + workspacesDown,
+		// SQLite can't drop a table's foreign keys or unique constraints, so the
+		// status and tag tables are rebuilt keyed by workspace. task_tag is rebuilt
+		// first so that dropping the old tag table doesn't cascade into it.
+		sqliteUp: dialectSQL(backendSQLite, workspacesUp) + `;
+		CREATE TABLE workflow_status_new (
+			status_id INTEGER PRIMARY KEY AUTOINCREMENT,
+			workspace_id INT NOT NULL REFERENCES workspace(workspace_id) ON DELETE CASCADE,
+			name VARCHAR(30) NOT NULL,
+			position INT NOT NULL,
+			is_done BOOLEAN NOT NULL DEFAULT FALSE,
+			UNIQUE (workspace_id, name)
+		);
+		INSERT INTO workflow_status_new (status_id, workspace_id, name, position, is_done)
+		SELECT s.status_id, w.workspace_id, s.name, s.position, s.is_done FROM workflow_status s JOIN workspace w ON w.created_by = s.user_id;
+		DROP TABLE workflow_status;
+		ALTER TABLE workflow_status_new RENAME TO workflow_status;
+		CREATE TABLE workflow_transition_new (
+			workspace_id INT NOT NULL REFERENCES workspace(workspace_id) ON DELETE CASCADE,
+			from_status VARCHAR(30) NOT NULL,
+			to_status VARCHAR(30) NOT NULL,
+			PRIMARY KEY (workspace_id, from_status, to_status)
+		);
+		INSERT INTO workflow_transition_new (workspace_id, from_status, to_status)
+		SELECT w.workspace_id, t.from_status, t.to_status FROM workflow_transition t JOIN workspace w ON w.created_by = t.user_id;
+		DROP TABLE workflow_transition;
+		ALTER TABLE workflow_transition_new RENAME TO workflow_transition;
+		CREATE TABLE tag_new (
+			tag_id INTEGER PRIMARY KEY AUTOINCREMENT,
+			workspace_id INT NOT NULL REFERENCES workspace(workspace_id) ON DELETE CASCADE,
+			name VARCHAR(50) NOT NULL,
+			UNIQUE (workspace_id, name)
+		);
+		INSERT INTO tag_new (tag_id, workspace_id, name)
+		SELECT g.tag_id, w.workspace_id, g.name FROM tag g JOIN workspace w ON w.created_by = g.user_id;
+		CREATE TABLE task_tag_new (
+			task_id INT NOT NULL REFERENCES "task"(task_id) ON DELETE CASCADE,
+			tag_id INT NOT NULL REFERENCES tag_new(tag_id) ON DELETE CASCADE,
+			PRIMARY KEY (task_id, tag_id)
+		);
+		INSERT INTO task_tag_new (task_id, tag_id) SELECT task_id, tag_id FROM task_tag;
+		DROP TABLE task_tag;
+		DROP TABLE tag;
+		ALTER TABLE tag_new RENAME TO tag;
+		ALTER TABLE task_tag_new RENAME TO task_tag;
+		CREATE INDEX task_tag_tag_id_idx ON task_tag (tag_id)`,
+		sqliteDown: `CREATE TABLE tag_old (
+			tag_id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INT NOT NULL REFERENCES "user"(user_id) ON DELETE CASCADE,
+			name VARCHAR(50) NOT NULL,
+			UNIQUE (user_id, name)
+		);
+		INSERT INTO tag_old (tag_id, user_id, name)
+		SELECT g.tag_id, w.created_by, g.name FROM tag g JOIN workspace w ON w.workspace_id = g.workspace_id
+		WHERE g.workspace_id IN (` + personalWorkspaces + `);
+		CREATE TABLE task_tag_old (
+			task_id INT NOT NULL REFERENCES "task"(task_id) ON DELETE CASCADE,
+			tag_id INT NOT NULL REFERENCES tag_old(tag_id) ON DELETE CASCADE,
+			PRIMARY KEY (task_id, tag_id)
+		);
+		INSERT INTO task_tag_old (task_id, tag_id) SELECT task_id, tag_id FROM task_tag WHERE tag_id IN (SELECT tag_id FROM tag_old);
+		DROP TABLE task_tag;
+		DROP TABLE tag;
+		ALTER TABLE tag_old RENAME TO tag;
+		ALTER TABLE task_tag_old RENAME TO task_tag;
+		CREATE INDEX task_tag_tag_id_idx ON task_tag (tag_id);
+		CREATE TABLE workflow_transition_old (
+			user_id INT NOT NULL REFERENCES "user"(user_id) ON DELETE CASCADE,
+			from_status VARCHAR(30) NOT NULL,
+			to_status VARCHAR(30) NOT NULL,
+			PRIMARY KEY (user_id, from_status, to_status)
+		);
+		INSERT INTO workflow_transition_old (user_id, from_status, to_status)
+		SELECT w.created_by, t.from_status, t.to_status FROM workflow_transition t JOIN workspace w ON w.workspace_id = t.workspace_id
+		WHERE t.workspace_id IN (` + personalWorkspaces + `);
+		DROP TABLE workflow_transition;
+		ALTER TABLE workflow_transition_old RENAME TO workflow_transition;
+		CREATE TABLE workflow_status_old (
+			status_id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INT NOT NULL REFERENCES "user"(user_id) ON DELETE CASCADE,
+			name VARCHAR(30) NOT NULL,
+			position INT NOT NULL,
+			is_done BOOLEAN NOT NULL DEFAULT FALSE,
+			UNIQUE (user_id, name)
+		);
+		INSERT INTO workflow_status_old (status_id, user_id, name, position, is_done)
+		SELECT s.status_id, w.created_by, s.name, s.position, s.is_done FROM workflow_status s JOIN workspace w ON w.workspace_id = s.workspace_id
+		WHERE s.workspace_id IN (` + personalWorkspaces + `);
+		DROP TABLE workflow_status;
+		ALTER TABLE workflow_status_old RENAME TO workflow_status;
+		` + workspacesDown,
+	},
+	{
+		version: 12,
+		name:    "add task assignees and watchers",
+		up: `ALTER TABLE "task" ADD COLUMN assignee_id INT REFERENCES "user"(user_id) ON DELETE SET NULL;
+		CREATE INDEX task_assignee_id_idx ON "task" (assignee_id);
+		CREATE TABLE task_watcher (
+			task_id INT NOT NULL REFERENCES "task"(task_id) ON DELETE CASCADE,
+			user_id INT NOT NULL REFERENCES "user"(user_id) ON DELETE CASCADE,
+			PRIMARY KEY (task_id, user_id)
+		);
+		CREATE TABLE task_assignment (
+			assignment_id SERIAL PRIMARY KEY,
+			task_id INT NOT NULL REFERENCES "task"(task_id) ON DELETE CASCADE,
+			assignee_id INT REFERENCES "user"(user_id) ON DELETE SET NULL, -- NULL when the task was unassigned
+			assigned_by INT REFERENCES "user"(user_id) ON DELETE SET NULL,
+			assigned_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE INDEX task_assignment_task_id_idx ON task_assignment (task_id)`,
+		down: `DROP TABLE task_assignment;
+		DROP TABLE task_watcher;
+		DROP INDEX task_assignee_id_idx;
+		ALTER TABLE "task" DROP COLUMN assignee_id`,
+	},
+	{
+		version: 13,
+		name:    "create task comment table",
+		up: `CREATE TABLE task_comment (
+			comment_id SERIAL PRIMARY KEY,
+			task_id INT NOT NULL REFERENCES "task"(task_id) ON DELETE CASCADE,
+			user_id INT REFERENCES "user"(user_id) ON DELETE SET NULL, -- the author
+			body TEXT NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			edited_at TIMESTAMP
+		);
+		CREATE INDEX task_comment_task_id_idx ON task_comment (task_id)`,
+		down: `DROP TABLE task_comment`,
+	},
+	{
+		version: 14,
+		name:    "create task history tables",
+		up: `CREATE TABLE task_event (
+			event_id SERIAL PRIMARY KEY,
+			workspace_id INT NOT NULL REFERENCES workspace(workspace_id) ON DELETE CASCADE,
+			task_id INT NOT NULL, -- no foreign key: the history outlives the task
+			user_id INT REFERENCES "user"(user_id) ON DELETE SET NULL, -- who made the change
+			action VARCHAR(10) NOT NULL, -- create, update or delete
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE INDEX task_event_task_id_idx ON task_event (task_id);
+		CREATE TABLE task_event_change (
+			event_id INT NOT NULL REFERENCES task_event(event_id) ON DELETE CASCADE,
+			field VARCHAR(30) NOT NULL,
+			old_value TEXT NOT NULL,
+			new_value TEXT NOT NULL,
+			PRIMARY KEY (event_id, field)
+		)`,
+		down: `DROP TABLE task_event_change;
+		DROP TABLE task_event`,
+	},
+	{
+		version: 15,
+		name:    "add a trash for deleted tasks",
+		up: `ALTER TABLE "task" ADD COLUMN deleted_at TIMESTAMP; -- NULL unless the task is in the trash
+		CREATE INDEX task_deleted_at_idx ON "task" (deleted_at)`,
+		// Without the trash, tasks in it would come back to life, so they go for good
+		down: `DELETE FROM "task" WHERE deleted_at IS NOT NULL;
+		DROP INDEX task_deleted_at_idx;
+		ALTER TABLE "task" DROP COLUMN deleted_at`,
+	},
+	{
+		version: 16,
+		name:    "add two-factor authentication",
+		up: `ALTER TABLE "user" ADD COLUMN totp_secret VARCHAR(32); -- base32; set while setting up and once on
+		ALTER TABLE "user" ADD COLUMN totp_enabled_at TIMESTAMP; -- NULL until the setup is confirmed
+		ALTER TABLE "user" ADD COLUMN totp_last_step BIGINT NOT NULL DEFAULT 0; -- codes up to this time step are spent
+		CREATE TABLE recovery_code (
+			recovery_code_id SERIAL PRIMARY KEY,
+			user_id INT NOT NULL REFERENCES "user"(user_id) ON DELETE CASCADE,
+			code_hash VARCHAR(64) NOT NULL, -- SHA-256, like session tokens
+			used_at TIMESTAMP
+		);
+		CREATE INDEX recovery_code_user_id_idx ON recovery_code (user_id);
+		ALTER TABLE workspace ADD COLUMN require_two_factor BOOLEAN NOT NULL DEFAULT FALSE`,
+		down: `ALTER TABLE workspace DROP COLUMN require_two_factor;
+		DROP TABLE recovery_code;
+		ALTER TABLE "user" DROP COLUMN totp_last_step;
+		ALTER TABLE "user" DROP COLUMN totp_enabled_at;
+		ALTER TABLE "user" DROP COLUMN totp_secret`,
+	},
+	{
+		version: 17,
+		name:    "create login lockout tables",
+		up: `CREATE TABLE login_throttle (
+			kind VARCHAR(10) NOT NULL, -- user or source
+			subject VARCHAR(255) NOT NULL, -- the username, or the client address
+			failures INT NOT NULL,
+			last_failure_at TIMESTAMP NOT NULL,
+			locked_until TIMESTAMP,
+			PRIMARY KEY (kind, subject)
+		);
+		CREATE TABLE account_notice (
+			notice_id SERIAL PRIMARY KEY,
+			user_id INT NOT NULL REFERENCES "user"(user_id) ON DELETE CASCADE,
+			message TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			seen_at TIMESTAMP
+		);
+		CREATE INDEX account_notice_user_id_idx ON account_notice (user_id)`,
+		down: `DROP TABLE account_notice;
+		DROP TABLE login_throttle`,
+	},
+	{
+		version: 18,
+		name:    "replace security questions with password reset codes",
+		up: `ALTER TABLE "user" ADD COLUMN email VARCHAR(254);
+		CREATE TABLE password_reset (
+			password_reset_id SERIAL PRIMARY KEY,
+			user_id INT NOT NULL REFERENCES "user"(user_id) ON DELETE CASCADE,
+			token_hash VARCHAR(64) NOT NULL UNIQUE, -- SHA-256, like session tokens
+			created_at TIMESTAMP NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			used_at TIMESTAMP
+		);
+		CREATE INDEX password_reset_user_id_idx ON password_reset (user_id);
+		ALTER TABLE "user" DROP COLUMN fanswer;
+		ALTER TABLE "user" DROP COLUMN sanswer`,
+		// The answers are gone for good; the columns come back empty
+		down: `ALTER TABLE "user" ADD COLUMN fanswer VARCHAR(255);
+		ALTER TABLE "user" ADD COLUMN sanswer VARCHAR(255);
+		DROP TABLE password_reset;
+		ALTER TABLE "user" DROP COLUMN email`,
+	},
+	{
+		version: 19,
+		name:    "add the security question catalog",
+		up: `CREATE TABLE security_question (
+			question_id SERIAL PRIMARY KEY,
+			text VARCHAR(200) NOT NULL UNIQUE
+		);
+		INSERT INTO security_question (text) VALUES
+			('What was the first concert you attended?'),
+			('Who is your favorite artist?'),
+			('What was the name of your first pet?'),
+			('What was the name of your primary school?'),
+			('What was the make of your first car?'),
+			('What was the name of your childhood best friend?'),
+			('What street did you grow up on?'),
+			('What was your first job?');
+		CREATE TABLE user_security_answer (
+			user_id INT NOT NULL REFERENCES "user"(user_id) ON DELETE CASCADE,
+			question_id INT NOT NULL REFERENCES security_question(question_id),
+			answer_hash VARCHAR(60) NOT NULL, -- bcrypt of the normalized answer
+			PRIMARY KEY (user_id, question_id)
+		)`,
+		down: `DROP TABLE user_security_answer;
+		DROP TABLE security_question`,
+	},
+}
+
+// Statements of migration 11 that both dialects share. Every existing user
+// gets a workspace of their own holding everything they had.
+const workspacesUp = `CREATE TABLE workspace (
+			workspace_id SERIAL PRIMARY KEY,
+			name VARCHAR(50) NOT NULL,
+			created_by INT REFERENCES "user"(user_id) ON DELETE SET NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE TABLE workspace_member (
+			workspace_id INT NOT NULL REFERENCES workspace(workspace_id) ON DELETE CASCADE,
+			user_id INT NOT NULL REFERENCES "user"(user_id) ON DELETE CASCADE,
+			role VARCHAR(10) NOT NULL, -- owner, admin, member or viewer
+			PRIMARY KEY (workspace_id, user_id)
+		);
+		CREATE INDEX workspace_member_user_id_idx ON workspace_member (user_id);
+		INSERT INTO workspace (name, created_by) SELECT username, user_id FROM "user";
+		INSERT INTO workspace_member (workspace_id, user_id, role) SELECT workspace_id, created_by, 'owner' FROM workspace;
+		ALTER TABLE "task" ADD COLUMN workspace_id INT REFERENCES workspace(workspace_id) ON DELETE CASCADE;
+		UPDATE "task" SET workspace_id = (SELECT workspace_id FROM workspace w WHERE w.created_by = "task".user_id);
+		CREATE INDEX task_workspace_id_idx ON "task" (workspace_id);
+		ALTER TABLE project ADD COLUMN workspace_id INT REFERENCES workspace(workspace_id) ON DELETE CASCADE;
+		UPDATE project SET workspace_id = (SELECT workspace_id FROM workspace w WHERE w.created_by = project.user_id);
+		DROP INDEX project_user_name_idx;
+		CREATE UNIQUE INDEX project_workspace_name_idx ON project (workspace_id, LOWER(name));
+		ALTER TABLE sessions ADD COLUMN workspace_id INT REFERENCES workspace(workspace_id) ON DELETE SET NULL`
+
+// The first workspace each user created, which migration 11 made from their own data
+const personalWorkspaces = `SELECT MIN(workspace_id) FROM workspace WHERE created_by IS NOT NULL GROUP BY created_by`
+
+// Statements that undo workspacesUp once statuses and tags are keyed by user again
+const workspacesDown = `ALTER TABLE sessions DROP COLUMN workspace_id;
+		DROP INDEX project_workspace_name_idx;
+		ALTER TABLE project DROP COLUMN workspace_id;
+		CREATE UNIQUE INDEX project_user_name_idx ON project (user_id, LOWER(name));
+		DROP INDEX task_workspace_id_idx;
+		ALTER TABLE "task" DROP COLUMN workspace_id;
+		DROP TABLE workspace_member;
+		DROP TABLE workspace`
+
+// Function to rewrite Postgres-only column types for the given backend
+func dialectSQL(backend, query string) string {
+	if backend == backendPostgres {
+		return query
+	}
+	replacer := strings.NewReplacer(
+		"SERIAL PRIMARY KEY", "INTEGER PRIMARY KEY AUTOINCREMENT",
+		"TIMESTAMPTZ", "TIMESTAMP",
+	)
+	return replacer.Replace(query)
+}
+
+// Function to get the statements that apply (or revert) a migration on the backend
+func (m migration) statements(backend string, up bool) string {
+	switch {
+	case up && backend != backendPostgres && m.sqliteUp != "":
+		return m.sqliteUp
+	case !up && backend != backendPostgres && m.sqliteDown != "":
+		return m.sqliteDown
+	case up:
+		return dialectSQL(backend, m.up)
+	default:
+		return dialectSQL(backend, m.down)
+	}
+}
+
+// Function to create the table that records which migrations have been applied
+func createMigrationsTable(db *sql.DB) error {
+	query := `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INT PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`
+	_, err := db.Exec(query)
+	return err
+}
+
+// Function to read the applied migration versions and when they ran
+func appliedMigrations(db *sql.DB) (map[int]time.Time, error) {
+	if err := createMigrationsTable(db); err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(`SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// Function to run one migration and record it, all inside a single transaction
+func runMigration(db *sql.DB, backend string, m migration, up bool) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Serialise concurrent migrators on Postgres; SQLite already locks the whole file on write
+	if backend == backendPostgres {
+		if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(7406001)`); err != nil {
+			return err
+		}
+	}
+
+	// Another process may have applied or reverted this migration while we waited for the lock
+	var count int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM schema_migrations WHERE version = $1`, m.version).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if (up && count > 0) || (!up && count == 0) {
+		return tx.Commit()
+	}
+
+	if _, err := tx.Exec(m.statements(backend, up)); err != nil {
+		return err
+	}
+	if up {
+		_, err = tx.Exec(`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, m.version, m.name)
+	} else {
+		_, err = tx.Exec(`DELETE FROM schema_migrations WHERE version = $1`, m.version)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Function to apply every pending migration in order
+func migrateUp(db *sql.DB, backend string) error {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return fmt.Errorf("reading schema_migrations: %w", err)
+	}
+	for _, m := range migrations {
+		if _, ok := applied[m.version]; ok {
+			continue
+		}
+		if err := runMigration(db, backend, m, true); err != nil {
+			return fmt.Errorf("applying migration %d (%s): %w", m.version, m.name, err)
+		}
+		log.Printf("Applied migration %d: %s", m.version, m.name)
+	}
+	return nil
+}
+
+// Function to revert the most recently applied migration
+func migrateDown(db *sql.DB, backend string) error {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return fmt.Errorf("reading schema_migrations: %w", err)
+	}
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.version]; !ok {
+			continue
+		}
+		if err := runMigration(db, backend, m, false); err != nil {
+			return fmt.Errorf("reverting migration %d (%s): %w", m.version, m.name, err)
+		}
+		log.Printf("Reverted migration %d: %s", m.version, m.name)
+		return nil
+	}
+	fmt.Println("No migrations to revert.")
+	return nil
+}
+
+// Function to print every known migration and whether it has been applied
+func migrateStatus(db *sql.DB) error {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return fmt.Errorf("reading schema_migrations: %w", err)
+	}
+	for _, m := range migrations {
+		status := "pending"
+		if appliedAt, ok := applied[m.version]; ok {
+			status = "applied " + formatTime(appliedAt)
+		}
+		fmt.Printf("%4d  %-40s %s\n", m.version, m.name, status)
+	}
+	return nil
+}
+
+// Function to handle "migrate up|down|status" from the command line
+func runMigrateCommand(db *sql.DB, backend string, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: migrate up|down|status")
+	}
+	switch args[0] {
+	case "up":
+		return migrateUp(db, backend)
+	case "down":
+		return migrateDown(db, backend)
+	case "status":
+		return migrateStatus(db)
+	default:
+		return fmt.Errorf("unknown migrate command %q (expected up, down or status)", args[0])
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+)
+
+// Helper function to read the schema, so it can be compared after migrating down and up again
+func schemaOf(t *testing.T, db *sql.DB) map[string]string {
+	t.Helper()
+	rows, err := db.Query(`SELECT name, COALESCE(sql, '') FROM sqlite_master WHERE name NOT LIKE 'sqlite_%' AND name <> 'schema_migrations'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	schema := make(map[string]string)
+	for rows.Next() {
+		var name, definition string
+		if err := rows.Scan(&name, &definition); err != nil {
+			t.Fatal(err)
+		}
+		schema[name] = definition
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return schema
+}
+
+func TestMigrationsUpAndDown(t *testing.T) {
+	router := newTestRouter(t)
+	db := router.Primary()
+	migrated := schemaOf(t, db)
+
+	// Every migration reverts cleanly, newest first, down to an empty schema
+	for i := len(migrations) - 1; i >= 0; i-- {
+		if err := migrateDown(db, backendMemory); err != nil {
+			t.Fatal(err)
+		}
+		applied, err := appliedMigrations(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(applied) != i {
+			t.Fatalf("after reverting migration %d, %d migrations are applied; want %d", migrations[i].version, len(applied), i)
+		}
+		if _, ok := applied[migrations[i].version]; ok {
+			t.Fatalf("migration %d is still recorded as applied", migrations[i].version)
+		}
+	}
+	if schema := schemaOf(t, db); len(schema) != 0 {
+		t.Errorf("tables left after reverting every migration: %v", schema)
+	}
+
+	// Applying them again gives the same schema, and applying twice changes nothing
+	for run := 0; run < 2; run++ {
+		if err := migrateUp(db, backendMemory); err != nil {
+			t.Fatal(err)
+		}
+	}
+	again := schemaOf(t, db)
+	for name, definition := range migrated {
+		if again[name] != definition {
+			t.Errorf("%s after migrating down and up:\n%s\nwant:\n%s", name, again[name], definition)
+		}
+	}
+	for name := range again {
+		if _, ok := migrated[name]; !ok {
+			t.Errorf("%s appeared after migrating down and up", name)
+		}
+	}
+}
+
+func TestMigrationVersionsAscend(t *testing.T) {
+	for i, m := range migrations {
+		if m.version != i+1 {
+			t.Errorf("migration %q has version %d; want %d", m.name, m.version, i+1)
+		}
+		if m.up == "" && m.sqliteUp == "" {
+			t.Errorf("migration %d has nothing to apply", m.version)
+		}
+		if m.down == "" && m.sqliteDown == "" {
+			t.Errorf("migration %d has nothing to revert", m.version)
+		}
+	}
+}
+
+func TestDialectSQL(t *testing.T) {
+	tests := []struct {
+		backend string
+		query   string
+		want    string
+	}{
+		{backendPostgres, "id SERIAL PRIMARY KEY, at TIMESTAMPTZ", "id SERIAL PRIMARY KEY, at TIMESTAMPTZ"},
+		{backendSQLite, "id SERIAL PRIMARY KEY, at TIMESTAMPTZ", "id INTEGER PRIMARY KEY AUTOINCREMENT, at TIMESTAMP"},
+		{backendMemory, "at TIMESTAMPTZ NOT NULL", "at TIMESTAMP NOT NULL"},
+	}
+	for _, tt := range tests {
+		if got := dialectSQL(tt.backend, tt.query); got != tt.want {
+			t.Errorf("dialectSQL(%s, %q) = %q; want %q", tt.backend, tt.query, got, tt.want)
+		}
+	}
+}
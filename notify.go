@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Notification sinks, chosen with notify.sink
+const (
+	sinkSMTP = "smtp"
+	sinkFile = "file"
+	sinkLog  = "log"
+)
+
+var (
+	// ErrNoEmail is returned when a message should go by email to a user without an email address
+	ErrNoEmail = errors.New("the account has no email address")
+	// ErrNoNotifier is returned for sending a password reset code without a notifier to send it
+	ErrNoNotifier = errors.New("password reset codes can't be sent: set notify.sink to smtp or file, or to log while serving the API")
+)
+
+// Recipient is the user a message is for
+type Recipient struct {
+	Username string
+	Email    string // empty if the user never gave one
+}
+
+// Notifier delivers messages to users outside the program, such as password
+// reset codes. Email goes through SMTP; the file and log sinks are for local
+// testing and let an operator pass messages on by hand.
+type Notifier interface {
+	Notify(to Recipient, subject, body string) error
+}
+
+// Function to create the notifier selected in the configuration, or nil when
+// there is none. There is no default: messages carry reset codes, so they only
+// go where the operator said. The log sink only works while serving the API,
+// as the menu and CLI log to the terminal of whoever asked for the code.
+func newNotifier(cfg NotifyConfig, serving bool) Notifier {
+	switch {
+	case cfg.Sink == sinkSMTP:
+		return &smtpNotifier{cfg: cfg.SMTP}
+	case cfg.Sink == sinkFile:
+		return &fileNotifier{path: cfg.File}
+	case cfg.Sink == sinkLog && serving:
+		return logNotifier{}
+	default:
+		return nil
+	}
+}
+
+// Helper function to lay out a message with its headers, as the file and log sinks show it
+func formatMessage(to Recipient, subject, body string) string {
+	recipient := to.Username
+	if to.Email != "" {
+		recipient = fmt.Sprintf("%s <%s>", to.Username, to.Email)
+	}
+	return fmt.Sprintf("Date: %s\nTo: %s\nSubject: %s\n\n%s", time.Now().Format(time.RFC1123Z), recipient, subject, body)
+}
+
+type smtpNotifier struct {
+	cfg SMTPConfig
+}
+
+// Notify sends the message by email, authenticating when a username is configured
+func (n *smtpNotifier) Notify(to Recipient, subject, body string) error {
+	if to.Email == "" {
+		return ErrNoEmail
+	}
+	var auth smtp.Auth
+	if n.cfg.Username != "" {
+		host := n.cfg.Addr
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", n.cfg.Username, n.cfg.Password, host)
+	}
+	message := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s",
+		n.cfg.From, to.Email, subject, time.Now().Format(time.RFC1123Z), strings.ReplaceAll(body, "\n", "\r\n"))
+	return smtp.SendMail(n.cfg.Addr, auth, n.cfg.From, []string{to.Email}, []byte(message))
+}
+
+type fileNotifier struct {
+	path string
+	mu   sync.Mutex // API requests may notify at the same time
+}
+
+// Notify appends the message to the file, separated from the one before by a blank line
+func (n *fileNotifier) Notify(to Recipient, subject, body string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	f, err := os.OpenFile(n.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(f, "%s\n\n", formatMessage(to, subject, body)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+type logNotifier struct{}
+
+// Notify writes the message to the program's log
+func (logNotifier) Notify(to Recipient, subject, body string) error {
+	log.Printf("Message for %s:\n%s", to.Username, formatMessage(to, subject, body))
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// Project menu
+func projectMenu(store ProjectStore, actor Actor) {
+	for {
+		projects, err := store.Projects(actor)
+		if err != nil {
+			log.Println("Error loading projects:", err)
+			return
+		}
+		printProjects(projects)
+
+		fmt.Println("\nProject Menu:")
+		fmt.Println("---------------------------------")
+		fmt.Println("1 - Create Project")
+		fmt.Println("2 - Rename Project")
+		fmt.Println("3 - Archive or Unarchive Project")
+		fmt.Println("4 - Delete Project")
+		fmt.Println("5 - Back")
+
+		fmt.Print("Enter your choice: ")
+		choiceInput, _ := stdin.ReadString('\n')
+		choice, err := strconv.Atoi(sanitizeInput(choiceInput))
+		if err != nil {
+			log.Println("Invalid input. Please enter a number.")
+			continue
+		}
+
+		switch choice {
+		case 1:
+			fmt.Print("Enter project name: ")
+			name, _ := stdin.ReadString('\n')
+			_, err = store.CreateProject(actor, name)
+		case 2:
+			fmt.Print("Enter project to rename: ")
+			oldName, _ := stdin.ReadString('\n')
+			fmt.Print("Enter new project name: ")
+			newName, _ := stdin.ReadString('\n')
+			err = store.RenameProject(actor, oldName, newName)
+		case 3:
+			fmt.Print("Enter project to archive or unarchive: ")
+			name, _ := stdin.ReadString('\n')
+			project, ok := projectNamed(projects, name)
+			if !ok {
+				fmt.Println("Error:", ErrProjectNotFound)
+				continue
+			}
+			err = store.ArchiveProject(actor, project.Name, !project.Archived)
+		case 4:
+			fmt.Print("Enter project to delete: ")
+			name, _ := stdin.ReadString('\n')
+			project, ok := projectNamed(projects, name)
+			if !ok {
+				fmt.Println("Error:", ErrProjectNotFound)
+				continue
+			}
+			withTasks := false
+			if project.Open+project.Done > 0 {
+				fmt.Printf("Move its %d tasks to the trash (D) or keep them outside any project (K)? ", project.Open+project.Done)
+				tasksInput, _ := stdin.ReadString('\n')
+				switch strings.ToUpper(sanitizeInput(tasksInput)) {
+				case "D":
+					withTasks = true
+				case "K":
+				default:
+					fmt.Println("Project not deleted.")
+					continue
+				}
+			}
+			err = store.DeleteProject(actor, project.Name, withTasks)
+		case 5:
+			return
+		default:
+			fmt.Println("Invalid choice. Please try again.")
+			continue
+		}
+
+		if err != nil {
+			reportProjectError(err)
+			continue
+		}
+		fmt.Println("Projects updated successfully!")
+	}
+}
+
+// Helper function to find a project in a list by name, ignoring case
+func projectNamed(projects []Project, name string) (Project, bool) {
+	name = sanitizeInput(name)
+	for _, project := range projects {
+		if strings.EqualFold(project.Name, name) {
+			return project, true
+		}
+	}
+	return Project{}, false
+}
+
+// Helper function to print a workspace's projects with their open and complete task counts
+func printProjects(projects []Project) {
+	fmt.Println("---------------------------------")
+	fmt.Println("YOUR PROJECTS:")
+	if len(projects) == 0 {
+		fmt.Println(" (none yet)")
+	}
+	for _, project := range projects {
+		line := fmt.Sprintf(" %s: %d open, %d complete", project.Name, project.Open, project.Done)
+		if project.Archived {
+			line += " [ARCHIVED]"
+		}
+		fmt.Println(line)
+	}
+}
+
+// Helper function to explain why a project change was rejected
+func reportProjectError(err error) {
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) || errors.Is(err, ErrProjectNotFound) || err == ErrProjectExists || errors.Is(err, ErrForbidden) {
+		fmt.Println("Error:", err)
+		return
+	}
+	log.Println("Error saving projects:", err)
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Project groups a workspace's tasks, e.g. all the work for one client
+type Project struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Archived bool   `json:"archived"` // archived projects and their tasks are left out of task lists
+	Open     int    `json:"open"`     // number of tasks not yet complete
+	Done     int    `json:"done"`     // number of complete tasks
+}
+
+var (
+	// ErrProjectNotFound is returned for a project name the workspace doesn't have
+	ErrProjectNotFound = errors.New("project not found")
+	// ErrProjectExists is returned when creating or renaming a project to a name already in use
+	ErrProjectExists = errors.New("a project with that name already exists")
+	// ErrProjectArchived is returned when adding tasks to an archived project
+	ErrProjectArchived = errors.New("project is archived; unarchive it first")
+	// ErrSubtaskProject is returned when moving a subtask to another project than its parent's
+	ErrSubtaskProject = errors.New("subtasks belong to their parent's project; move the parent task instead")
+)
+
+// ProjectStore manages a workspace's projects. Changing them needs the admin role.
+type ProjectStore interface {
+	Projects(actor Actor) ([]Project, error)
+	CreateProject(actor Actor, name string) (Project, error)
+	RenameProject(actor Actor, oldName, newName string) error
+	ArchiveProject(actor Actor, name string, archived bool) error
+	DeleteProject(actor Actor, name string, withTasks bool) error
+}
+
+// Function to check a project name as typed by a user. Names keep their
+// case but are compared case-insensitively.
+func normalizeProjectName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	switch {
+	case name == "":
+		return "", &ValidationError{Field: "project", Err: errors.New("project name must not be empty")}
+	case len(name) > 50:
+		return "", &ValidationError{Field: "project", Err: errors.New("project name must be at most 50 characters long")}
+	}
+	return name, nil
+}
+
+// Function to look up one of the workspace's projects by name
+func findProject(db queryer, workspaceID int, name string) (int, bool, error) {
+	name, err := normalizeProjectName(name)
+	if err != nil {
+		return 0, false, err
+	}
+	var projectID int
+	var archivedAt sql.NullTime
+	query := `SELECT project_id, archived_at FROM project WHERE workspace_id = $1 AND LOWER(name) = LOWER($2)`
+	err = db.QueryRow(query, workspaceID, name).Scan(&projectID, &archivedAt)
+	if err == sql.ErrNoRows {
+		return 0, false, fmt.Errorf("%w: %q", ErrProjectNotFound, name)
+	}
+	return projectID, archivedAt.Valid, err
+}
+
+// Function to find the project a task is put in by name; "" means no project
+func taskProject(db queryer, workspaceID int, name string) (*int, error) {
+	if strings.TrimSpace(name) == "" {
+		return nil, nil
+	}
+	projectID, archived, err := findProject(db, workspaceID, name)
+	if errors.Is(err, ErrProjectNotFound) {
+		return nil, &ValidationError{Field: "project", Err: err}
+	} else if err != nil {
+		return nil, err
+	}
+	if archived {
+		return nil, &ValidationError{Field: "project", Err: ErrProjectArchived}
+	}
+	return &projectID, nil
+}
+
+// Function to get the project of a parent task, which its subtasks share
+func parentProject(db queryer, workspaceID, parentID int) (*int, error) {
+	var projectID sql.NullInt64
+	err := db.QueryRow(`SELECT project_id FROM "task" WHERE task_id = $1 AND workspace_id = $2`, parentID, workspaceID).Scan(&projectID)
+	if err != nil || !projectID.Valid {
+		return nil, err
+	}
+	id := int(projectID.Int64)
+	return &id, nil
+}
+
+// Function to move a task and all its subtasks to a project (nil for none)
+func moveToProject(db queryer, workspaceID, taskID int, projectID *int) error {
+	query := `
+	UPDATE "task" SET project_id = $1, updated_at = CURRENT_TIMESTAMP
+	WHERE workspace_id = $2 AND task_id IN (` + subtreeQuery + `)`
+	_, err := db.Exec(query, projectID, workspaceID, taskID)
+	return err
+}
+
+// Function to read the names of the projects the given tasks are in, keyed by project ID
+func loadProjectNames(db queryer, workspaceID int, taskIDs []int) (map[int]string, error) {
+	args := []interface{}{workspaceID}
+	query := `
+	SELECT project_id, name FROM project WHERE workspace_id = $1 AND project_id IN (
+		SELECT project_id FROM "task" WHERE task_id IN (` + idList(&args, taskIDs) + `))`
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	names := make(map[int]string)
+	for rows.Next() {
+		var projectID int
+		var name string
+		if err := rows.Scan(&projectID, &name); err != nil {
+			return nil, err
+		}
+		names[projectID] = name
+	}
+	return names, rows.Err()
+}
+
+// Function to build the SQL condition for the project being listed (0 for
+// all), appending its arguments after the workspace ID in args[0]. Without a
+// project, tasks in archived projects are left out.
+func projectCondition(args *[]interface{}, projectID int) string {
+	if projectID != 0 {
+		*args = append(*args, projectID)
+		return ` AND project_id = ` + placeholder(*args)
+	}
+	*args = append(*args, (*args)[0])
+	return ` AND (project_id IS NULL OR project_id NOT IN (
+		SELECT project_id FROM project WHERE workspace_id = ` + placeholder(*args) + ` AND archived_at IS NOT NULL))`
+}
+
+// Projects returns every project in the workspace, with how many of its tasks are open and complete
+func (s *sqlTaskStore) Projects(actor Actor) ([]Project, error) {
+	db := s.router.Reader(actor.UserID)
+	if _, err := requireRole(db, actor, RoleViewer); err != nil {
+		return nil, err
+	}
+	workflow, err := loadWorkflow(db, actor.WorkspaceID)
+	if err != nil {
+		return nil, err
+	}
+	query := `
+	SELECT p.project_id, p.name, p.archived_at, t.status FROM project p
+	LEFT JOIN "task" t ON t.project_id = p.project_id AND t.deleted_at IS NULL
+	WHERE p.workspace_id = $1 ORDER BY LOWER(p.name), p.project_id`
+	rows, err := db.Query(query, actor.WorkspaceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var projects []Project
+	for rows.Next() {
+		var project Project
+		var archivedAt sql.NullTime
+		var status sql.NullString
+		if err := rows.Scan(&project.ID, &project.Name, &archivedAt, &status); err != nil {
+			return nil, err
+		}
+		if n := len(projects); n == 0 || projects[n-1].ID != project.ID {
+			project.Archived = archivedAt.Valid
+			projects = append(projects, project)
+		}
+		if !status.Valid {
+			continue // a project without tasks
+		}
+		if workflow.IsDone(status.String) {
+			projects[len(projects)-1].Done++
+		} else {
+			projects[len(projects)-1].Open++
+		}
+	}
+	return projects, rows.Err()
+}
+
+// CreateProject adds an empty project
+func (s *sqlTaskStore) CreateProject(actor Actor, name string) (Project, error) {
+	name, err := normalizeProjectName(name)
+	if err != nil {
+		return Project{}, err
+	}
+
+	tx, err := s.router.Writer(actor.UserID).Begin()
+	if err != nil {
+		return Project{}, err
+	}
+	defer tx.Rollback()
+
+	if _, err := requireRole(tx, actor, RoleAdmin); err != nil {
+		return Project{}, err
+	}
+	if _, _, err := findProject(tx, actor.WorkspaceID, name); err == nil {
+		return Project{}, ErrProjectExists
+	} else if !errors.Is(err, ErrProjectNotFound) {
+		return Project{}, err
+	}
+	project := Project{Name: name}
+	query := `INSERT INTO project (workspace_id, user_id, name) VALUES ($1, $2, $3) RETURNING project_id`
+	if err := tx.QueryRow(query, actor.WorkspaceID, actor.UserID, name).Scan(&project.ID); err != nil {
+		return Project{}, err
+	}
+	return project, tx.Commit()
+}
+
+// RenameProject changes a project's name; its tasks stay in it
+func (s *sqlTaskStore) RenameProject(actor Actor, oldName, newName string) error {
+	newName, err := normalizeProjectName(newName)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.router.Writer(actor.UserID).Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := requireRole(tx, actor, RoleAdmin); err != nil {
+		return err
+	}
+	projectID, _, err := findProject(tx, actor.WorkspaceID, oldName)
+	if err != nil {
+		return err
+	}
+	taskIDs, err := queryIDs(tx, `SELECT task_id FROM "task" WHERE project_id = $1`, projectID)
+	if err != nil {
+		return err
+	}
+	before, err := s.snapshot(tx, actor.WorkspaceID, taskIDs)
+	if err != nil {
+		return err
+	}
+	// Changing only the case of the name is fine; taking another project's name isn't
+	if otherID, _, err := findProject(tx, actor.WorkspaceID, newName); err == nil && otherID != projectID {
+		return ErrProjectExists
+	} else if err != nil && !errors.Is(err, ErrProjectNotFound) {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE project SET name = $1 WHERE project_id = $2`, newName, projectID); err != nil {
+		return err
+	}
+	if err := s.recordChanges(tx, actor, before); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ArchiveProject archives a project, or brings an archived one back
+func (s *sqlTaskStore) ArchiveProject(actor Actor, name string, archived bool) error {
+	db := s.router.Writer(actor.UserID)
+	if _, err := requireRole(db, actor, RoleAdmin); err != nil {
+		return err
+	}
+	projectID, _, err := findProject(db, actor.WorkspaceID, name)
+	if err != nil {
+		return err
+	}
+	query := `UPDATE project SET archived_at = CURRENT_TIMESTAMP WHERE project_id = $1 AND archived_at IS NULL`
+	if !archived {
+		query = `UPDATE project SET archived_at = NULL WHERE project_id = $1`
+	}
+	_, err = db.Exec(query, projectID)
+	return err
+}
+
+// DeleteProject deletes a project. Its tasks are moved to the trash if
+// withTasks is set, and otherwise kept without a project.
+func (s *sqlTaskStore) DeleteProject(actor Actor, name string, withTasks bool) error {
+	tx, err := s.router.Writer(actor.UserID).Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := requireRole(tx, actor, RoleAdmin); err != nil {
+		return err
+	}
+	projectID, _, err := findProject(tx, actor.WorkspaceID, name)
+	if err != nil {
+		return err
+	}
+	// Trashing the project's tasks also changes what the tasks they block are blocked by
+	taskIDs, err := queryIDs(tx, `SELECT task_id FROM "task" WHERE project_id = $1`, projectID)
+	if err != nil {
+		return err
+	}
+	if withTasks {
+		if taskIDs, err = taskFamily(tx, actor.WorkspaceID, taskIDs...); err != nil {
+			return err
+		}
+	}
+	before, err := s.snapshot(tx, actor.WorkspaceID, taskIDs)
+	if err != nil {
+		return err
+	}
+	if withTasks {
+		// Subtasks share their parent's project, so whole trees go to the trash at once
+		query := `UPDATE "task" SET deleted_at = $1 WHERE project_id = $2 AND workspace_id = $3 AND deleted_at IS NULL`
+		if _, err := tx.Exec(query, time.Now().UTC(), projectID, actor.WorkspaceID); err != nil {
+			return err
+		}
+	}
+	// The project_id foreign key takes any remaining tasks, and those in the trash, out of the project
+	if _, err := tx.Exec(`DELETE FROM project WHERE project_id = $1`, projectID); err != nil {
+		return err
+	}
+	if err := s.recordChanges(tx, actor, before); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
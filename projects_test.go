@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestProjects(t *testing.T) {
+	router := newTestRouter(t)
+	store := newSQLTaskStore(router, 0)
+	userID, workspaceID := newTestUser(t, router, "alice")
+	actor := Actor{UserID: userID, WorkspaceID: workspaceID}
+
+	for _, name := range []string{"Garden", "Office"} {
+		if _, err := store.CreateProject(actor, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := store.CreateProject(actor, "garden"); !errors.Is(err, ErrProjectExists) {
+		t.Errorf("creating garden next to Garden = %v; want %v", err, ErrProjectExists)
+	}
+
+	weed, err := store.Create(actor, Task{Title: "Weed", Project: "Garden"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	roses, err := store.Create(actor, Task{Title: "Roses", ParentID: &weed.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := getTask(t, store, actor, roses.ID); got.Project != "Garden" {
+		t.Errorf("subtask is in project %q; want its parent's Garden", got.Project)
+	}
+	if _, err := store.Create(actor, Task{Title: "Mow", Project: "Garden"}); err != nil {
+		t.Fatal(err)
+	}
+	completeTask(t, store, actor, roses.ID)
+
+	projects, err := store.Projects(actor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string][2]int)
+	for _, project := range projects {
+		counts[project.Name] = [2]int{project.Open, project.Done}
+	}
+	if want := map[string][2]int{"Garden": {2, 1}, "Office": {0, 0}}; !reflect.DeepEqual(counts, want) {
+		t.Errorf("open and done per project = %v; want %v", counts, want)
+	}
+
+	// Moving a task takes its subtasks along; subtasks can't move on their own
+	office := "Office"
+	if _, err := store.Update(actor, roses.ID, TaskUpdate{Project: &office}); !errors.Is(err, ErrSubtaskProject) {
+		t.Errorf("moving a subtask = %v; want %v", err, ErrSubtaskProject)
+	}
+	if _, err := store.Update(actor, weed.ID, TaskUpdate{Project: &office}); err != nil {
+		t.Fatal(err)
+	}
+	if got := getTask(t, store, actor, roses.ID); got.Project != "Office" {
+		t.Errorf("subtask is in project %q after moving its parent; want Office", got.Project)
+	}
+
+	list := func(filter TaskFilter) []string {
+		t.Helper()
+		tasks, err := store.List(actor, filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return taskTitles(tasks)
+	}
+	if err := store.ArchiveProject(actor, "Office", true); err != nil {
+		t.Fatal(err)
+	}
+	if got := list(TaskFilter{}); !reflect.DeepEqual(got, []string{"Mow"}) {
+		t.Errorf("tasks listed with Office archived = %q; want [Mow]", got)
+	}
+	if got := list(TaskFilter{Project: "office"}); !reflect.DeepEqual(got, []string{"Roses", "Weed"}) {
+		t.Errorf("tasks of the archived Office = %q; want [Roses Weed]", got)
+	}
+	if _, err := store.Create(actor, Task{Title: "File", Project: "Office"}); !errors.Is(err, ErrProjectArchived) {
+		t.Errorf("adding a task to an archived project = %v; want %v", err, ErrProjectArchived)
+	}
+	if err := store.ArchiveProject(actor, "Office", false); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.RenameProject(actor, "Garden", "Office"); !errors.Is(err, ErrProjectExists) {
+		t.Errorf("renaming onto an existing project = %v; want %v", err, ErrProjectExists)
+	}
+	if err := store.RenameProject(actor, "Garden", "Yard"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Deleting a project keeps its tasks outside any project unless they go too
+	if err := store.DeleteProject(actor, "Yard", false); err != nil {
+		t.Fatal(err)
+	}
+	if got := list(TaskFilter{}); !reflect.DeepEqual(got, []string{"Mow", "Roses", "Weed"}) {
+		t.Errorf("tasks after deleting Yard = %q; want [Mow Roses Weed]", got)
+	}
+	if err := store.DeleteProject(actor, "Office", true); err != nil {
+		t.Fatal(err)
+	}
+	if got := list(TaskFilter{}); !reflect.DeepEqual(got, []string{"Mow"}) {
+		t.Errorf("tasks after deleting Office with its tasks = %q; want [Mow]", got)
+	}
+	if err := store.DeleteProject(actor, "Office", false); !errors.Is(err, ErrProjectNotFound) {
+		t.Errorf("deleting a deleted project = %v; want %v", err, ErrProjectNotFound)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Most security questions password_reset.security_questions can ask for,
+// leaving users a choice among the catalog's
+const maxSecurityQuestions = 5
+
+var (
+	// ErrWrongAnswers is returned when a password reset gets a security question wrong
+	ErrWrongAnswers = errors.New("wrong answer to a security question")
+	// ErrQuestionsOff is returned for changing security questions while password_reset.security_questions is 0
+	ErrQuestionsOff = errors.New("security questions are turned off")
+)
+
+// SecurityQuestion is a question from the catalog users pick theirs from
+type SecurityQuestion struct {
+	ID   int    `json:"id"`
+	Text string `json:"text"`
+}
+
+// SecurityAnswer is a user's answer to one question of the catalog
+type SecurityAnswer struct {
+	QuestionID int    `json:"question_id"`
+	Answer     string `json:"answer"`
+}
+
+// Helper function to put an answer in the form it is hashed in, so that
+// "New  York" and "new york" match
+func normalizeAnswer(answer string) string {
+	return strings.Join(strings.Fields(strings.ToLower(answer)), " ")
+}
+
+// Function to get the questions users can pick from
+func questionCatalog(db queryer) ([]SecurityQuestion, error) {
+	return scanQuestions(db.Query(`SELECT question_id, text FROM security_question ORDER BY question_id`))
+}
+
+// Function to get the questions a user picked
+func chosenQuestions(db queryer, userID int) ([]SecurityQuestion, error) {
+	query := `
+	SELECT q.question_id, q.text FROM security_question q
+	JOIN user_security_answer a ON a.question_id = q.question_id
+	WHERE a.user_id = $1 ORDER BY q.question_id`
+	return scanQuestions(db.Query(query, userID))
+}
+
+// Helper function to read the rows of a security question query
+func scanQuestions(rows *sql.Rows, err error) ([]SecurityQuestion, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var questions []SecurityQuestion
+	for rows.Next() {
+		var question SecurityQuestion
+		if err := rows.Scan(&question.ID, &question.Text); err != nil {
+			return nil, err
+		}
+		questions = append(questions, question)
+	}
+	return questions, rows.Err()
+}
+
+// Function to check that answers cover exactly the required number of
+// distinct questions from the catalog
+func validateAnswers(db queryer, answers []SecurityAnswer, required int) error {
+	if len(answers) != required {
+		return &ValidationError{Field: "security_answers", Err: fmt.Errorf("answer exactly %d security questions", required)}
+	}
+	catalog, err := questionCatalog(db)
+	if err != nil {
+		return err
+	}
+	known := make(map[int]bool, len(catalog))
+	for _, question := range catalog {
+		known[question.ID] = true
+	}
+	seen := make(map[int]bool, len(answers))
+	for _, answer := range answers {
+		switch normalized := normalizeAnswer(answer.Answer); {
+		case !known[answer.QuestionID]:
+			return &ValidationError{Field: "security_answers", Err: fmt.Errorf("there is no security question %d", answer.QuestionID)}
+		case seen[answer.QuestionID]:
+			return &ValidationError{Field: "security_answers", Err: errors.New("pick a different question for each answer")}
+		case normalized == "":
+			return &ValidationError{Field: "security_answers", Err: errors.New("answers can't be empty")}
+		case len(normalized) > 72: // bcrypt ignores the rest
+			return &ValidationError{Field: "security_answers", Err: errors.New("answers must be at most 72 characters long")}
+		}
+		seen[answer.QuestionID] = true
+	}
+	return nil
+}
+
+// Function to replace a user's questions and answers, hashing the answers
+// like passwords. The answers must have been validated.
+func saveAnswers(db queryer, userID int, answers []SecurityAnswer) error {
+	if _, err := db.Exec(`DELETE FROM user_security_answer WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	for _, answer := range answers {
+		hashedAnswer, err := hashPassword(normalizeAnswer(answer.Answer))
+		if err != nil {
+			return fmt.Errorf("hashing answer: %w", err)
+		}
+		query := `INSERT INTO user_security_answer (user_id, question_id, answer_hash) VALUES ($1, $2, $3)`
+		if _, err := db.Exec(query, userID, answer.QuestionID, hashedAnswer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Function to check answers against every question the user picked. Users who
+// picked fewer than the required number of questions, such as those who signed
+// up before the questions were turned on, fail: the reset code alone mustn't do.
+func checkAnswers(db queryer, userID int, answers []SecurityAnswer, required int) (bool, error) {
+	rows, err := db.Query(`SELECT question_id, answer_hash FROM user_security_answer WHERE user_id = $1`, userID)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	hashes := make(map[int]string)
+	for rows.Next() {
+		var questionID int
+		var hash string
+		if err := rows.Scan(&questionID, &hash); err != nil {
+			return false, err
+		}
+		hashes[questionID] = hash
+	}
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+	if len(hashes) < required {
+		return false, nil
+	}
+
+	given := make(map[int]string, len(answers))
+	for _, answer := range answers {
+		given[answer.QuestionID] = answer.Answer
+	}
+	for questionID, hash := range hashes {
+		answer, ok := given[questionID]
+		if !ok || !checkPasswordHash(normalizeAnswer(answer), hash) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// SetAnswers replaces the logged-in user's security questions and answers
+// after checking their password
+func (s *resetStore) SetAnswers(userID int, password string, answers []SecurityAnswer) error {
+	if s.questions == 0 {
+		return ErrQuestionsOff
+	}
+	db := s.router.Writer(userID)
+	if err := checkCurrentPassword(db, userID, password); err != nil {
+		return err
+	}
+	if err := validateAnswers(db, answers, s.questions); err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := saveAnswers(tx, userID, answers); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNormalizeAnswer(t *testing.T) {
+	for input, want := range map[string]string{
+		"New York":       "new york",
+		"  new   YORK\t": "new york",
+		"":               "",
+	} {
+		if got := normalizeAnswer(input); got != want {
+			t.Errorf("normalizeAnswer(%q) = %q; want %q", input, got, want)
+		}
+	}
+}
+
+func TestSecurityQuestions(t *testing.T) {
+	router := newTestRouter(t)
+	notifier := new(testNotifier)
+	resets := newResetStore(router, notifier, ResetConfig{CodeTTL: time.Hour, SecurityQuestions: 2})
+	catalog, err := questionCatalog(router.Primary())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(catalog) < 3 {
+		t.Fatalf("the catalog has %d questions; want at least 3", len(catalog))
+	}
+	first, second := catalog[0].ID, catalog[1].ID
+
+	invalid := [][]SecurityAnswer{
+		{{first, "Lisbon"}},
+		{{first, "Lisbon"}, {first, "Porto"}},
+		{{first, "Lisbon"}, {second, "   "}},
+		{{first, "Lisbon"}, {-1, "Porto"}},
+	}
+	for _, answers := range invalid {
+		var validationErr *ValidationError
+		_, err := createUser(router, "alice", "Passw0rd!", "", answers, 2)
+		if !errors.As(err, &validationErr) || validationErr.Field != "security_answers" {
+			t.Errorf("signing up with answers %v = %v; want a validation error for security_answers", answers, err)
+		}
+	}
+	if _, err := createUser(router, "alice", "Passw0rd!", "", []SecurityAnswer{{first, "New York"}, {second, "Rex"}}, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	code := requestResetCode(t, resets, notifier, "alice")
+	questions, err := resets.Questions("alice", code)
+	if err != nil || len(questions) != 2 || questions[0].ID != first || questions[1].ID != second {
+		t.Errorf("questions with the code = %v, %v; want the two alice picked", questions, err)
+	}
+	wrong := []SecurityAnswer{{first, "New York"}, {second, "Fido"}}
+	if err := resets.Reset("alice", code, wrong, "N3wPassw0rd!"); !errors.Is(err, ErrWrongAnswers) {
+		t.Errorf("resetting with a wrong answer = %v; want %v", err, ErrWrongAnswers)
+	}
+	// The code survives a wrong answer, and answers match whatever their case and spacing
+	right := []SecurityAnswer{{first, "  new   YORK "}, {second, "rex"}}
+	if err := resets.Reset("alice", code, right, "N3wPassw0rd!"); err != nil {
+		t.Errorf("resetting with the right answers: %v", err)
+	}
+
+	// A user who never picked questions can't reset with the code alone
+	newTestUser(t, router, "bob")
+	code = requestResetCode(t, resets, notifier, "bob")
+	if err := resets.Reset("bob", code, nil, "N3wPassw0rd!"); !errors.Is(err, ErrWrongAnswers) {
+		t.Errorf("resetting without picked questions = %v; want %v", err, ErrWrongAnswers)
+	}
+}
+
+func TestSetAnswers(t *testing.T) {
+	router := newTestRouter(t)
+	userID, _ := newTestUser(t, router, "alice")
+	catalog, err := questionCatalog(router.Primary())
+	if err != nil {
+		t.Fatal(err)
+	}
+	answers := []SecurityAnswer{{catalog[2].ID, "Blue"}}
+
+	off := newResetStore(router, nil, ResetConfig{CodeTTL: time.Hour})
+	if err := off.SetAnswers(userID, "Passw0rd!", answers); !errors.Is(err, ErrQuestionsOff) {
+		t.Errorf("picking questions while they are off = %v; want %v", err, ErrQuestionsOff)
+	}
+	on := newResetStore(router, nil, ResetConfig{CodeTTL: time.Hour, SecurityQuestions: 1})
+	if err := on.SetAnswers(userID, "Wr0ngpass!", answers); err == nil {
+		t.Error("picking questions with the wrong password succeeded")
+	}
+	if err := on.SetAnswers(userID, "Passw0rd!", answers); err != nil {
+		t.Fatal(err)
+	}
+	chosen, err := chosenQuestions(router.Primary(), userID)
+	if err != nil || len(chosen) != 1 || chosen[0].ID != catalog[2].ID {
+		t.Errorf("chosen questions = %v, %v; want question %d", chosen, err, catalog[2].ID)
+	}
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Recurrence is the subset of an RFC 5545 RRULE that tasks can repeat by:
+// FREQ (DAILY, WEEKLY, MONTHLY or YEARLY), INTERVAL, BYDAY (weekly rules),
+// BYMONTHDAY (monthly rules, negative days count from the end of the month),
+// and COUNT or UNTIL to end the series. Occurrences keep the wall-clock time
+// of the first due date in its zone, so they don't shift when DST starts or
+// ends. As in RFC 5545, dates that don't exist (e.g. the 31st in a 30-day
+// month, or 29 February outside leap years) are skipped; use BYMONTHDAY=-1
+// for "the last day of every month".
+type Recurrence struct {
+	Freq       string
+	Interval   int
+	ByDay      []time.Weekday
+	ByMonthDay []int
+	Count      int        // total number of occurrences, 0 for no limit
+	Until      *time.Time // last allowed occurrence, nil for no limit
+}
+
+var (
+	// ErrNoMoreOccurrences is returned when skipping the last occurrence of a series
+	ErrNoMoreOccurrences = errors.New("this is the last occurrence of the series")
+	// ErrRecurrenceNeedsDue is returned when a recurring task has no due date to repeat from
+	ErrRecurrenceNeedsDue = errors.New("recurring tasks need a due date")
+	// ErrSkipWithChanges is returned when skipping an occurrence and changing the schedule at once
+	ErrSkipWithChanges = errors.New("skipping an occurrence can't be combined with changing the due date or rule")
+)
+
+var weekdayCodes = map[string]time.Weekday{
+	"MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday, "TH": time.Thursday,
+	"FR": time.Friday, "SA": time.Saturday, "SU": time.Sunday,
+}
+
+// Shorthands accepted in place of a full rule
+var recurrenceShorthands = map[string]string{
+	"daily":    "FREQ=DAILY",
+	"weekdays": "FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR",
+	"weekly":   "FREQ=WEEKLY",
+	"monthly":  "FREQ=MONTHLY",
+	"yearly":   "FREQ=YEARLY",
+}
+
+// ParseRecurrence reads a rule such as "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH",
+// with or without a leading "RRULE:", or one of the shorthands daily,
+// weekdays, weekly, monthly and yearly
+func ParseRecurrence(input string) (Recurrence, error) {
+	input = strings.TrimSpace(input)
+	if rule, ok := recurrenceShorthands[strings.ToLower(input)]; ok {
+		input = rule
+	}
+	input = strings.TrimPrefix(strings.ToUpper(input), "RRULE:")
+
+	r := Recurrence{Interval: 1}
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(input, ";") {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok || value == "" {
+			return Recurrence{}, fmt.Errorf("invalid rule part %q; expected NAME=VALUE", part)
+		}
+		if seen[key] {
+			return Recurrence{}, fmt.Errorf("%s is given twice", key)
+		}
+		seen[key] = true
+
+		switch key {
+		case "FREQ":
+			switch value {
+			case "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+				r.Freq = value
+			default:
+				return Recurrence{}, fmt.Errorf("FREQ must be DAILY, WEEKLY, MONTHLY or YEARLY, got %q", value)
+			}
+		case "INTERVAL":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 || n > 1000 {
+				return Recurrence{}, fmt.Errorf("INTERVAL must be a whole number from 1 to 1000, got %q", value)
+			}
+			r.Interval = n
+		case "BYDAY":
+			for _, code := range strings.Split(value, ",") {
+				day, ok := weekdayCodes[code]
+				if !ok {
+					return Recurrence{}, fmt.Errorf("BYDAY must list days such as MO,WE,FR, got %q", code)
+				}
+				r.ByDay = append(r.ByDay, day)
+			}
+		case "BYMONTHDAY":
+			for _, field := range strings.Split(value, ",") {
+				day, err := strconv.Atoi(field)
+				if err != nil || day == 0 || day < -31 || day > 31 {
+					return Recurrence{}, fmt.Errorf("BYMONTHDAY must list days from 1 to 31 or -1 to -31, got %q", field)
+				}
+				r.ByMonthDay = append(r.ByMonthDay, day)
+			}
+		case "COUNT":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return Recurrence{}, fmt.Errorf("COUNT must be a positive whole number, got %q", value)
+			}
+			r.Count = n
+		case "UNTIL":
+			until, err := parseUntil(value)
+			if err != nil {
+				return Recurrence{}, err
+			}
+			r.Until = &until
+		default:
+			return Recurrence{}, fmt.Errorf("unsupported rule part %s; use FREQ, INTERVAL, BYDAY, BYMONTHDAY, COUNT or UNTIL", key)
+		}
+	}
+
+	switch {
+	case r.Freq == "":
+		return Recurrence{}, errors.New("FREQ is required")
+	case len(r.ByDay) > 0 && r.Freq != "WEEKLY":
+		return Recurrence{}, errors.New("BYDAY is only supported with FREQ=WEEKLY")
+	case len(r.ByMonthDay) > 0 && r.Freq != "MONTHLY":
+		return Recurrence{}, errors.New("BYMONTHDAY is only supported with FREQ=MONTHLY")
+	case r.Count > 0 && r.Until != nil:
+		return Recurrence{}, errors.New("COUNT and UNTIL can't be combined")
+	}
+	sort.Slice(r.ByDay, func(i, j int) bool { return weekdayIndex(r.ByDay[i]) < weekdayIndex(r.ByDay[j]) })
+	return r, nil
+}
+
+// Helper function to read UNTIL as a UTC timestamp (20241231T170000Z) or a
+// date (20241231), which allows occurrences up to the end of that day in UTC
+func parseUntil(value string) (time.Time, error) {
+	if until, err := time.Parse("20060102T150405Z", value); err == nil {
+		return until, nil
+	}
+	if until, err := time.Parse("20060102", value); err == nil {
+		return endOfDay(until), nil
+	}
+	return time.Time{}, fmt.Errorf("UNTIL must look like 20241231 or 20241231T170000Z, got %q", value)
+}
+
+// String formats the rule in its canonical RRULE form, as it is stored
+func (r Recurrence) String() string {
+	parts := []string{"FREQ=" + r.Freq}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if len(r.ByDay) > 0 {
+		var codes []string
+		for _, day := range r.ByDay {
+			codes = append(codes, strings.ToUpper(day.String()[:2]))
+		}
+		parts = append(parts, "BYDAY="+strings.Join(codes, ","))
+	}
+	if len(r.ByMonthDay) > 0 {
+		var days []string
+		for _, day := range r.ByMonthDay {
+			days = append(days, strconv.Itoa(day))
+		}
+		parts = append(parts, "BYMONTHDAY="+strings.Join(days, ","))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if r.Until != nil {
+		parts = append(parts, "UNTIL="+r.Until.UTC().Format("20060102T150405Z"))
+	}
+	return strings.Join(parts, ";")
+}
+
+// Helper function to number weekdays from Monday, the RRULE week start
+func weekdayIndex(day time.Weekday) int {
+	return (int(day) + 6) % 7
+}
+
+// Helper function to get the number of days in a month
+func daysIn(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+// Helper function to build a date with prev's wall-clock time in prev's zone.
+// ok is false when the day doesn't exist in that month.
+func sameTimeOn(prev time.Time, year int, month time.Month, day int) (time.Time, bool) {
+	if day < 1 || day > daysIn(year, month) {
+		return time.Time{}, false
+	}
+	return time.Date(year, month, day, prev.Hour(), prev.Minute(), prev.Second(), 0, prev.Location()), true
+}
+
+// Next returns the occurrence after prev, the due date of occurrence number
+// occurrence (counting from 1). ok is false once the series has ended.
+func (r Recurrence) Next(prev time.Time, occurrence int) (time.Time, bool) {
+	if r.Count > 0 && occurrence >= r.Count {
+		return time.Time{}, false
+	}
+	next, ok := r.after(prev)
+	if !ok || (r.Until != nil && next.After(*r.Until)) {
+		return time.Time{}, false
+	}
+	return next, true
+}
+
+// Helper function to find the first occurrence after prev, ignoring COUNT and UNTIL
+func (r Recurrence) after(prev time.Time) (time.Time, bool) {
+	year, month, day := prev.Date()
+	switch r.Freq {
+	case "DAILY":
+		return time.Date(year, month, day+r.Interval, prev.Hour(), prev.Minute(), prev.Second(), 0, prev.Location()), true
+
+	case "WEEKLY":
+		if len(r.ByDay) == 0 {
+			return time.Date(year, month, day+7*r.Interval, prev.Hour(), prev.Minute(), prev.Second(), 0, prev.Location()), true
+		}
+		// A later listed day in the same week, else the first listed day INTERVAL weeks on
+		today := weekdayIndex(prev.Weekday())
+		for _, d := range r.ByDay {
+			if weekdayIndex(d) > today {
+				return time.Date(year, month, day+weekdayIndex(d)-today, prev.Hour(), prev.Minute(), prev.Second(), 0, prev.Location()), true
+			}
+		}
+		monday := day - today + 7*r.Interval
+		return time.Date(year, month, monday+weekdayIndex(r.ByDay[0]), prev.Hour(), prev.Minute(), prev.Second(), 0, prev.Location()), true
+
+	case "MONTHLY":
+		days := r.ByMonthDay
+		if len(days) == 0 {
+			days = []int{day}
+		}
+		// Later days in the same month first, then every INTERVAL months on;
+		// give up after a long run of months in which none of the days exist
+		for step := 0; step <= 12*r.Interval*4; step += r.Interval {
+			y, m := year, month+time.Month(step)
+			for m > 12 {
+				y, m = y+1, m-12
+			}
+			for _, candidate := range monthDays(days, y, m) {
+				if step == 0 && candidate <= day {
+					continue
+				}
+				if next, ok := sameTimeOn(prev, y, m, candidate); ok {
+					return next, true
+				}
+			}
+		}
+		return time.Time{}, false
+
+	case "YEARLY":
+		// Every INTERVAL years on the same date, skipping years without it (29 February)
+		for y := year + r.Interval; y <= year+8*r.Interval; y += r.Interval {
+			if next, ok := sameTimeOn(prev, y, month, day); ok {
+				return next, true
+			}
+		}
+		return time.Time{}, false
+	}
+	return time.Time{}, false
+}
+
+// Helper function to resolve BYMONTHDAY values (negative ones count back
+// from the month's end) into the month's actual days, in order
+func monthDays(byMonthDay []int, year int, month time.Month) []int {
+	last := daysIn(year, month)
+	var days []int
+	for _, d := range byMonthDay {
+		if d < 0 {
+			d = last + d + 1
+		}
+		if d >= 1 && d <= last {
+			days = append(days, d)
+		}
+	}
+	sort.Ints(days)
+	return days
+}
+
+// Function to check a task's recurrence rule and put it in canonical form.
+// An empty rule means the task doesn't repeat.
+func normalizeRecurrence(rule string, due *time.Time) (string, error) {
+	if strings.TrimSpace(rule) == "" {
+		return "", nil
+	}
+	r, err := ParseRecurrence(rule)
+	if err != nil {
+		return "", &ValidationError{Field: "recurrence", Err: err}
+	}
+	if due == nil {
+		return "", &ValidationError{Field: "recurrence", Err: ErrRecurrenceNeedsDue}
+	}
+	return r.String(), nil
+}
+
+// Function to create the next instance of a recurring task that was just
+// completed. The rule moves on to the new instance, so the completed task
+// becomes a one-off and completing it again doesn't repeat it twice. The new
+// instance keeps the creator, assignee and watchers of the series.
+func (s *sqlTaskStore) recur(tx queryer, workspaceID int, task Task) error {
+	if task.Recurrence == "" || task.DueAt == nil || task.NextTaskID != nil {
+		return nil
+	}
+	rule, err := ParseRecurrence(task.Recurrence)
+	if err != nil {
+		return err
+	}
+	query := `UPDATE "task" SET recurrence = NULL WHERE task_id = $1 AND workspace_id = $2`
+	if _, err := tx.Exec(query, task.ID, workspaceID); err != nil {
+		return err
+	}
+	next, ok := rule.Next(*task.DueAt, task.Occurrence)
+	if !ok {
+		return nil // that was the last occurrence
+	}
+
+	workflow, err := loadWorkflow(tx, workspaceID)
+	if err != nil {
+		return err
+	}
+	dueAt, dueTZ := dueColumns(&next)
+	query = `
+	INSERT INTO "task" (workspace_id, user_id, assignee_id, title, description, status, priority, due_at, due_tz, parent_id, project_id, recurrence, occurrence)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+	RETURNING task_id`
+	var nextID int
+	err = tx.QueryRow(query, workspaceID, task.UserID, task.AssigneeID, task.Title, task.Description, workflow.Initial(), task.Priority, dueAt, dueTZ,
+		task.ParentID, task.ProjectID, task.Recurrence, task.Occurrence+1).Scan(&nextID)
+	if err != nil {
+		return err
+	}
+	if err := addTaskTags(tx, workspaceID, nextID, task.Tags); err != nil {
+		return err
+	}
+	query = `INSERT INTO task_watcher (task_id, user_id) SELECT $1, user_id FROM task_watcher WHERE task_id = $2`
+	if _, err := tx.Exec(query, nextID, task.ID); err != nil {
+		return err
+	}
+	query = `UPDATE "task" SET next_task_id = $1 WHERE task_id = $2 AND workspace_id = $3`
+	_, err = tx.Exec(query, nextID, task.ID, workspaceID)
+	return err
+}
+
+// Function to find the due date a recurring task moves to when its current
+// occurrence is skipped
+func skipOccurrence(task Task) (time.Time, error) {
+	if task.Recurrence == "" || task.DueAt == nil {
+		return time.Time{}, &ValidationError{Field: "recurrence", Err: errors.New("task doesn't repeat")}
+	}
+	rule, err := ParseRecurrence(task.Recurrence)
+	if err != nil {
+		return time.Time{}, err
+	}
+	next, ok := rule.Next(*task.DueAt, task.Occurrence)
+	if !ok {
+		return time.Time{}, &ValidationError{Field: "recurrence", Err: fmt.Errorf("%w; complete it or stop the series instead", ErrNoMoreOccurrences)}
+	}
+	return next, nil
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+	_ "time/tzdata" // the DST cases need America/New_York wherever the tests run
+)
+
+func TestParseRecurrence(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    string // canonical form; empty when the rule is refused
+		wantErr bool
+	}{
+		{"weekly", "FREQ=WEEKLY", false},
+		{" Weekdays ", "FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR", false},
+		{"RRULE:FREQ=WEEKLY;BYDAY=TH,MO;INTERVAL=2", "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH", false},
+		{"freq=monthly;bymonthday=-1", "FREQ=MONTHLY;BYMONTHDAY=-1", false},
+		{"FREQ=DAILY;INTERVAL=1;COUNT=5", "FREQ=DAILY;COUNT=5", false},
+		{"FREQ=DAILY;UNTIL=20241231", "FREQ=DAILY;UNTIL=20241231T235959Z", false},
+		{"FREQ=DAILY;UNTIL=20241231T170000Z", "FREQ=DAILY;UNTIL=20241231T170000Z", false},
+		{"", "", true},
+		{"FREQ=HOURLY", "", true},
+		{"INTERVAL=2", "", true},
+		{"FREQ=DAILY;FREQ=WEEKLY", "", true},
+		{"FREQ=DAILY;INTERVAL=0", "", true},
+		{"FREQ=DAILY;BYDAY=MO", "", true},
+		{"FREQ=WEEKLY;BYDAY=XX", "", true},
+		{"FREQ=WEEKLY;BYMONTHDAY=1", "", true},
+		{"FREQ=MONTHLY;BYMONTHDAY=0", "", true},
+		{"FREQ=MONTHLY;BYMONTHDAY=32", "", true},
+		{"FREQ=DAILY;COUNT=2;UNTIL=20241231", "", true},
+		{"FREQ=DAILY;BYHOUR=9", "", true},
+	}
+	for _, tt := range tests {
+		r, err := ParseRecurrence(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseRecurrence(%q) error = %v; want error %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if err == nil && r.String() != tt.want {
+			t.Errorf("ParseRecurrence(%q) = %s; want %s", tt.input, r.String(), tt.want)
+		}
+	}
+}
+
+func TestRecurrenceNext(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	utc := func(year int, month time.Month, day, hour int) time.Time {
+		return time.Date(year, month, day, hour, 0, 0, 0, time.UTC)
+	}
+	local := func(year int, month time.Month, day, hour int) time.Time {
+		return time.Date(year, month, day, hour, 0, 0, 0, newYork)
+	}
+
+	tests := []struct {
+		name       string
+		rule       string
+		prev       time.Time
+		occurrence int
+		want       []time.Time // the following occurrences; the series ends after the last
+		ended      bool
+	}{
+		{
+			name: "monthly from the 31st skips shorter months",
+			rule: "FREQ=MONTHLY",
+			prev: utc(2024, time.January, 31, 9),
+			want: []time.Time{utc(2024, time.March, 31, 9), utc(2024, time.May, 31, 9), utc(2024, time.July, 31, 9)},
+		},
+		{
+			name: "last day of every month, in a leap year",
+			rule: "FREQ=MONTHLY;BYMONTHDAY=-1",
+			prev: utc(2024, time.January, 31, 9),
+			want: []time.Time{utc(2024, time.February, 29, 9), utc(2024, time.March, 31, 9), utc(2024, time.April, 30, 9)},
+		},
+		{
+			name: "last day of every month, in a common year",
+			rule: "FREQ=MONTHLY;BYMONTHDAY=-1",
+			prev: utc(2023, time.January, 31, 9),
+			want: []time.Time{utc(2023, time.February, 28, 9)},
+		},
+		{
+			name: "several days of the month",
+			rule: "FREQ=MONTHLY;BYMONTHDAY=1,15,-1",
+			prev: utc(2024, time.February, 1, 9),
+			want: []time.Time{utc(2024, time.February, 15, 9), utc(2024, time.February, 29, 9), utc(2024, time.March, 1, 9)},
+		},
+		{
+			name: "the 30th every other month skips February",
+			rule: "FREQ=MONTHLY;INTERVAL=2;BYMONTHDAY=30",
+			prev: utc(2023, time.December, 30, 9),
+			want: []time.Time{utc(2024, time.April, 30, 9)},
+		},
+		{
+			name: "29 February only in leap years",
+			rule: "FREQ=YEARLY",
+			prev: utc(2024, time.February, 29, 9),
+			want: []time.Time{utc(2028, time.February, 29, 9)},
+		},
+		{
+			name: "weekly on listed days, every other week",
+			rule: "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH",
+			prev: utc(2024, time.May, 6, 9), // a Monday
+			want: []time.Time{utc(2024, time.May, 9, 9), utc(2024, time.May, 20, 9), utc(2024, time.May, 23, 9)},
+		},
+		{
+			name: "weekdays over a weekend",
+			rule: "weekdays",
+			prev: utc(2024, time.May, 10, 9), // a Friday
+			want: []time.Time{utc(2024, time.May, 13, 9)},
+		},
+		{
+			name: "daily keeps the wall-clock time when DST starts",
+			rule: "FREQ=DAILY",
+			prev: local(2024, time.March, 9, 9),
+			want: []time.Time{local(2024, time.March, 10, 9), local(2024, time.March, 11, 9)},
+		},
+		{
+			name: "weekly keeps the wall-clock time when DST ends",
+			rule: "FREQ=WEEKLY",
+			prev: local(2024, time.October, 29, 9),
+			want: []time.Time{local(2024, time.November, 5, 9)},
+		},
+		{
+			name: "monthly keeps the wall-clock time across DST",
+			rule: "FREQ=MONTHLY;BYMONTHDAY=-1",
+			prev: local(2024, time.February, 29, 23),
+			want: []time.Time{local(2024, time.March, 31, 23)},
+		},
+		{
+			name:       "COUNT ends the series",
+			rule:       "FREQ=DAILY;COUNT=3",
+			prev:       utc(2024, time.May, 1, 9),
+			occurrence: 1,
+			want:       []time.Time{utc(2024, time.May, 2, 9), utc(2024, time.May, 3, 9)},
+			ended:      true,
+		},
+		{
+			name:  "UNTIL as a date allows that whole day",
+			rule:  "FREQ=DAILY;UNTIL=20240503",
+			prev:  utc(2024, time.May, 1, 23),
+			want:  []time.Time{utc(2024, time.May, 2, 23), utc(2024, time.May, 3, 23)},
+			ended: true,
+		},
+		{
+			name:  "UNTIL as a timestamp",
+			rule:  "FREQ=DAILY;UNTIL=20240503T090000Z",
+			prev:  utc(2024, time.May, 1, 10),
+			want:  []time.Time{utc(2024, time.May, 2, 10)},
+			ended: true,
+		},
+	}
+	for _, tt := range tests {
+		r, err := ParseRecurrence(tt.rule)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		prev, occurrence := tt.prev, tt.occurrence
+		if occurrence == 0 {
+			occurrence = 1
+		}
+		for _, want := range tt.want {
+			next, ok := r.Next(prev, occurrence)
+			if !ok {
+				t.Errorf("%s: the series ended after %s; want %s", tt.name, prev, want)
+				break
+			}
+			if !next.Equal(want) || next.Location() != want.Location() {
+				t.Errorf("%s: after %s got %s; want %s", tt.name, prev, next, want)
+				break
+			}
+			prev, occurrence = next, occurrence+1
+		}
+		if _, ok := r.Next(prev, occurrence); ok == tt.ended {
+			t.Errorf("%s: after %s the series goes on = %v; want %v", tt.name, prev, ok, !tt.ended)
+		}
+	}
+}
+
+func TestNormalizeRecurrence(t *testing.T) {
+	due := time.Date(2024, time.May, 1, 9, 0, 0, 0, time.UTC)
+	if rule, err := normalizeRecurrence("  ", nil); rule != "" || err != nil {
+		t.Errorf("empty rule = %q, %v; want no rule", rule, err)
+	}
+	if rule, err := normalizeRecurrence("monthly", &due); rule != "FREQ=MONTHLY" || err != nil {
+		t.Errorf("monthly = %q, %v; want FREQ=MONTHLY", rule, err)
+	}
+	var validationErr *ValidationError
+	if _, err := normalizeRecurrence("weekly", nil); !errors.As(err, &validationErr) || !errors.Is(err, ErrRecurrenceNeedsDue) {
+		t.Errorf("rule without a due date: %v; want %v", err, ErrRecurrenceNeedsDue)
+	}
+	if _, err := normalizeRecurrence("FREQ=HOURLY", &due); !errors.As(err, &validationErr) || validationErr.Field != "recurrence" {
+		t.Errorf("unsupported rule: %v; want a recurrence validation error", err)
+	}
+}
+
+func TestCompletingRecurringTask(t *testing.T) {
+	router := newTestRouter(t)
+	store := newSQLTaskStore(router, 0)
+	userID, workspaceID := newTestUser(t, router, "carol")
+	actor := Actor{UserID: userID, WorkspaceID: workspaceID}
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	due := time.Date(2024, time.January, 31, 9, 0, 0, 0, newYork)
+	task, err := store.Create(actor, Task{Title: "Pay rent", DueAt: &due, Recurrence: "FREQ=MONTHLY;BYMONTHDAY=-1;COUNT=3", Tags: []string{"home"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := statusDone
+	for _, want := range []time.Time{
+		time.Date(2024, time.February, 29, 9, 0, 0, 0, newYork),
+		time.Date(2024, time.March, 31, 9, 0, 0, 0, newYork), // after DST started
+	} {
+		completed, err := store.Update(actor, task.ID, TaskUpdate{Status: &done})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if completed.Recurrence != "" || completed.NextTaskID == nil {
+			t.Fatalf("completed occurrence %d: recurrence %q, next task %v; want the rule moved to a next task", completed.Occurrence, completed.Recurrence, completed.NextTaskID)
+		}
+		next, err := store.Get(actor, *completed.NextTaskID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if next.DueAt == nil || !next.DueAt.Equal(want) || next.DueAt.Format("15:04 MST") != want.Format("15:04 MST") {
+			t.Errorf("occurrence %d is due %v; want %v", next.Occurrence, next.DueAt, want)
+		}
+		if next.Occurrence != completed.Occurrence+1 || next.Done || len(next.Tags) != 1 || next.Tags[0] != "home" {
+			t.Errorf("next occurrence = %+v; want occurrence %d, open, tagged home", next, completed.Occurrence+1)
+		}
+		task = next
+	}
+
+	// The third occurrence is the last
+	completed, err := store.Update(actor, task.ID, TaskUpdate{Status: &done})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if completed.NextTaskID != nil {
+		t.Errorf("completing the last occurrence created task %d", *completed.NextTaskID)
+	}
+}
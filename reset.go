@@ -0,0 +1,153 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// ErrInvalidResetCode is returned for a wrong, expired or already used password reset code
+var ErrInvalidResetCode = errors.New("invalid or expired reset code")
+
+// resetStore replaces the security questions of old: a user who forgot their
+// password asks for a code, which the notifier delivers, and sets a new
+// password with it. Only the SHA-256 of each code is stored, like session tokens.
+// When security questions are turned on, the code alone isn't enough: the
+// user also answers the questions they picked from the catalog.
+type resetStore struct {
+	router    *dbRouter
+	notifier  Notifier
+	ttl       time.Duration // how long a code works
+	questions int           // how many security questions each user answers; 0 turns them off
+}
+
+func newResetStore(router *dbRouter, notifier Notifier, cfg ResetConfig) *resetStore {
+	return &resetStore{router: router, notifier: notifier, ttl: cfg.CodeTTL, questions: cfg.SecurityQuestions}
+}
+
+// Request sends a single-use password reset code to the user. Unknown
+// usernames and failed deliveries are only logged, so the caller can't tell
+// which accounts exist.
+func (s *resetStore) Request(username string) error {
+	if s.notifier == nil {
+		return ErrNoNotifier
+	}
+	db := s.router.Writer(anonymousSession)
+	var userID int
+	var email sql.NullString
+	err := db.QueryRow(`SELECT user_id, email FROM "user" WHERE username = $1`, username).Scan(&userID, &email)
+	if err == sql.ErrNoRows {
+		log.Printf("Password reset requested for unknown user %q", username)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	code, err := generateAuthToken()
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	expiresAt := now.Add(s.ttl)
+
+	// Codes sent earlier keep working until they expire, so asking again
+	// can't take away a code the user is about to enter; spent ones are dropped
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	query := `DELETE FROM password_reset WHERE user_id = $1 AND (used_at IS NOT NULL OR expires_at <= $2)`
+	if _, err := tx.Exec(query, userID, now); err != nil {
+		return err
+	}
+	query = `INSERT INTO password_reset (user_id, token_hash, created_at, expires_at) VALUES ($1, $2, $3, $4)`
+	if _, err := tx.Exec(query, userID, hashToken(code), now, expiresAt); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Someone asked to reset the password of your tms account %q. Your reset code is\n\n"+
+		"    %s\n\nIt works once, until %s UTC. If you didn't ask for it, ignore this message; your password stays the same.\n",
+		username, code, formatTime(expiresAt))
+	if err := s.notifier.Notify(Recipient{Username: username, Email: email.String}, "Reset your tms password", body); err != nil {
+		log.Printf("Error sending the password reset code to %q: %v", username, err)
+	}
+	return nil
+}
+
+// Helper function to check that a user has the unused reset code, returning the user's ID
+func findResetCode(db queryer, username, code string, now time.Time) (int, error) {
+	var userID int
+	query := `
+	SELECT r.user_id FROM password_reset r JOIN "user" u ON u.user_id = r.user_id
+	WHERE u.username = $1 AND r.token_hash = $2 AND r.used_at IS NULL AND r.expires_at > $3`
+	err := db.QueryRow(query, username, hashToken(normalizeCode(code)), now).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, ErrInvalidResetCode
+	}
+	return userID, err
+}
+
+// Questions checks a reset code without spending it and returns the security
+// questions to answer with it, none when they are turned off. The questions
+// stay hidden from anyone without a code.
+func (s *resetStore) Questions(username, code string) ([]SecurityQuestion, error) {
+	db := s.router.Writer(anonymousSession)
+	userID, err := findResetCode(db, username, code, time.Now().UTC())
+	if err != nil || s.questions == 0 {
+		return nil, err
+	}
+	return chosenQuestions(db, userID)
+}
+
+// Reset sets a new password with a reset code and the answers to the user's
+// security questions, spending the code and ending all of the user's sessions
+func (s *resetStore) Reset(username, code string, answers []SecurityAnswer, newPassword string) error {
+	if err := ValidPassword(newPassword); err != nil {
+		return &ValidationError{Field: "password", Err: err}
+	}
+	hashedPassword, err := hashPassword(newPassword)
+	if err != nil {
+		return fmt.Errorf("hashing password: %w", err)
+	}
+
+	tx, err := s.router.Writer(anonymousSession).Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	userID, err := findResetCode(tx, username, code, now)
+	if err != nil {
+		return err
+	}
+	// A wrong answer leaves the code for another try, within the lockout limits
+	if s.questions > 0 {
+		ok, err := checkAnswers(tx, userID, answers, s.questions)
+		if err != nil {
+			return err
+		} else if !ok {
+			return ErrWrongAnswers
+		}
+	}
+
+	if _, err := tx.Exec(`UPDATE "user" SET password = $1 WHERE user_id = $2`, hashedPassword, userID); err != nil {
+		return err
+	}
+	// The code is spent, along with any other the user asked for
+	if _, err := tx.Exec(`UPDATE password_reset SET used_at = $1 WHERE user_id = $2 AND used_at IS NULL`, now, userID); err != nil {
+		return err
+	}
+	// Whoever knew the old password is logged out everywhere
+	query := `UPDATE sessions SET revoked_at = $1 WHERE user_id = $2 AND revoked_at IS NULL`
+	if _, err := tx.Exec(query, now, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
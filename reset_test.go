@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// testNotifier keeps the messages it is asked to send
+type testNotifier struct {
+	messages []string
+}
+
+func (n *testNotifier) Notify(to Recipient, subject, body string) error {
+	n.messages = append(n.messages, to.Username+": "+body)
+	return nil
+}
+
+// The reset code on its own indented line of a message
+var resetCodePattern = regexp.MustCompile(`\n {4}(\S+)\n`)
+
+// Helper function to request a reset code for the user and read it from the message
+func requestResetCode(t *testing.T, resets *resetStore, notifier *testNotifier, username string) string {
+	t.Helper()
+	if err := resets.Request(username); err != nil {
+		t.Fatal(err)
+	}
+	if len(notifier.messages) == 0 {
+		t.Fatal("no reset code was sent")
+	}
+	match := resetCodePattern.FindStringSubmatch(notifier.messages[len(notifier.messages)-1])
+	if match == nil {
+		t.Fatalf("no reset code in %q", notifier.messages[len(notifier.messages)-1])
+	}
+	return match[1]
+}
+
+func TestPasswordReset(t *testing.T) {
+	router := newTestRouter(t)
+	notifier := new(testNotifier)
+	resets := newResetStore(router, notifier, ResetConfig{CodeTTL: time.Hour})
+	sessions := newSessionStore(router, time.Hour)
+	userID, _ := newTestUser(t, router, "alice")
+	authToken, err := sessions.Create(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Unknown usernames look the same to the caller but send nothing
+	if err := resets.Request("nobody"); err != nil || len(notifier.messages) != 0 {
+		t.Errorf("requesting a code for an unknown user = %v with %d messages; want nil and none", err, len(notifier.messages))
+	}
+	code := requestResetCode(t, resets, notifier, "alice")
+
+	if _, err := resets.Questions("alice", "wrong"); !errors.Is(err, ErrInvalidResetCode) {
+		t.Errorf("checking a wrong code = %v; want %v", err, ErrInvalidResetCode)
+	}
+	if _, err := resets.Questions("alice", code); err != nil {
+		t.Errorf("checking the code: %v", err)
+	}
+	var validationErr *ValidationError
+	if err := resets.Reset("alice", code, nil, "short"); !errors.As(err, &validationErr) || validationErr.Field != "password" {
+		t.Errorf("resetting to a weak password = %v; want a validation error for password", err)
+	}
+	if err := resets.Reset("alice", code, nil, "N3wPassw0rd!"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := authenticate(router, "alice", "Passw0rd!"); err != ErrInvalidCredentials {
+		t.Errorf("logging in with the old password = %v; want %v", err, ErrInvalidCredentials)
+	}
+	if got, err := authenticate(router, "alice", "N3wPassw0rd!"); err != nil || got != userID {
+		t.Errorf("logging in with the new password = %d, %v; want %d", got, err, userID)
+	}
+	if _, ok := isValidToken(sessions, authToken.Token); ok {
+		t.Error("a session outlived the password reset")
+	}
+	if err := resets.Reset("alice", code, nil, "An0therPass!"); !errors.Is(err, ErrInvalidResetCode) {
+		t.Errorf("spending a code twice = %v; want %v", err, ErrInvalidResetCode)
+	}
+}
+
+func TestPasswordResetExpiry(t *testing.T) {
+	router := newTestRouter(t)
+	notifier := new(testNotifier)
+	newTestUser(t, router, "alice")
+
+	expired := newResetStore(router, notifier, ResetConfig{CodeTTL: -time.Minute})
+	code := requestResetCode(t, expired, notifier, "alice")
+	if err := expired.Reset("alice", code, nil, "N3wPassw0rd!"); !errors.Is(err, ErrInvalidResetCode) {
+		t.Errorf("using an expired code = %v; want %v", err, ErrInvalidResetCode)
+	}
+
+	if err := newResetStore(router, nil, ResetConfig{CodeTTL: time.Hour}).Request("alice"); !errors.Is(err, ErrNoNotifier) {
+		t.Errorf("requesting a code without a notifier = %v; want %v", err, ErrNoNotifier)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+)
+
+// anonymousSession is the routing key for work done before anyone has logged in
+// (sign up, log in, password recovery)
+const anonymousSession = 0
+
+// dbRouter sends reads to the replica and writes to the primary. A user who
+// has written within maxLag keeps reading from the primary, so they always see
+// their own changes even if the replica has not caught up yet. Reads also fall
+// back to the primary while the replica is unreachable or lagging.
+type dbRouter struct {
+	primary *sql.DB
+	replica *sql.DB
+	backend string
+	maxLag  time.Duration // how far behind the replica may be before it is skipped
+
+	mu             sync.Mutex
+	lastWrite      map[int]time.Time // keyed by user ID (or anonymousSession)
+	replicaOK      bool
+	replicaChecked time.Time
+	checking       bool // a reader is checking the replica
+}
+
+const (
+	// How often the replica's health and lag are re-checked
+	replicaCheckInterval = 5 * time.Second
+	// How long a check may take before the replica counts as unavailable
+	replicaCheckTimeout = 2 * time.Second
+)
+
+func newDBRouter(primary, replica *sql.DB, backend string, maxLag time.Duration) *dbRouter {
+	return &dbRouter{
+		primary:   primary,
+		replica:   replica,
+		backend:   backend,
+		maxLag:    maxLag,
+		lastWrite: make(map[int]time.Time),
+	}
+}
+
+// Writer returns the primary and remembers that the session has written
+func (r *dbRouter) Writer(session int) *sql.DB {
+	if r.replica == nil || r.replica == r.primary {
+		return r.primary // nothing to route around
+	}
+	r.mu.Lock()
+	r.lastWrite[session] = time.Now()
+	r.mu.Unlock()
+	return r.primary
+}
+
+// Primary returns the primary for reads that must see the latest data, such
+// as session lookups, without keeping the session off the replica like Writer
+func (r *dbRouter) Primary() *sql.DB {
+	return r.primary
+}
+
+// Reader returns the database the session should read from
+func (r *dbRouter) Reader(session int) *sql.DB {
+	if r.replica == nil || r.replica == r.primary {
+		return r.primary
+	}
+
+	r.mu.Lock()
+	// Read-your-own-writes: stay on the primary until the replica must have caught up
+	if wrote, ok := r.lastWrite[session]; ok {
+		if time.Since(wrote) < r.maxLag {
+			r.mu.Unlock()
+			return r.primary
+		}
+		delete(r.lastWrite, session)
+	}
+	check := !r.checking && time.Since(r.replicaChecked) >= replicaCheckInterval
+	if check {
+		r.checking = true
+	}
+	replicaOK := r.replicaOK
+	r.mu.Unlock()
+
+	// One reader checks the replica without holding the lock; the others go
+	// by the last result in the meantime
+	if check {
+		replicaOK = r.checkReplica()
+		r.mu.Lock()
+		r.replicaOK = replicaOK
+		r.replicaChecked = time.Now()
+		r.checking = false
+		r.forgetOldWrites()
+		r.mu.Unlock()
+	}
+	if !replicaOK {
+		return r.primary
+	}
+	return r.replica
+}
+
+// Helper function to drop the writes the replica must have caught up with,
+// for sessions that never read again. The caller holds r.mu.
+func (r *dbRouter) forgetOldWrites() {
+	for session, wrote := range r.lastWrite {
+		if time.Since(wrote) >= r.maxLag {
+			delete(r.lastWrite, session)
+		}
+	}
+}
+
+// Function to check that the replica is reachable and not lagging more than maxLag
+func (r *dbRouter) checkReplica() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), replicaCheckTimeout)
+	defer cancel()
+	if err := r.replica.PingContext(ctx); err != nil {
+		log.Println("Replica unavailable, reading from the primary:", err)
+		return false
+	}
+	if r.backend != backendPostgres {
+		return true
+	}
+
+	// A replica that has replayed everything it received is up to date, however old its last transaction
+	var lagSeconds float64
+	query := `SELECT CASE
+		WHEN NOT pg_is_in_recovery() THEN 0
+		WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+		ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+	END`
+	if err := r.replica.QueryRowContext(ctx, query).Scan(&lagSeconds); err != nil {
+		log.Println("Error checking replica lag, reading from the primary:", err)
+		return false
+	}
+	lag := time.Duration(lagSeconds * float64(time.Second))
+	if lag > r.maxLag {
+		log.Printf("Replica is %s behind, reading from the primary", lag.Round(time.Millisecond))
+		return false
+	}
+	return true
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+// Helper function to open an empty in-memory database, closed when the test ends
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestRouterReadsYourOwnWrites(t *testing.T) {
+	primary, replica := openTestDB(t), openTestDB(t)
+	router := newDBRouter(primary, replica, backendSQLite, time.Hour)
+
+	if router.Reader(1) != replica {
+		t.Error("a session that never wrote doesn't read from the replica")
+	}
+	if router.Writer(1) != primary {
+		t.Error("writes don't go to the primary")
+	}
+	if router.Reader(1) != primary {
+		t.Error("a session that just wrote doesn't read its writes from the primary")
+	}
+	if router.Reader(2) != replica {
+		t.Error("another session's write kept this one off the replica")
+	}
+	if router.Primary() != primary {
+		t.Error("Primary doesn't return the primary")
+	}
+}
+
+func TestRouterReturnsToReplica(t *testing.T) {
+	primary, replica := openTestDB(t), openTestDB(t)
+	router := newDBRouter(primary, replica, backendSQLite, 0)
+
+	router.Writer(1)
+	if router.Reader(1) != replica {
+		t.Error("a session stayed on the primary after the replica must have caught up")
+	}
+}
+
+func TestRouterSkipsUnavailableReplica(t *testing.T) {
+	primary, replica := openTestDB(t), openTestDB(t)
+	replica.Close()
+	router := newDBRouter(primary, replica, backendSQLite, time.Hour)
+
+	if router.Reader(1) != primary {
+		t.Error("reads went to a replica that can't be reached")
+	}
+}
+
+func TestRouterWithoutReplica(t *testing.T) {
+	primary := openTestDB(t)
+	for _, replica := range []*sql.DB{nil, primary} {
+		router := newDBRouter(primary, replica, backendSQLite, time.Hour)
+		if router.Reader(1) != primary || router.Writer(1) != primary {
+			t.Error("without a replica, reads and writes don't both go to the primary")
+		}
+	}
+}
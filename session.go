@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"log"
+	"time"
+)
+
+type AuthToken struct {
+	Token     string
+	ExpiresAt time.Time
+}
+
+// How long after the last renewal a session is extended again. Renewing on
+// every request would turn each read into a write.
+const sessionRenewInterval = time.Minute
+
+// sessionStore keeps login sessions in the "sessions" table so they survive a
+// restart. Only the SHA-256 of each token is stored; the token itself is
+// handed to the user once and never persisted. Sessions expire ttl after
+// their last use and can be revoked explicitly on logout.
+type sessionStore struct {
+	router *dbRouter
+	ttl    time.Duration
+}
+
+func newSessionStore(router *dbRouter, ttl time.Duration) *sessionStore {
+	return &sessionStore{router: router, ttl: ttl}
+}
+
+func generateAuthToken() (string, error) {
+	bytes := make([]byte, 16)
+	_, err := rand.Read(bytes)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
+// Helper function to hash a token for storage and lookup
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Create starts a new session for the user and returns its token
+func (s *sessionStore) Create(userID int) (AuthToken, error) {
+	token, err := generateAuthToken()
+	if err != nil {
+		return AuthToken{}, err
+	}
+	now := time.Now().UTC()
+	authToken := AuthToken{Token: token, ExpiresAt: now.Add(s.ttl)}
+
+	db := s.router.Writer(userID)
+
+	// Drop the user's dead sessions while we are here so the table doesn't grow forever
+	_, err = db.Exec(`DELETE FROM sessions WHERE user_id = $1 AND (expires_at < $2 OR revoked_at IS NOT NULL)`, userID, now)
+	if err != nil {
+		return AuthToken{}, err
+	}
+
+	query := `
+	INSERT INTO sessions (token_hash, user_id, created_at, last_seen_at, expires_at)
+	VALUES ($1, $2, $3, $3, $4)`
+	_, err = db.Exec(query, hashToken(token), userID, now, authToken.ExpiresAt)
+	if err != nil {
+		return AuthToken{}, err
+	}
+	return authToken, nil
+}
+
+// Validate returns the user a live session belongs to and slides its expiry forward.
+// Lookups always go to the primary so that a revoked session stops working at once,
+// without counting as a write that would keep anyone else off the replica.
+func (s *sessionStore) Validate(token string) (int, bool, error) {
+	db := s.router.Primary()
+
+	var userID int
+	var lastSeenAt, expiresAt time.Time
+	var revokedAt sql.NullTime
+	query := `SELECT user_id, last_seen_at, expires_at, revoked_at FROM sessions WHERE token_hash = $1`
+	err := db.QueryRow(query, hashToken(token)).Scan(&userID, &lastSeenAt, &expiresAt, &revokedAt)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+
+	now := time.Now().UTC()
+	if revokedAt.Valid || !now.Before(expiresAt) {
+		return 0, false, nil
+	}
+
+	// Sliding renewal
+	if now.Sub(lastSeenAt) >= sessionRenewInterval {
+		_, err = db.Exec(`UPDATE sessions SET last_seen_at = $1, expires_at = $2 WHERE token_hash = $3`, now, now.Add(s.ttl), hashToken(token))
+		if err != nil {
+			return 0, false, err
+		}
+	}
+	return userID, true, nil
+}
+
+// Actor returns the user of a session working in the session's workspace:
+// the one picked with UseWorkspace, or the user's first workspace if they
+// never picked one or have since left it. It reads the primary, so a switch
+// takes effect at once, but records no write for the user.
+func (s *sessionStore) Actor(token string, userID int) (Actor, error) {
+	db := s.router.Primary()
+
+	var workspaceID sql.NullInt64
+	err := db.QueryRow(`SELECT workspace_id FROM sessions WHERE token_hash = $1`, hashToken(token)).Scan(&workspaceID)
+	if err != nil && err != sql.ErrNoRows {
+		return Actor{}, err
+	}
+	if workspaceID.Valid {
+		actor := Actor{UserID: userID, WorkspaceID: int(workspaceID.Int64)}
+		if _, err := actorRole(db, actor); err == nil {
+			return actor, nil
+		} else if err != ErrWorkspaceNotFound {
+			return Actor{}, err
+		}
+	}
+	id, err := defaultWorkspace(db, userID)
+	return Actor{UserID: userID, WorkspaceID: id}, err
+}
+
+// UseWorkspace switches a session to another of the user's workspaces
+func (s *sessionStore) UseWorkspace(token string, actor Actor) error {
+	db := s.router.Writer(actor.UserID)
+	if _, err := actorRole(db, actor); err != nil {
+		return err
+	}
+	_, err := db.Exec(`UPDATE sessions SET workspace_id = $1 WHERE token_hash = $2`, actor.WorkspaceID, hashToken(token))
+	return err
+}
+
+// Revoke ends a session, e.g. on logout
+func (s *sessionStore) Revoke(token string) error {
+	query := `UPDATE sessions SET revoked_at = $1 WHERE token_hash = $2 AND revoked_at IS NULL`
+	_, err := s.router.Writer(anonymousSession).Exec(query, time.Now().UTC(), hashToken(token))
+	return err
+}
+
+// Function to check a token against the stored sessions
+func isValidToken(sessions *sessionStore, token string) (int, bool) {
+	userID, ok, err := sessions.Validate(token)
+	if err != nil {
+		log.Println("Error validating session:", err)
+		return 0, false
+	}
+	return userID, ok
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSessionLifecycle(t *testing.T) {
+	router := newTestRouter(t)
+	sessions := newSessionStore(router, time.Hour)
+	userID, _ := newTestUser(t, router, "alice")
+
+	authToken, err := sessions.Create(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if until := time.Until(authToken.ExpiresAt); until <= 59*time.Minute || until > time.Hour {
+		t.Errorf("session expires in %s; want the 1h TTL", until)
+	}
+	if got, ok := isValidToken(sessions, authToken.Token); !ok || got != userID {
+		t.Errorf("isValidToken = %d, %v; want %d, true", got, ok, userID)
+	}
+	if _, ok := isValidToken(sessions, "not-a-token"); ok {
+		t.Error("an unknown token is valid")
+	}
+
+	// Only the hash of the token is stored
+	var stored int
+	err = router.Primary().QueryRow(`SELECT COUNT(*) FROM sessions WHERE token_hash = $1`, authToken.Token).Scan(&stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored != 0 {
+		t.Error("the token is stored as is")
+	}
+
+	if err := sessions.Revoke(authToken.Token); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := isValidToken(sessions, authToken.Token); ok {
+		t.Error("a revoked session is still valid")
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	router := newTestRouter(t)
+	sessions := newSessionStore(router, time.Hour)
+	userID, _ := newTestUser(t, router, "alice")
+	authToken, err := sessions.Create(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := router.Primary()
+
+	// A session used a while ago is renewed for another full TTL
+	lastSeen := time.Now().UTC().Add(-30 * time.Minute)
+	_, err = db.Exec(`UPDATE sessions SET last_seen_at = $1, expires_at = $2 WHERE token_hash = $3`,
+		lastSeen, lastSeen.Add(time.Hour), hashToken(authToken.Token))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := isValidToken(sessions, authToken.Token); !ok {
+		t.Fatal("a session within its TTL is not valid")
+	}
+	var expiresAt time.Time
+	err = db.QueryRow(`SELECT expires_at FROM sessions WHERE token_hash = $1`, hashToken(authToken.Token)).Scan(&expiresAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Until(expiresAt) <= 59*time.Minute {
+		t.Errorf("a used session expires in %s; want it renewed for the 1h TTL", time.Until(expiresAt))
+	}
+
+	// A session left idle for longer than the TTL is over
+	lastSeen = time.Now().UTC().Add(-2 * time.Hour)
+	_, err = db.Exec(`UPDATE sessions SET last_seen_at = $1, expires_at = $2 WHERE token_hash = $3`,
+		lastSeen, lastSeen.Add(time.Hour), hashToken(authToken.Token))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := isValidToken(sessions, authToken.Token); ok {
+		t.Error("an expired session is still valid")
+	}
+}
@@ -0,0 +1,694 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// sqlTaskStore keeps tasks in the "task" table. The queries stick to the SQL
+// that Postgres and SQLite share ($n placeholders, RETURNING), so the same
+// store serves the postgres, sqlite and memory backends. Reads and writes go
+// through the router so that reads can be served by the replica.
+type sqlTaskStore struct {
+	router    *dbRouter
+	retention time.Duration // how long deleted tasks stay in the trash
+}
+
+func newSQLTaskStore(router *dbRouter, retention time.Duration) *sqlTaskStore {
+	return &sqlTaskStore{router: router, retention: retention}
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx
+type queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+const taskColumns = `task_id, workspace_id, user_id, assignee_id, title, description, status, priority, due_at, due_tz, parent_id, project_id, auto_complete, recurrence, occurrence, next_task_id, created_at, updated_at, deleted_at`
+
+// Helper function to scan a task row selected with taskColumns
+func scanTask(row interface{ Scan(...interface{}) error }) (Task, error) {
+	var task Task
+	var dueAt sql.NullTime
+	var dueTZ sql.NullString
+	var assigneeID, parentID, projectID, nextTaskID sql.NullInt64
+	var recurrence sql.NullString
+	var deletedAt sql.NullTime
+	err := row.Scan(&task.ID, &task.WorkspaceID, &task.UserID, &assigneeID, &task.Title, &task.Description, &task.Status,
+		&task.Priority, &dueAt, &dueTZ, &parentID, &projectID, &task.AutoComplete, &recurrence, &task.Occurrence, &nextTaskID,
+		&task.CreatedAt, &task.UpdatedAt, &deletedAt)
+	if dueAt.Valid {
+		// Show the due date in the zone it was given in
+		due := dueAt.Time.In(loadZone(dueTZ.String))
+		task.DueAt = &due
+	}
+	if assigneeID.Valid {
+		id := int(assigneeID.Int64)
+		task.AssigneeID = &id
+	}
+	if parentID.Valid {
+		id := int(parentID.Int64)
+		task.ParentID = &id
+	}
+	if projectID.Valid {
+		id := int(projectID.Int64)
+		task.ProjectID = &id
+	}
+	if nextTaskID.Valid {
+		id := int(nextTaskID.Int64)
+		task.NextTaskID = &id
+	}
+	if deletedAt.Valid {
+		task.DeletedAt = &deletedAt.Time
+	}
+	task.Recurrence = recurrence.String
+	return task, err
+}
+
+// Helper function to get the column values for a due date
+func dueColumns(due *time.Time) (interface{}, interface{}) {
+	if due == nil {
+		return nil, nil
+	}
+	return due.UTC(), zoneName(*due)
+}
+
+// Helper function to number the next placeholder after the arguments so far
+func placeholder(args []interface{}) string {
+	return "$" + strconv.Itoa(len(args))
+}
+
+// Helper function to append IDs to the arguments and list their placeholders,
+// for use in an IN (...) condition
+func idList(args *[]interface{}, ids []int) string {
+	var list []string
+	for _, id := range ids {
+		*args = append(*args, id)
+		list = append(list, placeholder(*args))
+	}
+	return stringJoin(list, ", ")
+}
+
+// Helper function to store an empty string as NULL
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// Helper function to compare two optional IDs
+func sameID(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// Function to read tasks and fill in what doesn't live in the task row itself.
+// The rows are read in full before anything else is queried, so this also
+// works on a single connection.
+func (s *sqlTaskStore) queryTasks(db queryer, workspaceID int, query string, args ...interface{}) ([]Task, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	var tasks []Task
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := s.fillTasks(db, workspaceID, tasks); err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
+// Function to add the details kept outside the task row: whether the status
+// counts as done, the task's tags and project name, the progress of its
+// subtasks, the tasks blocking it and the people involved
+func (s *sqlTaskStore) fillTasks(db queryer, workspaceID int, tasks []Task) error {
+	if len(tasks) == 0 {
+		return nil
+	}
+	taskIDs := make([]int, len(tasks))
+	for i := range tasks {
+		taskIDs[i] = tasks[i].ID
+	}
+	workflow, err := loadWorkflow(db, workspaceID)
+	if err != nil {
+		return err
+	}
+	tags, err := loadTaskTags(db, workspaceID, taskIDs)
+	if err != nil {
+		return err
+	}
+	projects, err := loadProjectNames(db, workspaceID, taskIDs)
+	if err != nil {
+		return err
+	}
+	progress, err := loadProgress(db, workspaceID, taskIDs, workflow)
+	if err != nil {
+		return err
+	}
+	blockers, blocked, err := loadDependencies(db, workspaceID, taskIDs, workflow)
+	if err != nil {
+		return err
+	}
+	users, err := loadTaskUsers(db, workspaceID, taskIDs)
+	if err != nil {
+		return err
+	}
+	watchers, err := loadWatchers(db, workspaceID, taskIDs)
+	if err != nil {
+		return err
+	}
+	for i := range tasks {
+		tasks[i].Creator = users[tasks[i].UserID]
+		if tasks[i].AssigneeID != nil {
+			tasks[i].Assignee = users[*tasks[i].AssigneeID]
+		}
+		tasks[i].Watchers = watchers[tasks[i].ID]
+		if tasks[i].Watchers == nil {
+			tasks[i].Watchers = []string{} // encode as [] rather than null
+		}
+		tasks[i].BlockedBy = blockers[tasks[i].ID]
+		if tasks[i].BlockedBy == nil {
+			tasks[i].BlockedBy = []int{} // encode as [] rather than null
+		}
+		tasks[i].Blocked = blocked[tasks[i].ID]
+		if p, ok := progress[tasks[i].ID]; ok {
+			tasks[i].Progress = &p
+		}
+		if tasks[i].ProjectID != nil {
+			tasks[i].Project = projects[*tasks[i].ProjectID]
+		}
+		tasks[i].Done = workflow.IsDone(tasks[i].Status)
+		tasks[i].Tags = tags[tasks[i].ID]
+		if tasks[i].Tags == nil {
+			tasks[i].Tags = []string{} // encode as [] rather than null
+		}
+		if tasks[i].DeletedAt != nil {
+			purgeAt := tasks[i].DeletedAt.Add(s.retention)
+			tasks[i].PurgeAt = &purgeAt
+		}
+	}
+	return nil
+}
+
+func (s *sqlTaskStore) Create(actor Actor, task Task) (Task, error) {
+	tags, err := normalizeTags(task.Tags)
+	if err != nil {
+		return Task{}, err
+	}
+
+	tx, err := s.router.Writer(actor.UserID).Begin()
+	if err != nil {
+		return Task{}, err
+	}
+	defer tx.Rollback()
+
+	if _, err := requireRole(tx, actor, RoleMember); err != nil {
+		return Task{}, err
+	}
+
+	// New tasks start in the workflow's first status unless told otherwise
+	workflow, err := loadWorkflow(tx, actor.WorkspaceID)
+	if err != nil {
+		return Task{}, err
+	}
+	if task.Status == "" {
+		task.Status = workflow.Initial()
+	}
+	if err := workflow.CheckStatus(task.Status); err != nil {
+		return Task{}, err
+	}
+
+	if task.Priority == 0 {
+		task.Priority = defaultPriority
+	}
+	projectID, err := taskProject(tx, actor.WorkspaceID, task.Project)
+	if err != nil {
+		return Task{}, err
+	}
+	if task.ParentID != nil {
+		if err := checkParent(tx, actor.WorkspaceID, 0, *task.ParentID); err != nil {
+			return Task{}, err
+		}
+		// Subtasks go in their parent's project
+		parentProjectID, err := parentProject(tx, actor.WorkspaceID, *task.ParentID)
+		if err != nil {
+			return Task{}, err
+		}
+		if projectID != nil && (parentProjectID == nil || *parentProjectID != *projectID) {
+			return Task{}, &ValidationError{Field: "project", Err: ErrSubtaskProject}
+		}
+		projectID = parentProjectID
+	}
+	// Adding a subtask can complete its parent and the parent's parents
+	var family []int
+	if task.ParentID != nil {
+		if family, err = taskFamily(tx, actor.WorkspaceID, *task.ParentID); err != nil {
+			return Task{}, err
+		}
+	}
+	before, err := s.snapshot(tx, actor.WorkspaceID, family)
+	if err != nil {
+		return Task{}, err
+	}
+	recurrence, err := normalizeRecurrence(task.Recurrence, task.DueAt)
+	if err != nil {
+		return Task{}, err
+	}
+	var assigneeIDs []int
+	if task.Assignee != "" {
+		if assigneeIDs, err = memberIDs(tx, actor, "assignee", []string{task.Assignee}); err != nil {
+			return Task{}, err
+		}
+	}
+	watcherIDs, err := memberIDs(tx, actor, "watchers", task.Watchers)
+	if err != nil {
+		return Task{}, err
+	}
+	dueAt, dueTZ := dueColumns(task.DueAt)
+	query := `
+	INSERT INTO "task" (workspace_id, user_id, title, description, status, priority, due_at, due_tz, parent_id, project_id, auto_complete, recurrence)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+	RETURNING task_id`
+	var taskID int
+	err = tx.QueryRow(query, actor.WorkspaceID, actor.UserID, task.Title, task.Description, task.Status, task.Priority, dueAt, dueTZ,
+		task.ParentID, projectID, task.AutoComplete, nullString(recurrence)).Scan(&taskID)
+	if err != nil {
+		return Task{}, err
+	}
+	if err := addTaskTags(tx, actor.WorkspaceID, taskID, tags); err != nil {
+		return Task{}, err
+	}
+	if len(assigneeIDs) > 0 {
+		if err := assignTask(tx, actor.WorkspaceID, taskID, &assigneeIDs[0], actor.UserID); err != nil {
+			return Task{}, err
+		}
+	}
+	if err := addWatchers(tx, taskID, watcherIDs); err != nil {
+		return Task{}, err
+	}
+	if err := addBlockers(tx, actor.WorkspaceID, taskID, task.BlockedBy); err != nil {
+		return Task{}, err
+	}
+	if len(task.BlockedBy) > 0 && workflow.IsDone(task.Status) {
+		if err := checkBlockers(tx, taskID, workflow); err != nil {
+			return Task{}, err
+		}
+	}
+	if err := s.completeParents(tx, actor.WorkspaceID, task.ParentID); err != nil {
+		return Task{}, err
+	}
+
+	if err := s.recordChanges(tx, actor, before, taskID); err != nil {
+		return Task{}, err
+	}
+	created, err := s.get(tx, actor.WorkspaceID, taskID)
+	if err != nil {
+		return Task{}, err
+	}
+	return created, tx.Commit()
+}
+
+func (s *sqlTaskStore) Get(actor Actor, taskID int) (Task, error) {
+	db := s.router.Reader(actor.UserID)
+	if _, err := requireRole(db, actor, RoleViewer); err != nil {
+		return Task{}, err
+	}
+	return s.get(db, actor.WorkspaceID, taskID)
+}
+
+// Function to read one task with any query runner
+func (s *sqlTaskStore) get(db queryer, workspaceID, taskID int) (Task, error) {
+	query := `SELECT ` + taskColumns + ` FROM "task" WHERE task_id = $1 AND workspace_id = $2 AND deleted_at IS NULL`
+	tasks, err := s.queryTasks(db, workspaceID, query, taskID, workspaceID)
+	if err != nil {
+		return Task{}, err
+	}
+	if len(tasks) == 0 {
+		return Task{}, ErrTaskNotFound
+	}
+	return tasks[0], nil
+}
+
+func (s *sqlTaskStore) List(actor Actor, filter TaskFilter) ([]Task, error) {
+	tags, err := normalizeTags(filter.Tags)
+	if err != nil {
+		return nil, err
+	}
+	filter.Tags = tags
+
+	db := s.router.Reader(actor.UserID)
+	if _, err := requireRole(db, actor, RoleViewer); err != nil {
+		return nil, err
+	}
+	var projectID int
+	if filter.Project != "" {
+		if projectID, _, err = findProject(db, actor.WorkspaceID, filter.Project); err != nil {
+			return nil, err
+		}
+	}
+
+	args := []interface{}{actor.WorkspaceID}
+	query := `SELECT ` + taskColumns + ` FROM "task" WHERE workspace_id = $1 AND deleted_at IS NULL`
+	query += filter.tagCondition(&args)
+	query += projectCondition(&args, projectID)
+	query += filter.peopleCondition(&args)
+	query += ` ORDER BY task_id`
+	return s.queryTasks(db, actor.WorkspaceID, query, args...)
+}
+
+func (s *sqlTaskStore) Update(actor Actor, taskID int, update TaskUpdate) (Task, error) {
+	addTags, err := normalizeTags(update.AddTags)
+	if err != nil {
+		return Task{}, err
+	}
+	removeTags, err := normalizeTags(update.RemoveTags)
+	if err != nil {
+		return Task{}, err
+	}
+
+	tx, err := s.router.Writer(actor.UserID).Begin()
+	if err != nil {
+		return Task{}, err
+	}
+	defer tx.Rollback()
+
+	// Besides members, the assignee may update the task even as a viewer
+	role, err := requireRole(tx, actor, RoleViewer)
+	if err != nil {
+		return Task{}, err
+	}
+	current, err := s.get(tx, actor.WorkspaceID, taskID)
+	if err != nil {
+		return Task{}, err
+	}
+	// Moving the task can complete its old or new parents
+	scope := []int{taskID}
+	if update.ParentID != nil && !update.ClearParent {
+		scope = append(scope, *update.ParentID)
+	}
+	family, err := taskFamily(tx, actor.WorkspaceID, scope...)
+	if err != nil {
+		return Task{}, err
+	}
+	before, err := s.snapshot(tx, actor.WorkspaceID, family)
+	if err != nil {
+		return Task{}, err
+	}
+	addWatcherIDs, err := memberIDs(tx, actor, "watchers", update.AddWatchers)
+	if err != nil {
+		return Task{}, err
+	}
+	removeWatcherIDs, err := memberIDs(tx, actor, "watchers", update.RemoveWatchers)
+	if err != nil {
+		return Task{}, err
+	}
+	if err := checkCanUpdate(role, actor, current, update, addWatcherIDs, removeWatcherIDs); err != nil {
+		return Task{}, err
+	}
+	var assigneeID *int
+	if update.Assignee != nil && *update.Assignee != "" {
+		ids, err := memberIDs(tx, actor, "assignee", []string{*update.Assignee})
+		if err != nil {
+			return Task{}, err
+		}
+		assigneeID = &ids[0]
+	}
+
+	// A status change must be allowed by the workflow from the task's current status
+	workflow, err := loadWorkflow(tx, actor.WorkspaceID)
+	if err != nil {
+		return Task{}, err
+	}
+	if update.Status != nil {
+		if err := workflow.CheckTransition(current.Status, *update.Status); err != nil {
+			return Task{}, err
+		}
+	}
+
+	// Change the blockers first, so completing the task is checked against the new ones
+	if err := removeBlockers(tx, taskID, update.RemoveBlockers); err != nil {
+		return Task{}, err
+	}
+	if err := addBlockers(tx, actor.WorkspaceID, taskID, update.AddBlockers); err != nil {
+		return Task{}, err
+	}
+	if update.Status != nil && workflow.IsDone(*update.Status) && !current.Done {
+		if err := checkBlockers(tx, taskID, workflow); err != nil {
+			return Task{}, err
+		}
+	}
+	if update.ParentID != nil && !update.ClearParent {
+		if err := checkParent(tx, actor.WorkspaceID, taskID, *update.ParentID); err != nil {
+			return Task{}, err
+		}
+	}
+
+	// Subtasks share their parent's project, so only top-level tasks change
+	// project directly; a task moved under a parent joins the parent's project
+	projectID := current.ProjectID
+	if update.ParentID != nil && !update.ClearParent {
+		if update.Project != nil {
+			return Task{}, &ValidationError{Field: "project", Err: ErrSubtaskProject}
+		}
+		if projectID, err = parentProject(tx, actor.WorkspaceID, *update.ParentID); err != nil {
+			return Task{}, err
+		}
+	} else if update.Project != nil {
+		if current.ParentID != nil && !update.ClearParent {
+			return Task{}, &ValidationError{Field: "project", Err: ErrSubtaskProject}
+		}
+		if projectID, err = taskProject(tx, actor.WorkspaceID, *update.Project); err != nil {
+			return Task{}, err
+		}
+	}
+
+	// A recurring task needs a due date to repeat from
+	recurrence := current.Recurrence
+	if update.Recurrence != nil {
+		due := current.DueAt
+		if update.ClearDue {
+			due = nil
+		} else if update.DueAt != nil {
+			due = update.DueAt
+		}
+		if recurrence, err = normalizeRecurrence(*update.Recurrence, due); err != nil {
+			return Task{}, err
+		}
+	} else if recurrence != "" && update.ClearDue {
+		return Task{}, &ValidationError{Field: "due", Err: ErrRecurrenceNeedsDue}
+	}
+	var skipTo *time.Time
+	if update.SkipOccurrence {
+		if update.Recurrence != nil || update.DueAt != nil || update.ClearDue {
+			return Task{}, &ValidationError{Field: "recurrence", Err: ErrSkipWithChanges}
+		}
+		next, err := skipOccurrence(current)
+		if err != nil {
+			return Task{}, err
+		}
+		skipTo = &next
+	}
+
+	var queryParts []string
+	var args []interface{}
+	set := func(column string, value interface{}) {
+		args = append(args, value)
+		queryParts = append(queryParts, column+" = "+placeholder(args))
+	}
+
+	if update.Title != nil {
+		set("title", *update.Title)
+	}
+	if update.Description != nil {
+		set("description", *update.Description)
+	}
+	if update.Status != nil {
+		set("status", *update.Status)
+	}
+	if update.Priority != nil {
+		set("priority", *update.Priority)
+	}
+	if update.ClearDue {
+		set("due_at", nil)
+		set("due_tz", nil)
+	} else if update.DueAt != nil {
+		dueAt, dueTZ := dueColumns(update.DueAt)
+		set("due_at", dueAt)
+		set("due_tz", dueTZ)
+	}
+	if update.ClearParent {
+		set("parent_id", nil)
+	} else if update.ParentID != nil {
+		set("parent_id", *update.ParentID)
+	}
+	if update.AutoComplete != nil {
+		set("auto_complete", *update.AutoComplete)
+	}
+	if update.Recurrence != nil {
+		set("recurrence", nullString(recurrence))
+		if recurrence != current.Recurrence {
+			set("occurrence", 1) // a new rule starts a new series
+		}
+	}
+	if skipTo != nil {
+		dueAt, dueTZ := dueColumns(skipTo)
+		set("due_at", dueAt)
+		set("due_tz", dueTZ)
+		set("occurrence", current.Occurrence+1)
+	}
+
+	// Add 'updated_at' field to query
+	queryParts = append(queryParts, "updated_at = CURRENT_TIMESTAMP")
+
+	args = append(args, taskID)
+	where := ` WHERE task_id = ` + placeholder(args)
+	args = append(args, actor.WorkspaceID)
+	where += ` AND workspace_id = ` + placeholder(args)
+	if update.Status != nil {
+		// Only apply the change if nobody moved the task since we checked the transition
+		args = append(args, current.Status)
+		where += ` AND status = ` + placeholder(args)
+	}
+	result, err := tx.Exec(`UPDATE "task" SET `+stringJoin(queryParts, ", ")+where, args...)
+	if err != nil {
+		return Task{}, err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return Task{}, err
+	} else if n == 0 {
+		return Task{}, ErrTaskConflict
+	}
+
+	if err := addTaskTags(tx, actor.WorkspaceID, taskID, addTags); err != nil {
+		return Task{}, err
+	}
+	if err := removeTaskTags(tx, actor.WorkspaceID, taskID, removeTags); err != nil {
+		return Task{}, err
+	}
+	if !sameID(projectID, current.ProjectID) {
+		if err := moveToProject(tx, actor.WorkspaceID, taskID, projectID); err != nil {
+			return Task{}, err
+		}
+	}
+	if update.Assignee != nil && !sameID(assigneeID, current.AssigneeID) {
+		if err := assignTask(tx, actor.WorkspaceID, taskID, assigneeID, actor.UserID); err != nil {
+			return Task{}, err
+		}
+	}
+	if err := addWatchers(tx, taskID, addWatcherIDs); err != nil {
+		return Task{}, err
+	}
+	if err := removeWatchers(tx, taskID, removeWatcherIDs); err != nil {
+		return Task{}, err
+	}
+
+	// Completing a recurring task schedules its next occurrence
+	updated, err := s.get(tx, actor.WorkspaceID, taskID)
+	if err != nil {
+		return Task{}, err
+	}
+	if updated.Done && !current.Done {
+		if err := s.recur(tx, actor.WorkspaceID, updated); err != nil {
+			return Task{}, err
+		}
+	}
+
+	// Completing or moving a subtask may leave its old or new parent with only
+	// done subtasks; switching auto-completion on may complete the task itself
+	start := updated.ParentID
+	if update.AutoComplete != nil && *update.AutoComplete {
+		start = &taskID
+	}
+	if err := s.completeParents(tx, actor.WorkspaceID, start); err != nil {
+		return Task{}, err
+	}
+	if current.ParentID != nil && (updated.ParentID == nil || *updated.ParentID != *current.ParentID) {
+		if err := s.completeParents(tx, actor.WorkspaceID, current.ParentID); err != nil {
+			return Task{}, err
+		}
+	}
+
+	if err := s.recordChanges(tx, actor, before); err != nil {
+		return Task{}, err
+	}
+	updated, err = s.get(tx, actor.WorkspaceID, taskID)
+	if err != nil {
+		return Task{}, err
+	}
+	return updated, tx.Commit()
+}
+
+func (s *sqlTaskStore) Delete(actor Actor, taskID int, subtasks SubtaskPolicy) error {
+	tx, err := s.router.Writer(actor.UserID).Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Members may only delete the tasks they created; admins and owners any task
+	role, err := requireRole(tx, actor, RoleMember)
+	if err != nil {
+		return err
+	}
+	task, err := s.get(tx, actor.WorkspaceID, taskID)
+	if err != nil {
+		return err
+	}
+	if task.UserID != actor.UserID && !role.AtLeast(RoleAdmin) {
+		return fmt.Errorf("%w: members can only delete tasks they created", ErrForbidden)
+	}
+	family, err := taskFamily(tx, actor.WorkspaceID, taskID)
+	if err != nil {
+		return err
+	}
+	before, err := s.snapshot(tx, actor.WorkspaceID, family)
+	if err != nil {
+		return err
+	}
+	if task.Progress != nil {
+		switch subtasks {
+		case SubtasksRefuse:
+			return ErrTaskHasSubtasks
+		case SubtasksPromote:
+			query := `UPDATE "task" SET parent_id = $1, updated_at = CURRENT_TIMESTAMP WHERE parent_id = $2 AND workspace_id = $3`
+			if _, err := tx.Exec(query, task.ParentID, taskID, actor.WorkspaceID); err != nil {
+				return err
+			}
+		case SubtasksCascade:
+			// The whole subtree goes to the trash with the task
+		}
+	}
+
+	trashed, err := trashTask(tx, actor.WorkspaceID, taskID, time.Now().UTC())
+	if err != nil {
+		return err
+	} else if trashed == 0 {
+		return ErrTaskNotFound
+	}
+
+	// The deleted task may have been the parent's last open subtask
+	if err := s.completeParents(tx, actor.WorkspaceID, task.ParentID); err != nil {
+		return err
+	}
+	if err := s.recordChanges(tx, actor, before); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
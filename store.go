@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// Task is a single row of the "task" table
+type Task struct {
+	ID           int        `json:"id"`
+	WorkspaceID  int        `json:"workspace_id"`
+	UserID       int        `json:"user_id"` // the user who created the task
+	Creator      string     `json:"creator"` // username of the user who created the task
+	AssigneeID   *int       `json:"assignee_id,omitempty"`
+	Assignee     string     `json:"assignee,omitempty"` // username of the member the task is assigned to
+	Watchers     []string   `json:"watchers"`           // usernames of the members following the task
+	Title        string     `json:"title"`
+	Description  string     `json:"description"`
+	Status       string     `json:"status"` // one of the workspace's workflow statuses, e.g. todo or done
+	Done         bool       `json:"done"`   // whether Status counts as complete in the workflow
+	Priority     Priority   `json:"priority"`
+	Tags         []string   `json:"tags"`
+	ProjectID    *int       `json:"project_id,omitempty"`
+	Project      string     `json:"project,omitempty"`      // name of the project the task belongs to
+	ParentID     *int       `json:"parent_id,omitempty"`    // set for subtasks
+	AutoComplete bool       `json:"auto_complete"`          // complete the task once all its subtasks are done
+	Progress     *Progress  `json:"progress,omitempty"`     // set for tasks with subtasks
+	BlockedBy    []int      `json:"blocked_by"`             // tasks that must be done before this one
+	Blocked      bool       `json:"blocked"`                // whether any of BlockedBy is still open
+	Recurrence   string     `json:"recurrence,omitempty"`   // RRULE the task repeats by, e.g. FREQ=WEEKLY;BYDAY=MO
+	Occurrence   int        `json:"occurrence,omitempty"`   // which occurrence of its series the task is, from 1
+	NextTaskID   *int       `json:"next_task_id,omitempty"` // the instance created when this one was completed
+	DueAt        *time.Time `json:"due_at,omitempty"`       // in the zone the due date was given in
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+	DeletedAt    *time.Time `json:"deleted_at,omitempty"` // set while the task is in the trash
+	PurgeAt      *time.Time `json:"purge_at,omitempty"`   // when a task in the trash is deleted for good
+}
+
+// TaskUpdate lists the fields to change on a task; nil fields are left as they are
+type TaskUpdate struct {
+	Title          *string
+	Description    *string
+	Status         *string
+	Priority       *Priority
+	DueAt          *time.Time
+	ClearDue       bool // remove the due date; takes precedence over DueAt
+	AddTags        []string
+	RemoveTags     []string
+	ParentID       *int // move the task under another task
+	ClearParent    bool // make the task a top-level task; takes precedence over ParentID
+	AutoComplete   *bool
+	AddBlockers    []int // tasks that must be done before this one
+	RemoveBlockers []int
+	Recurrence     *string // new recurrence rule; "" stops the series
+	SkipOccurrence bool    // move a recurring task on to its next occurrence without completing it
+	Project        *string // move the task and its subtasks to this project; "" takes them out of their project
+	Assignee       *string // username of the member to assign the task to; "" unassigns it
+	AddWatchers    []string
+	RemoveWatchers []string
+}
+
+var (
+	// ErrTaskNotFound is returned when a task does not exist or belongs to another workspace
+	ErrTaskNotFound = errors.New("task not found")
+	// ErrTaskConflict is returned when a task changed between reading and updating it
+	ErrTaskConflict = errors.New("task was changed at the same time; try again")
+)
+
+// TaskStore is the storage behind the task menu. Every method is scoped to the
+// actor's workspace, so callers never see tasks from workspaces they aren't in,
+// and checks that the actor's role there allows the call.
+type TaskStore interface {
+	Create(actor Actor, task Task) (Task, error)
+	Get(actor Actor, taskID int) (Task, error)
+	List(actor Actor, filter TaskFilter) ([]Task, error)
+	Update(actor Actor, taskID int, update TaskUpdate) (Task, error)
+	Delete(actor Actor, taskID int, subtasks SubtaskPolicy) error
+
+	WorkflowStore
+	TagStore
+	ProjectStore
+	WorkspaceStore
+	AssignmentStore
+	CommentStore
+	HistoryStore
+	TrashStore
+}
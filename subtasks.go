@@ -0,0 +1,172 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// Progress counts how many of a task's direct subtasks are complete
+type Progress struct {
+	Done  int `json:"done"`
+	Total int `json:"total"`
+}
+
+func (p Progress) String() string {
+	return fmt.Sprintf("%d/%d done", p.Done, p.Total)
+}
+
+// SubtaskPolicy says what happens to a task's subtasks when it is deleted
+type SubtaskPolicy int
+
+const (
+	SubtasksRefuse  SubtaskPolicy = iota // only delete tasks without subtasks
+	SubtasksCascade                      // delete the subtasks (and theirs) too
+	SubtasksPromote                      // move the subtasks up to the deleted task's parent
+)
+
+// ErrTaskHasSubtasks is returned when deleting a task with subtasks without saying what to do with them
+var ErrTaskHasSubtasks = errors.New("task has subtasks; choose whether to delete them too or promote them")
+
+// ParseSubtaskPolicy parses "cascade" or "promote"; an empty string refuses to delete parents
+func ParseSubtaskPolicy(input string) (SubtaskPolicy, error) {
+	switch sanitizeInput(input) {
+	case "":
+		return SubtasksRefuse, nil
+	case "cascade":
+		return SubtasksCascade, nil
+	case "promote":
+		return SubtasksPromote, nil
+	}
+	return SubtasksRefuse, fmt.Errorf("unknown subtask handling %q; expected cascade or promote", input)
+}
+
+// Function to count the done and total direct subtasks of the given tasks
+func loadProgress(db queryer, workspaceID int, taskIDs []int, workflow Workflow) (map[int]Progress, error) {
+	args := []interface{}{workspaceID}
+	query := `
+	SELECT parent_id, status FROM "task"
+	WHERE workspace_id = $1 AND parent_id IN (` + idList(&args, taskIDs) + `) AND deleted_at IS NULL`
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	progress := make(map[int]Progress)
+	for rows.Next() {
+		var parentID int
+		var status string
+		if err := rows.Scan(&parentID, &status); err != nil {
+			return nil, err
+		}
+		p := progress[parentID]
+		p.Total++
+		if workflow.IsDone(status) {
+			p.Done++
+		}
+		progress[parentID] = p
+	}
+	return progress, rows.Err()
+}
+
+// Function to check that a task may be put under a parent: the parent must be
+// a task in the same workspace and not the task itself or one of its subtasks.
+// taskID is 0 for a task that doesn't exist yet.
+func checkParent(db queryer, workspaceID, taskID, parentID int) error {
+	invalid := func(message string) error {
+		return &ValidationError{Field: "parent_id", Err: errors.New(message)}
+	}
+	if parentID == taskID {
+		return invalid("a task can't be its own subtask")
+	}
+
+	// Walk up from the new parent; meeting the task means it would become its own ancestor
+	id := parentID
+	for {
+		var next sql.NullInt64
+		err := db.QueryRow(`SELECT parent_id FROM "task" WHERE task_id = $1 AND workspace_id = $2 AND deleted_at IS NULL`, id, workspaceID).Scan(&next)
+		if err == sql.ErrNoRows {
+			return invalid("parent task not found")
+		} else if err != nil {
+			return err
+		}
+		if !next.Valid {
+			return nil
+		}
+		id = int(next.Int64)
+		if id == taskID {
+			return invalid("a task can't be moved under one of its own subtasks")
+		}
+	}
+}
+
+// Function to complete tasks that asked for it once all their subtasks are
+// done, starting at taskID and walking up through its parents
+func (s *sqlTaskStore) completeParents(tx queryer, workspaceID int, taskID *int) error {
+	for taskID != nil {
+		task, err := s.get(tx, workspaceID, *taskID)
+		if err != nil {
+			return err
+		}
+		if !task.Done {
+			if !task.AutoComplete || task.Blocked || task.Progress == nil || task.Progress.Done < task.Progress.Total {
+				return nil
+			}
+			workflow, err := loadWorkflow(tx, workspaceID)
+			if err != nil {
+				return err
+			}
+			status, ok := workflow.DoneStatusFrom(task.Status)
+			if !ok {
+				return nil // the workflow offers no way to complete it from its status
+			}
+			query := `UPDATE "task" SET status = $1, updated_at = CURRENT_TIMESTAMP WHERE task_id = $2 AND workspace_id = $3`
+			if _, err := tx.Exec(query, status, task.ID, workspaceID); err != nil {
+				return err
+			}
+			if err := s.recur(tx, workspaceID, task); err != nil {
+				return err
+			}
+		}
+		taskID = task.ParentID
+	}
+	return nil
+}
+
+// treeTask is a task with how deep it sits under its top-level ancestor
+type treeTask struct {
+	Task
+	Depth int
+}
+
+// Function to order tasks as a tree, each parent followed by its subtasks.
+// Siblings keep their order from tasks. Subtasks whose parent isn't in tasks
+// (e.g. because of a filter) are shown at the top level.
+func taskTree(tasks []Task) []treeTask {
+	listed := make(map[int]bool)
+	for _, task := range tasks {
+		listed[task.ID] = true
+	}
+	children := make(map[int][]Task)
+	var roots []Task
+	for _, task := range tasks {
+		if task.ParentID != nil && listed[*task.ParentID] {
+			children[*task.ParentID] = append(children[*task.ParentID], task)
+		} else {
+			roots = append(roots, task)
+		}
+	}
+
+	var tree []treeTask
+	var add func(task Task, depth int)
+	add = func(task Task, depth int) {
+		tree = append(tree, treeTask{Task: task, Depth: depth})
+		for _, child := range children[task.ID] {
+			add(child, depth+1)
+		}
+	}
+	for _, root := range roots {
+		add(root, 0)
+	}
+	return tree
+}
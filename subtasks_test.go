@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// Helper function to complete a task, returning it as it is afterwards
+func completeTask(t *testing.T, store TaskStore, actor Actor, taskID int) Task {
+	t.Helper()
+	status := statusDone
+	task, err := store.Update(actor, taskID, TaskUpdate{Status: &status})
+	if err != nil {
+		t.Fatalf("completing task %d: %v", taskID, err)
+	}
+	return task
+}
+
+// Helper function to read a task, failing the test if it can't be read
+func getTask(t *testing.T, store TaskStore, actor Actor, taskID int) Task {
+	t.Helper()
+	task, err := store.Get(actor, taskID)
+	if err != nil {
+		t.Fatalf("getting task %d: %v", taskID, err)
+	}
+	return task
+}
+
+func TestSubtaskProgress(t *testing.T) {
+	router := newTestRouter(t)
+	store := newSQLTaskStore(router, 0)
+	userID, workspaceID := newTestUser(t, router, "alice")
+	actor := Actor{UserID: userID, WorkspaceID: workspaceID}
+
+	parent, err := store.Create(actor, Task{Title: "Move house", AutoComplete: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var children []Task
+	for _, title := range []string{"Pack", "Unpack"} {
+		child, err := store.Create(actor, Task{Title: title, ParentID: &parent.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		children = append(children, child)
+	}
+
+	if p := getTask(t, store, actor, parent.ID).Progress; p == nil || *p != (Progress{Done: 0, Total: 2}) {
+		t.Errorf("progress = %+v; want 0/2", p)
+	}
+	completeTask(t, store, actor, children[0].ID)
+	got := getTask(t, store, actor, parent.ID)
+	if got.Progress == nil || *got.Progress != (Progress{Done: 1, Total: 2}) || got.Done {
+		t.Errorf("after one subtask: progress %+v, done %v; want 1/2 and open", got.Progress, got.Done)
+	}
+	completeTask(t, store, actor, children[1].ID)
+	if got := getTask(t, store, actor, parent.ID); !got.Done {
+		t.Error("the parent wasn't completed with its last subtask")
+	}
+
+	// A task can't move under itself or one of its subtasks
+	var validationErr *ValidationError
+	for _, parentID := range []int{parent.ID, children[0].ID} {
+		id := parentID
+		_, err := store.Update(actor, parent.ID, TaskUpdate{ParentID: &id})
+		if !errors.As(err, &validationErr) || validationErr.Field != "parent_id" {
+			t.Errorf("moving the parent under task %d = %v; want a validation error for parent_id", parentID, err)
+		}
+	}
+}
+
+func TestDeleteTaskWithSubtasks(t *testing.T) {
+	router := newTestRouter(t)
+	store := newSQLTaskStore(router, 0)
+	userID, workspaceID := newTestUser(t, router, "alice")
+	actor := Actor{UserID: userID, WorkspaceID: workspaceID}
+
+	newTree := func() (Task, Task, Task) {
+		root, err := store.Create(actor, Task{Title: "Root"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		parent, err := store.Create(actor, Task{Title: "Parent", ParentID: &root.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		child, err := store.Create(actor, Task{Title: "Child", ParentID: &parent.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return root, parent, child
+	}
+
+	_, parent, child := newTree()
+	if err := store.Delete(actor, parent.ID, SubtasksRefuse); !errors.Is(err, ErrTaskHasSubtasks) {
+		t.Errorf("deleting a parent without a policy = %v; want %v", err, ErrTaskHasSubtasks)
+	}
+	if err := store.Delete(actor, parent.ID, SubtasksCascade); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(actor, child.ID); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("getting the subtask of a deleted parent = %v; want %v", err, ErrTaskNotFound)
+	}
+
+	root, parent, child := newTree()
+	if err := store.Delete(actor, parent.ID, SubtasksPromote); err != nil {
+		t.Fatal(err)
+	}
+	if got := getTask(t, store, actor, child.ID); got.ParentID == nil || *got.ParentID != root.ID {
+		t.Errorf("promoted subtask has parent %v; want the deleted task's parent %d", got.ParentID, root.ID)
+	}
+}
+
+func TestParseSubtaskPolicy(t *testing.T) {
+	for input, want := range map[string]SubtaskPolicy{"": SubtasksRefuse, "cascade": SubtasksCascade, "promote": SubtasksPromote} {
+		if got, err := ParseSubtaskPolicy(input); got != want || err != nil {
+			t.Errorf("ParseSubtaskPolicy(%q) = %v, %v; want %v", input, got, err, want)
+		}
+	}
+	if _, err := ParseSubtaskPolicy("orphan"); err == nil {
+		t.Error("ParseSubtaskPolicy accepted orphan")
+	}
+}
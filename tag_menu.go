@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+)
+
+// Tag menu
+func tagMenu(store TagStore, actor Actor) {
+	for {
+		tags, err := store.Tags(actor)
+		if err != nil {
+			log.Println("Error loading tags:", err)
+			return
+		}
+		printTags(tags)
+
+		fmt.Println("\nTag Menu:")
+		fmt.Println("---------------------------------")
+		fmt.Println("1 - Rename Tag")
+		fmt.Println("2 - Merge Tags")
+		fmt.Println("3 - Delete Tag")
+		fmt.Println("4 - Back")
+
+		fmt.Print("Enter your choice: ")
+		choiceInput, _ := stdin.ReadString('\n')
+		choice, err := strconv.Atoi(sanitizeInput(choiceInput))
+		if err != nil {
+			log.Println("Invalid input. Please enter a number.")
+			continue
+		}
+
+		switch choice {
+		case 1:
+			fmt.Print("Enter tag to rename: ")
+			oldName, _ := stdin.ReadString('\n')
+			fmt.Print("Enter new tag name: ")
+			newName, _ := stdin.ReadString('\n')
+			err = store.RenameTag(actor, oldName, newName)
+		case 2:
+			fmt.Print("Enter tags to merge (comma-separated): ")
+			sources, _ := stdin.ReadString('\n')
+			fmt.Print("Merge into tag: ")
+			target, _ := stdin.ReadString('\n')
+			err = store.MergeTags(actor, splitTags(sources), target)
+		case 3:
+			fmt.Print("Enter tag to delete: ")
+			name, _ := stdin.ReadString('\n')
+			err = store.DeleteTag(actor, name)
+		case 4:
+			return
+		default:
+			fmt.Println("Invalid choice. Please try again.")
+			continue
+		}
+
+		if err != nil {
+			reportTagError(err)
+			continue
+		}
+		fmt.Println("Tags updated successfully!")
+	}
+}
+
+// Helper function to print a workspace's tags with their task counts
+func printTags(tags []Tag) {
+	fmt.Println("---------------------------------")
+	fmt.Println("YOUR TAGS:")
+	if len(tags) == 0 {
+		fmt.Println(" (none yet)")
+	}
+	for _, tag := range tags {
+		fmt.Printf(" %s (%d tasks)\n", tag.Name, tag.Tasks)
+	}
+}
+
+// Helper function to explain why a tag change was rejected
+func reportTagError(err error) {
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) || errors.Is(err, ErrTagNotFound) || err == ErrTagExists || errors.Is(err, ErrForbidden) {
+		fmt.Println("Error:", err)
+		return
+	}
+	log.Println("Error saving tags:", err)
+}
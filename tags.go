@@ -0,0 +1,362 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Tag is a label members can put on any number of their workspace's tasks
+type Tag struct {
+	Name  string `json:"name"`
+	Tasks int    `json:"tasks"` // number of tasks carrying the tag
+}
+
+// TaskFilter narrows down which tasks List returns. The zero value matches every task.
+type TaskFilter struct {
+	Tags        []string // only tasks carrying these tags
+	MatchAllTag bool     // true: every tag must be present (AND); false: any of them (OR)
+	Project     string   // only tasks in this project; otherwise tasks in archived projects are left out
+	AssigneeID  int      // only tasks assigned to this user
+	CreatorID   int      // only tasks this user created
+}
+
+var (
+	// ErrTagNotFound is returned when renaming, merging or deleting a tag the workspace doesn't have
+	ErrTagNotFound = errors.New("tag not found")
+	// ErrTagExists is returned when renaming a tag to a name that is already in use; merge instead
+	ErrTagExists = errors.New("a tag with that name already exists; merge the tags instead")
+)
+
+// TagStore lists and reorganises a workspace's tags. Members create tags by
+// putting them on tasks; renaming, merging and deleting them needs the admin role.
+type TagStore interface {
+	Tags(actor Actor) ([]Tag, error)
+	RenameTag(actor Actor, oldName, newName string) error
+	MergeTags(actor Actor, sources []string, target string) error
+	DeleteTag(actor Actor, name string) error
+}
+
+// Function to normalise a tag as typed by a user: trimmed and lower case
+func normalizeTag(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	switch {
+	case name == "":
+		return "", &ValidationError{Field: "tags", Err: errors.New("tags must not be empty")}
+	case len(name) > 50:
+		return "", &ValidationError{Field: "tags", Err: fmt.Errorf("tag %q must be at most 50 characters long", name)}
+	case strings.ContainsAny(name, ","):
+		return "", &ValidationError{Field: "tags", Err: fmt.Errorf("tag %q must not contain a comma", name)}
+	}
+	return name, nil
+}
+
+// Function to normalise a list of tags, dropping duplicates
+func normalizeTags(names []string) ([]string, error) {
+	var tags []string
+	seen := make(map[string]bool)
+	for _, name := range names {
+		tag, err := normalizeTag(name)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	return tags, nil
+}
+
+// Function to split comma-separated tags as typed at a prompt or on the command line
+func splitTags(input string) []string {
+	var tags []string
+	for _, tag := range strings.Split(input, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// Function to build the SQL condition for the filter's (normalised) tags,
+// appending its arguments after the workspace ID in args[0]
+func (f TaskFilter) tagCondition(args *[]interface{}) string {
+	tags := f.Tags
+	if len(tags) == 0 {
+		return ""
+	}
+	*args = append(*args, (*args)[0]) // workspace_id of the tags
+	condition := ` AND task_id IN (
+		SELECT tt.task_id FROM task_tag tt JOIN tag g ON g.tag_id = tt.tag_id
+		WHERE g.workspace_id = ` + placeholder(*args) + ` AND g.name IN (`
+	for i, tag := range tags {
+		*args = append(*args, tag)
+		if i > 0 {
+			condition += ", "
+		}
+		condition += placeholder(*args)
+	}
+	condition += `)`
+	if f.MatchAllTag {
+		condition += fmt.Sprintf(` GROUP BY tt.task_id HAVING COUNT(DISTINCT g.tag_id) = %d`, len(tags))
+	}
+	return condition + `)`
+}
+
+// Function to read the tags of the given tasks, keyed by task ID
+func loadTaskTags(db queryer, workspaceID int, taskIDs []int) (map[int][]string, error) {
+	args := []interface{}{workspaceID}
+	query := `
+	SELECT tt.task_id, g.name FROM task_tag tt JOIN tag g ON g.tag_id = tt.tag_id
+	WHERE g.workspace_id = $1 AND tt.task_id IN (` + idList(&args, taskIDs) + `) ORDER BY g.name`
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tags := make(map[int][]string)
+	for rows.Next() {
+		var taskID int
+		var name string
+		if err := rows.Scan(&taskID, &name); err != nil {
+			return nil, err
+		}
+		tags[taskID] = append(tags[taskID], name)
+	}
+	return tags, rows.Err()
+}
+
+// Function to get a tag's ID, creating the tag if the workspace doesn't have it yet
+func ensureTag(db queryer, workspaceID int, name string) (int, error) {
+	_, err := db.Exec(`INSERT INTO tag (workspace_id, name) VALUES ($1, $2) ON CONFLICT (workspace_id, name) DO NOTHING`, workspaceID, name)
+	if err != nil {
+		return 0, err
+	}
+	var tagID int
+	err = db.QueryRow(`SELECT tag_id FROM tag WHERE workspace_id = $1 AND name = $2`, workspaceID, name).Scan(&tagID)
+	return tagID, err
+}
+
+// Function to put tags on a task, creating any tags that don't exist yet
+func addTaskTags(db queryer, workspaceID, taskID int, tags []string) error {
+	for _, name := range tags {
+		tagID, err := ensureTag(db, workspaceID, name)
+		if err != nil {
+			return err
+		}
+		_, err = db.Exec(`INSERT INTO task_tag (task_id, tag_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`, taskID, tagID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Function to take tags off a task; the tags themselves are kept
+func removeTaskTags(db queryer, workspaceID, taskID int, tags []string) error {
+	for _, name := range tags {
+		query := `DELETE FROM task_tag WHERE task_id = $1 AND tag_id IN (SELECT tag_id FROM tag WHERE workspace_id = $2 AND name = $3)`
+		if _, err := db.Exec(query, taskID, workspaceID, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Tags returns every tag in the workspace, with how many tasks carry it
+func (s *sqlTaskStore) Tags(actor Actor) ([]Tag, error) {
+	query := `
+	SELECT g.name, COUNT(t.task_id) FROM tag g LEFT JOIN task_tag tt ON tt.tag_id = g.tag_id
+	LEFT JOIN "task" t ON t.task_id = tt.task_id AND t.deleted_at IS NULL
+	WHERE g.workspace_id = $1 GROUP BY g.tag_id, g.name ORDER BY g.name`
+	db := s.router.Reader(actor.UserID)
+	if _, err := requireRole(db, actor, RoleViewer); err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(query, actor.WorkspaceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tags []Tag
+	for rows.Next() {
+		var tag Tag
+		if err := rows.Scan(&tag.Name, &tag.Tasks); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+// RenameTag changes a tag's name on every task that carries it
+func (s *sqlTaskStore) RenameTag(actor Actor, oldName, newName string) error {
+	oldName, err := normalizeTag(oldName)
+	if err != nil {
+		return err
+	}
+	newName, err = normalizeTag(newName)
+	if err != nil {
+		return err
+	}
+	if oldName == newName {
+		return nil
+	}
+
+	tx, err := s.router.Writer(actor.UserID).Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := requireRole(tx, actor, RoleAdmin); err != nil {
+		return err
+	}
+	before, err := s.snapshotTagged(tx, actor.WorkspaceID, oldName)
+	if err != nil {
+		return err
+	}
+	var existing int
+	err = tx.QueryRow(`SELECT tag_id FROM tag WHERE workspace_id = $1 AND name = $2`, actor.WorkspaceID, newName).Scan(&existing)
+	if err == nil {
+		return ErrTagExists
+	} else if err != sql.ErrNoRows {
+		return err
+	}
+
+	result, err := tx.Exec(`UPDATE tag SET name = $1 WHERE workspace_id = $2 AND name = $3`, newName, actor.WorkspaceID, oldName)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrTagNotFound
+	}
+	if err := s.recordChanges(tx, actor, before); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Function to snapshot the tasks carrying any of the named tags, which are
+// the only ones renaming, merging or deleting those tags changes
+func (s *sqlTaskStore) snapshotTagged(db queryer, workspaceID int, names ...string) (map[int]Task, error) {
+	if len(names) == 0 {
+		return s.snapshot(db, workspaceID, nil)
+	}
+	args := []interface{}{workspaceID}
+	query := `
+	SELECT DISTINCT tt.task_id FROM task_tag tt JOIN tag g ON g.tag_id = tt.tag_id
+	WHERE g.workspace_id = $1 AND g.name IN (`
+	for i, name := range names {
+		args = append(args, name)
+		if i > 0 {
+			query += ", "
+		}
+		query += placeholder(args)
+	}
+	taskIDs, err := queryIDs(db, query+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	return s.snapshot(db, workspaceID, taskIDs)
+}
+
+// MergeTags moves every task carrying one of the source tags onto the target
+// tag, creating it if needed, and then deletes the source tags
+func (s *sqlTaskStore) MergeTags(actor Actor, sources []string, target string) error {
+	target, err := normalizeTag(target)
+	if err != nil {
+		return err
+	}
+	sources, err = normalizeTags(sources)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.router.Writer(actor.UserID).Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := requireRole(tx, actor, RoleAdmin); err != nil {
+		return err
+	}
+	before, err := s.snapshotTagged(tx, actor.WorkspaceID, sources...)
+	if err != nil {
+		return err
+	}
+	targetID, err := ensureTag(tx, actor.WorkspaceID, target)
+	if err != nil {
+		return err
+	}
+	sort.Strings(sources)
+	for _, source := range sources {
+		if source == target {
+			continue
+		}
+		var sourceID int
+		err := tx.QueryRow(`SELECT tag_id FROM tag WHERE workspace_id = $1 AND name = $2`, actor.WorkspaceID, source).Scan(&sourceID)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("%w: %q", ErrTagNotFound, source)
+		} else if err != nil {
+			return err
+		}
+
+		// Tasks that already carry the target keep a single link to it
+		query := `
+		INSERT INTO task_tag (task_id, tag_id)
+		SELECT task_id, $1 FROM task_tag WHERE tag_id = $2
+		ON CONFLICT DO NOTHING`
+		if _, err := tx.Exec(query, targetID, sourceID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM tag WHERE tag_id = $1`, sourceID); err != nil {
+			return err
+		}
+	}
+	if err := s.recordChanges(tx, actor, before); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteTag removes a tag from every task and forgets it
+func (s *sqlTaskStore) DeleteTag(actor Actor, name string) error {
+	name, err := normalizeTag(name)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.router.Writer(actor.UserID).Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := requireRole(tx, actor, RoleAdmin); err != nil {
+		return err
+	}
+	before, err := s.snapshotTagged(tx, actor.WorkspaceID, name)
+	if err != nil {
+		return err
+	}
+	result, err := tx.Exec(`DELETE FROM tag WHERE workspace_id = $1 AND name = $2`, actor.WorkspaceID, name)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrTagNotFound
+	}
+	if err := s.recordChanges(tx, actor, before); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Helper function to get the sorted titles of tasks
+func taskTitles(tasks []Task) []string {
+	titles := []string{}
+	for _, task := range tasks {
+		titles = append(titles, task.Title)
+	}
+	sort.Strings(titles)
+	return titles
+}
+
+func TestNormalizeTags(t *testing.T) {
+	got, err := normalizeTags([]string{" Home ", "home", "WORK"})
+	if err != nil || !reflect.DeepEqual(got, []string{"home", "work"}) {
+		t.Errorf("normalizeTags = %q, %v; want [home work]", got, err)
+	}
+	for _, bad := range []string{" ", "a,b", strings.Repeat("x", 51)} {
+		var validationErr *ValidationError
+		if _, err := normalizeTags([]string{bad}); !errors.As(err, &validationErr) || validationErr.Field != "tags" {
+			t.Errorf("normalizeTags(%q) = %v; want a validation error for tags", bad, err)
+		}
+	}
+}
+
+func TestTags(t *testing.T) {
+	router := newTestRouter(t)
+	store := newSQLTaskStore(router, 0)
+	userID, workspaceID := newTestUser(t, router, "alice")
+	actor := Actor{UserID: userID, WorkspaceID: workspaceID}
+	for _, task := range []Task{
+		{Title: "Paint", Tags: []string{"home", "weekend"}},
+		{Title: "Mow", Tags: []string{"Home"}},
+		{Title: "Report", Tags: []string{"work"}},
+		{Title: "Nap"},
+	} {
+		if _, err := store.Create(actor, task); err != nil {
+			t.Fatal(err)
+		}
+	}
+	list := func(filter TaskFilter) []string {
+		t.Helper()
+		tasks, err := store.List(actor, filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return taskTitles(tasks)
+	}
+
+	filters := []struct {
+		filter TaskFilter
+		want   []string
+	}{
+		{TaskFilter{Tags: []string{"home"}}, []string{"Mow", "Paint"}},
+		{TaskFilter{Tags: []string{"home", "work"}}, []string{"Mow", "Paint", "Report"}},
+		{TaskFilter{Tags: []string{"home", "weekend"}, MatchAllTag: true}, []string{"Paint"}},
+		{TaskFilter{Tags: []string{"holiday"}}, []string{}},
+	}
+	for _, tt := range filters {
+		if got := list(tt.filter); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("List(%+v) = %q; want %q", tt.filter, got, tt.want)
+		}
+	}
+
+	if err := store.RenameTag(actor, "home", "work"); !errors.Is(err, ErrTagExists) {
+		t.Errorf("renaming onto an existing tag = %v; want %v", err, ErrTagExists)
+	}
+	if err := store.RenameTag(actor, "home", "house"); err != nil {
+		t.Fatal(err)
+	}
+	if got := list(TaskFilter{Tags: []string{"house"}}); !reflect.DeepEqual(got, []string{"Mow", "Paint"}) {
+		t.Errorf("tasks tagged house after the rename = %q; want [Mow Paint]", got)
+	}
+
+	if err := store.MergeTags(actor, []string{"weekend", "work"}, "house"); err != nil {
+		t.Fatal(err)
+	}
+	if got := list(TaskFilter{Tags: []string{"house"}}); !reflect.DeepEqual(got, []string{"Mow", "Paint", "Report"}) {
+		t.Errorf("tasks tagged house after the merge = %q; want [Mow Paint Report]", got)
+	}
+	tags, err := store.Tags(actor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tags, []Tag{{Name: "house", Tasks: 3}}) {
+		t.Errorf("Tags after the merge = %+v; want only house on 3 tasks", tags)
+	}
+
+	if err := store.DeleteTag(actor, "house"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteTag(actor, "house"); !errors.Is(err, ErrTagNotFound) {
+		t.Errorf("deleting a deleted tag = %v; want %v", err, ErrTagNotFound)
+	}
+	tasks, err := store.List(actor, TaskFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, task := range tasks {
+		if len(task.Tags) != 0 {
+			t.Errorf("task %q still has tags %q", task.Title, task.Tags)
+		}
+	}
+}
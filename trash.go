@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// ErrParentInTrash is returned when restoring a subtask whose parent is still in the trash
+var ErrParentInTrash = errors.New("the task's parent is in the trash")
+
+// TrashStore keeps deleted tasks. TaskStore.Delete moves a task to the trash,
+// where it can be restored until the retention period is over and the
+// sweeper purges it for good.
+type TrashStore interface {
+	Trash(actor Actor) ([]Task, error)
+	Restore(actor Actor, taskID int) (Task, error)
+}
+
+// Query selecting a task and every subtask below it, with the task ID as $3
+const subtreeQuery = `
+	WITH RECURSIVE subtree (task_id) AS (
+		SELECT CAST($3 AS INT)
+		UNION ALL
+		SELECT t.task_id FROM "task" t JOIN subtree s ON t.parent_id = s.task_id
+	)
+	SELECT task_id FROM subtree`
+
+// Function to move a task and its subtasks to the trash, returning how many
+// tasks were moved
+func trashTask(db queryer, workspaceID, taskID int, now time.Time) (int64, error) {
+	query := `
+	UPDATE "task" SET deleted_at = $1
+	WHERE workspace_id = $2 AND deleted_at IS NULL AND task_id IN (` + subtreeQuery + `)`
+	result, err := db.Exec(query, now, workspaceID, taskID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Trash returns the workspace's deleted tasks, most recently deleted first
+func (s *sqlTaskStore) Trash(actor Actor) ([]Task, error) {
+	db := s.router.Reader(actor.UserID)
+	if _, err := requireRole(db, actor, RoleViewer); err != nil {
+		return nil, err
+	}
+	query := `SELECT ` + taskColumns + ` FROM "task" WHERE workspace_id = $1 AND deleted_at IS NOT NULL ORDER BY deleted_at DESC, task_id`
+	return s.queryTasks(db, actor.WorkspaceID, query, actor.WorkspaceID)
+}
+
+// Restore takes a task out of the trash along with the subtasks deleted with
+// it. Like deleting, members may only restore the tasks they created.
+func (s *sqlTaskStore) Restore(actor Actor, taskID int) (Task, error) {
+	tx, err := s.router.Writer(actor.UserID).Begin()
+	if err != nil {
+		return Task{}, err
+	}
+	defer tx.Rollback()
+
+	role, err := requireRole(tx, actor, RoleMember)
+	if err != nil {
+		return Task{}, err
+	}
+	family, err := taskFamily(tx, actor.WorkspaceID, taskID)
+	if err != nil {
+		return Task{}, err
+	}
+	before, err := s.snapshot(tx, actor.WorkspaceID, family)
+	if err != nil {
+		return Task{}, err
+	}
+	task, ok := before[taskID]
+	if !ok || task.DeletedAt == nil {
+		return Task{}, ErrTaskNotFound
+	}
+	if task.UserID != actor.UserID && !role.AtLeast(RoleAdmin) {
+		return Task{}, fmt.Errorf("%w: members can only restore tasks they created", ErrForbidden)
+	}
+	if task.ParentID != nil {
+		if parent, ok := before[*task.ParentID]; ok && parent.DeletedAt != nil {
+			return Task{}, fmt.Errorf("%w; restore task %d first", ErrParentInTrash, parent.ID)
+		}
+	}
+
+	// Subtasks deleted earlier on their own stay in the trash
+	query := `
+	UPDATE "task" SET deleted_at = NULL
+	WHERE workspace_id = $1 AND deleted_at >= $2 AND task_id IN (` + subtreeQuery + `)`
+	if _, err := tx.Exec(query, actor.WorkspaceID, task.DeletedAt.UTC(), taskID); err != nil {
+		return Task{}, err
+	}
+	if err := s.completeParents(tx, actor.WorkspaceID, task.ParentID); err != nil {
+		return Task{}, err
+	}
+	if err := s.recordChanges(tx, actor, before); err != nil {
+		return Task{}, err
+	}
+	restored, err := s.get(tx, actor.WorkspaceID, taskID)
+	if err != nil {
+		return Task{}, err
+	}
+	return restored, tx.Commit()
+}
+
+// PurgeTrash deletes for good every task that has been in the trash for
+// longer than the retention period, in all workspaces, and records the purge
+// in the history of each task it takes, subtasks included. It returns how
+// many tasks it took.
+func (s *sqlTaskStore) PurgeTrash(now time.Time) (int64, error) {
+	tx, err := s.router.Writer(anonymousSession).Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	cutoff := now.UTC().Add(-s.retention)
+	query := `
+	INSERT INTO task_event (workspace_id, task_id, action)
+	WITH RECURSIVE purged (task_id) AS (
+		SELECT task_id FROM "task" WHERE deleted_at < $1
+		UNION
+		SELECT t.task_id FROM "task" t JOIN purged p ON t.parent_id = p.task_id
+	)
+	SELECT t.workspace_id, t.task_id, $2 FROM "task" t JOIN purged p ON p.task_id = t.task_id`
+	result, err := tx.Exec(query, cutoff, EventPurge)
+	if err != nil {
+		return 0, err
+	}
+	// Subtasks go with their parents through the foreign key, so count the events instead of the deleted rows
+	purged, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`DELETE FROM "task" WHERE deleted_at < $1`, cutoff); err != nil {
+		return 0, err
+	}
+	return purged, tx.Commit()
+}
+
+// Function to purge expired tasks from the trash now and then every interval,
+// for as long as the program runs
+func runTrashSweeper(store *sqlTaskStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		purged, err := store.PurgeTrash(time.Now())
+		if err != nil {
+			log.Println("Error purging the trash:", err)
+		} else if purged > 0 {
+			log.Printf("Purged %d tasks from the trash", purged)
+		}
+		<-ticker.C
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+)
+
+// Trash menu
+func trashMenu(store TrashStore, actor Actor) {
+	for {
+		tasks, err := store.Trash(actor)
+		if err != nil {
+			log.Println("Error loading the trash:", err)
+			return
+		}
+		printTrash(tasks)
+
+		fmt.Print("\nEnter task ID to restore, or press Enter to go back: ")
+		idInput, _ := stdin.ReadString('\n')
+		idInput = sanitizeInput(idInput)
+		if idInput == "" {
+			return
+		}
+		taskID, err := strconv.Atoi(idInput)
+		if err != nil {
+			log.Println("Invalid input. Please enter a number.")
+			continue
+		}
+
+		_, err = store.Restore(actor, taskID)
+		if err == ErrTaskNotFound {
+			fmt.Println("Task ID is not in the trash.")
+			continue
+		} else if errors.Is(err, ErrForbidden) || errors.Is(err, ErrParentInTrash) {
+			fmt.Println("Error:", err)
+			continue
+		} else if err != nil {
+			log.Println("Error restoring task:", err)
+			continue
+		}
+		fmt.Println("Task restored successfully!")
+	}
+}
+
+// Helper function to print the tasks in the trash and when each will be purged
+func printTrash(tasks []Task) {
+	fmt.Println("---------------------------------")
+	fmt.Println("TRASH:")
+	if len(tasks) == 0 {
+		fmt.Println(" (empty)")
+	}
+	for _, task := range tasks {
+		fmt.Printf(" %d: %s (deleted %s, purged after %s)\n", task.ID, task.Title, formatTime(*task.DeletedAt), formatTime(*task.PurgeAt))
+	}
+}
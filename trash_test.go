@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTrashRestore(t *testing.T) {
+	router := newTestRouter(t)
+	store := newSQLTaskStore(router, time.Hour)
+	ownerID, workspaceID := newTestUser(t, router, "carol")
+	daveID, _ := newTestUser(t, router, "dave")
+	owner := Actor{UserID: ownerID, WorkspaceID: workspaceID}
+	dave := Actor{UserID: daveID, WorkspaceID: workspaceID}
+	if err := store.AddMember(owner, "dave", RoleMember); err != nil {
+		t.Fatal(err)
+	}
+
+	parent, err := store.Create(owner, Task{Title: "Parent"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var children []Task
+	for _, title := range []string{"Kept", "Deleted first"} {
+		child, err := store.Create(owner, Task{Title: title, ParentID: &parent.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		children = append(children, child)
+	}
+	if _, err := store.Restore(owner, parent.ID); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("restoring a task that isn't in the trash = %v; want %v", err, ErrTaskNotFound)
+	}
+	if err := store.Delete(owner, children[1].ID, SubtasksRefuse); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(owner, parent.ID, SubtasksCascade); err != nil {
+		t.Fatal(err)
+	}
+
+	trash, err := store.Trash(owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := taskTitles(trash); !reflect.DeepEqual(got, []string{"Deleted first", "Kept", "Parent"}) {
+		t.Errorf("trash = %q; want all three tasks", got)
+	}
+	for _, task := range trash {
+		if task.DeletedAt == nil || task.PurgeAt == nil || !task.PurgeAt.Equal(task.DeletedAt.Add(time.Hour)) {
+			t.Errorf("task %q deleted at %v is purged at %v; want an hour later", task.Title, task.DeletedAt, task.PurgeAt)
+		}
+	}
+
+	if _, err := store.Restore(owner, children[0].ID); !errors.Is(err, ErrParentInTrash) {
+		t.Errorf("restoring a subtask of a deleted task = %v; want %v", err, ErrParentInTrash)
+	}
+	if _, err := store.Restore(dave, parent.ID); !errors.Is(err, ErrForbidden) {
+		t.Errorf("a member restoring someone else's task = %v; want %v", err, ErrForbidden)
+	}
+	if _, err := store.Restore(owner, parent.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// The subtask deleted with the parent comes back; the one deleted before it doesn't
+	trash, err = store.Trash(owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := taskTitles(trash); !reflect.DeepEqual(got, []string{"Deleted first"}) {
+		t.Errorf("trash after restoring = %q; want [Deleted first]", got)
+	}
+	if got := getTask(t, store, owner, children[0].ID); got.DeletedAt != nil {
+		t.Error("the subtask deleted with its parent is still in the trash")
+	}
+}
+
+func TestPurgeTrash(t *testing.T) {
+	router := newTestRouter(t)
+	store := newSQLTaskStore(router, time.Hour)
+	userID, workspaceID := newTestUser(t, router, "alice")
+	actor := Actor{UserID: userID, WorkspaceID: workspaceID}
+
+	parent, err := store.Create(actor, Task{Title: "Old"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	child, err := store.Create(actor, Task{Title: "Old subtask", ParentID: &parent.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Create(actor, Task{Title: "Current"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(actor, parent.ID, SubtasksCascade); err != nil {
+		t.Fatal(err)
+	}
+
+	if purged, err := store.PurgeTrash(time.Now()); err != nil || purged != 0 {
+		t.Errorf("PurgeTrash within the retention = %d, %v; want nothing purged", purged, err)
+	}
+	if purged, err := store.PurgeTrash(time.Now().Add(2 * time.Hour)); err != nil || purged != 2 {
+		t.Errorf("PurgeTrash after the retention = %d, %v; want both tasks purged", purged, err)
+	}
+	if _, err := store.Restore(actor, parent.ID); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("restoring a purged task = %v; want %v", err, ErrTaskNotFound)
+	}
+	tasks, err := store.List(actor, TaskFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := taskTitles(tasks); !reflect.DeepEqual(got, []string{"Current"}) {
+		t.Errorf("tasks after the purge = %q; want [Current]", got)
+	}
+
+	// The history outlives the purge, subtasks included
+	want := []string{"create by alice", "delete by alice", "purge by "}
+	for _, taskID := range []int{parent.ID, child.ID} {
+		if got := historyActions(t, store, actor, taskID); !reflect.DeepEqual(got, want) {
+			t.Errorf("history of task %d = %q; want %q", taskID, got, want)
+		}
+	}
+}
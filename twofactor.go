@@ -0,0 +1,360 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Time-based one-time passwords as in RFC 6238, with the parameters every
+// authenticator app supports: HMAC-SHA1, 6 digits, 30-second steps
+const (
+	totpIssuer = "tms"
+	totpDigits = 6
+	totpPeriod = 30
+	totpSkew   = 1 // steps either side of now that are still accepted, for clock drift
+
+	recoveryCodeCount = 10
+)
+
+var (
+	// ErrSecondFactorRequired is returned when logging in to an account with two-factor authentication without a code
+	ErrSecondFactorRequired = errors.New("two-factor code required")
+	// ErrInvalidSecondFactor is returned for a wrong, expired, reused or already spent code
+	ErrInvalidSecondFactor = errors.New("invalid two-factor code")
+	// ErrTwoFactorEnabled is returned when setting up two-factor authentication a second time
+	ErrTwoFactorEnabled = errors.New("two-factor authentication is already on")
+	// ErrTwoFactorDisabled is returned when turning off two-factor authentication that isn't on
+	ErrTwoFactorDisabled = errors.New("two-factor authentication is not on")
+	// ErrNoTwoFactorSetup is returned when confirming a setup that was never started
+	ErrNoTwoFactorSetup = errors.New("start setting up two-factor authentication first")
+)
+
+// WorkspaceTwoFactorError is returned when turning off two-factor
+// authentication while in a workspace that requires it. Anyone can be added
+// to a workspace without being asked, so it names the workspace to leave.
+type WorkspaceTwoFactorError struct {
+	WorkspaceID int
+	Name        string
+}
+
+func (e *WorkspaceTwoFactorError) Error() string {
+	return fmt.Sprintf("%v: workspace %q (%d) requires two-factor authentication; leave it to turn it off", ErrForbidden, e.Name, e.WorkspaceID)
+}
+
+func (e *WorkspaceTwoFactorError) Unwrap() error {
+	return ErrForbidden
+}
+
+// TwoFactorSetup is what an authenticator app needs to generate codes for an account
+type TwoFactorSetup struct {
+	Secret string `json:"secret"`      // base32, for typing into the app by hand
+	URI    string `json:"otpauth_uri"` // otpauth:// URI, usually shown as a QR code
+}
+
+// TwoFactorStatus says whether an account uses two-factor authentication
+type TwoFactorStatus struct {
+	Enabled           bool `json:"enabled"`
+	RecoveryCodesLeft int  `json:"recovery_codes_left"`
+}
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Function to generate the code for one time step of a base32 secret
+func totpCode(secret string, step int64) (string, error) {
+	key, err := base32NoPadding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation (RFC 4226 section 5.3)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%uint32(math.Pow10(totpDigits))), nil
+}
+
+// Function to find the time step a code was generated for, within the
+// accepted clock drift. Steps up to lastStep were already used and are refused.
+func matchTOTP(secret, code string, now time.Time, lastStep int64) (int64, bool) {
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		expected, err := totpCode(secret, step)
+		if err == nil && hmac.Equal([]byte(expected), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// Function to build the otpauth:// URI that authenticator apps read from a QR code
+func totpURI(secret, username string) string {
+	values := url.Values{}
+	values.Set("secret", secret)
+	values.Set("issuer", totpIssuer)
+	values.Set("algorithm", "SHA1")
+	values.Set("digits", strconv.Itoa(totpDigits))
+	values.Set("period", strconv.Itoa(totpPeriod))
+	label := url.PathEscape(totpIssuer + ":" + username)
+	return "otpauth://totp/" + label + "?" + values.Encode()
+}
+
+// Helper function to generate random base32 text from n random bytes
+func randomBase32(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base32NoPadding.EncodeToString(b), nil
+}
+
+// Helper function to normalise a code as typed: recovery codes may be given
+// in any case and with or without their dash
+func normalizeCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// Function to read a user's TOTP secret and when it was confirmed, if ever
+func loadTOTP(db queryer, userID int) (secret string, enabled bool, lastStep int64, err error) {
+	var storedSecret sql.NullString
+	var enabledAt sql.NullTime
+	query := `SELECT totp_secret, totp_enabled_at, totp_last_step FROM "user" WHERE user_id = $1`
+	err = db.QueryRow(query, userID).Scan(&storedSecret, &enabledAt, &lastStep)
+	return storedSecret.String, enabledAt.Valid, lastStep, err
+}
+
+// Function to check whether a user has two-factor authentication on
+func twoFactorEnabled(db queryer, userID int) (bool, error) {
+	_, enabled, _, err := loadTOTP(db, userID)
+	return enabled, err
+}
+
+// Function to check a code from the user's authenticator app, or else one of
+// their unused recovery codes, and spend it so it can't be used again
+func verifySecondFactor(db queryer, userID int, code string) error {
+	secret, enabled, lastStep, err := loadTOTP(db, userID)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return ErrTwoFactorDisabled
+	}
+	code = normalizeCode(code)
+
+	if step, ok := matchTOTP(secret, code, time.Now(), lastStep); ok {
+		// Only one login may use a given code, even if two race for it
+		query := `UPDATE "user" SET totp_last_step = $1 WHERE user_id = $2 AND totp_last_step < $1`
+		result, err := db.Exec(query, step, userID)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return err
+		} else if n == 1 {
+			return nil
+		}
+		return ErrInvalidSecondFactor
+	}
+
+	query := `UPDATE recovery_code SET used_at = $1 WHERE user_id = $2 AND code_hash = $3 AND used_at IS NULL`
+	result, err := db.Exec(query, time.Now().UTC(), userID, hashToken(code))
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrInvalidSecondFactor
+	}
+	return nil
+}
+
+// Function to ask for the second factor when logging in: users without
+// two-factor authentication pass, everyone else needs a valid code
+func checkSecondFactor(router *dbRouter, userID int, code string) error {
+	db := router.Writer(anonymousSession)
+	enabled, err := twoFactorEnabled(db, userID)
+	if err != nil || !enabled {
+		return err
+	}
+	if strings.TrimSpace(code) == "" {
+		return ErrSecondFactorRequired
+	}
+	return verifySecondFactor(db, userID, code)
+}
+
+// Function to report whether a user has two-factor authentication on and how
+// many recovery codes they have left
+func twoFactorStatus(router *dbRouter, userID int) (TwoFactorStatus, error) {
+	db := router.Reader(userID)
+	var status TwoFactorStatus
+	var err error
+	if status.Enabled, err = twoFactorEnabled(db, userID); err != nil {
+		return TwoFactorStatus{}, err
+	}
+	query := `SELECT COUNT(*) FROM recovery_code WHERE user_id = $1 AND used_at IS NULL`
+	err = db.QueryRow(query, userID).Scan(&status.RecoveryCodesLeft)
+	return status, err
+}
+
+// Function to start setting up two-factor authentication with a new secret.
+// It only takes effect once confirmed with a code generated from the secret.
+func startTwoFactor(router *dbRouter, userID int) (TwoFactorSetup, error) {
+	db := router.Writer(userID)
+	var username string
+	var enabledAt sql.NullTime
+	query := `SELECT username, totp_enabled_at FROM "user" WHERE user_id = $1`
+	if err := db.QueryRow(query, userID).Scan(&username, &enabledAt); err != nil {
+		return TwoFactorSetup{}, err
+	}
+	if enabledAt.Valid {
+		return TwoFactorSetup{}, ErrTwoFactorEnabled
+	}
+
+	secret, err := randomBase32(20) // 160 bits, as RFC 4226 recommends
+	if err != nil {
+		return TwoFactorSetup{}, err
+	}
+	if _, err := db.Exec(`UPDATE "user" SET totp_secret = $1 WHERE user_id = $2`, secret, userID); err != nil {
+		return TwoFactorSetup{}, err
+	}
+	return TwoFactorSetup{Secret: secret, URI: totpURI(secret, username)}, nil
+}
+
+// Function to turn on two-factor authentication once the user proves their
+// app has the secret, returning the one-time recovery codes to keep somewhere safe
+func confirmTwoFactor(router *dbRouter, userID int, code string) ([]string, error) {
+	tx, err := router.Writer(userID).Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	secret, enabled, _, err := loadTOTP(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case enabled:
+		return nil, ErrTwoFactorEnabled
+	case secret == "":
+		return nil, ErrNoTwoFactorSetup
+	}
+	step, ok := matchTOTP(secret, normalizeCode(code), time.Now(), 0)
+	if !ok {
+		return nil, ErrInvalidSecondFactor
+	}
+	query := `UPDATE "user" SET totp_enabled_at = $1, totp_last_step = $2 WHERE user_id = $3`
+	if _, err := tx.Exec(query, time.Now().UTC(), step, userID); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM recovery_code WHERE user_id = $1`, userID); err != nil {
+		return nil, err
+	}
+	var codes []string
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := randomBase32(5) // 8 characters, 40 bits
+		if err != nil {
+			return nil, err
+		}
+		code = strings.ToLower(code)
+		query := `INSERT INTO recovery_code (user_id, code_hash) VALUES ($1, $2)`
+		if _, err := tx.Exec(query, userID, hashToken(code)); err != nil {
+			return nil, err
+		}
+		codes = append(codes, code[:4]+"-"+code[4:])
+	}
+	return codes, tx.Commit()
+}
+
+// Function to turn off two-factor authentication, which takes a current code.
+// Users in a workspace that requires it must leave that workspace first; the
+// refusal names it.
+func disableTwoFactor(router *dbRouter, userID int, code string) error {
+	tx, err := router.Writer(userID).Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var required WorkspaceTwoFactorError
+	query := `
+	SELECT w.workspace_id, w.name FROM workspace w JOIN workspace_member m ON m.workspace_id = w.workspace_id
+	WHERE m.user_id = $1 AND w.require_two_factor ORDER BY w.workspace_id`
+	err = tx.QueryRow(query, userID).Scan(&required.WorkspaceID, &required.Name)
+	if err == nil {
+		return &required
+	} else if err != sql.ErrNoRows {
+		return err
+	}
+	if err := verifySecondFactor(tx, userID, code); err != nil {
+		return err
+	}
+
+	query = `UPDATE "user" SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = 0 WHERE user_id = $1`
+	if _, err := tx.Exec(query, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM recovery_code WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Function to refuse work in a workspace that requires two-factor
+// authentication from a user who hasn't turned it on
+func checkTwoFactorRequirement(db queryer, actor Actor) error {
+	var required bool
+	query := `SELECT require_two_factor FROM workspace WHERE workspace_id = $1`
+	if err := db.QueryRow(query, actor.WorkspaceID).Scan(&required); err != nil || !required {
+		return err
+	}
+	enabled, err := twoFactorEnabled(db, actor.UserID)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return fmt.Errorf("%w: this workspace requires two-factor authentication; turn it on for your account first", ErrForbidden)
+	}
+	return nil
+}
+
+// SetTwoFactorRequired makes two-factor authentication a condition of working
+// in the actor's workspace, or stops requiring it. Admins turning it on must
+// use it themselves, so they don't lock themselves out.
+func (s *sqlTaskStore) SetTwoFactorRequired(actor Actor, required bool) error {
+	db := s.router.Writer(actor.UserID)
+	if _, err := requireRole(db, actor, RoleAdmin); err != nil {
+		return err
+	}
+	if required {
+		enabled, err := twoFactorEnabled(db, actor.UserID)
+		if err != nil {
+			return err
+		}
+		if !enabled {
+			return fmt.Errorf("%w: turn on two-factor authentication for your own account first", ErrForbidden)
+		}
+	}
+	_, err := db.Exec(`UPDATE workspace SET require_two_factor = $1 WHERE workspace_id = $2`, required, actor.WorkspaceID)
+	return err
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Two-factor authentication menu
+func twoFactorMenu(store WorkspaceStore, router *dbRouter, limiter *loginLimiter, userID int) {
+	status, err := twoFactorStatus(router, userID)
+	if err != nil {
+		log.Println("Error loading two-factor authentication:", err)
+		return
+	}
+	fmt.Println("---------------------------------")
+	if !status.Enabled {
+		fmt.Println("Two-factor authentication is off.")
+		fmt.Print("Turn it on? (Y/N): ")
+		confirm, _ := stdin.ReadString('\n')
+		if strings.ToUpper(sanitizeInput(confirm)) == "Y" {
+			enableTwoFactor(router, userID)
+		}
+		return
+	}
+
+	fmt.Printf("Two-factor authentication is on, with %d recovery codes left.\n", status.RecoveryCodesLeft)
+	fmt.Print("Turn it off? (Y/N): ")
+	confirm, _ := stdin.ReadString('\n')
+	if strings.ToUpper(sanitizeInput(confirm)) != "Y" {
+		return
+	}
+	fmt.Print("Enter the code from your authenticator app (or a recovery code): ")
+	code, _ := stdin.ReadString('\n')
+	for {
+		err = limiter.AttemptAs(userID, terminalSource, func() error {
+			return disableTwoFactor(router, userID, sanitizeInput(code))
+		})
+		// Workspaces that require two-factor authentication may be left on the spot
+		var required *WorkspaceTwoFactorError
+		if !errors.As(err, &required) {
+			break
+		}
+		fmt.Printf("Workspace %q requires two-factor authentication. Leave it? (Y/N): ", required.Name)
+		confirm, _ := stdin.ReadString('\n')
+		if strings.ToUpper(sanitizeInput(confirm)) != "Y" {
+			fmt.Println("Two-factor authentication is still on.")
+			return
+		}
+		if err := store.LeaveWorkspace(Actor{UserID: userID, WorkspaceID: required.WorkspaceID}); err != nil {
+			reportWorkspaceError(err)
+			return
+		}
+		fmt.Printf("Left workspace %q.\n", required.Name)
+	}
+	if err != nil {
+		reportTwoFactorError(err)
+		return
+	}
+	fmt.Println("Two-factor authentication turned off.")
+}
+
+// Helper function to walk the user through adding the account to an authenticator app
+func enableTwoFactor(router *dbRouter, userID int) {
+	setup, err := startTwoFactor(router, userID)
+	if err != nil {
+		reportTwoFactorError(err)
+		return
+	}
+	fmt.Println("Add this account to your authenticator app with the secret key")
+	fmt.Printf("  %s\n", setup.Secret)
+	fmt.Println("or by turning this URI into a QR code:")
+	fmt.Printf("  %s\n", setup.URI)
+
+	fmt.Print("Enter the code the app shows to finish: ")
+	code, _ := stdin.ReadString('\n')
+	codes, err := confirmTwoFactor(router, userID, sanitizeInput(code))
+	if err != nil {
+		reportTwoFactorError(err)
+		return
+	}
+	fmt.Println("Two-factor authentication turned on. Keep these recovery codes somewhere safe;")
+	fmt.Println("each logs you in once if you lose your authenticator app:")
+	for _, recoveryCode := range codes {
+		fmt.Printf("  %s\n", recoveryCode)
+	}
+}
+
+// Helper function to explain why a two-factor change was rejected
+func reportTwoFactorError(err error) {
+	if errors.Is(err, ErrForbidden) || err == ErrInvalidSecondFactor || err == ErrTwoFactorEnabled ||
+		err == ErrTwoFactorDisabled || err == ErrNoTwoFactorSetup || errors.Is(err, ErrTooManyAttempts) {
+		fmt.Println("Error:", err)
+		return
+	}
+	log.Println("Error saving two-factor authentication:", err)
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The SHA1 seed of RFC 6238 appendix B, "12345678901234567890", in base32
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B, keeping the last 6 of its 8 digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got, err := totpCode(rfc6238Secret, tt.unix/totpPeriod)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("code at %d = %s; want %s", tt.unix, got, tt.want)
+		}
+	}
+
+	if _, err := totpCode("not base32!", 1); err == nil {
+		t.Error("a secret that isn't base32 gave a code")
+	}
+	lower, err := totpCode("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upper, _ := totpCode(rfc6238Secret, 1); lower != upper {
+		t.Errorf("lower-case secret gave %s; want %s", lower, upper)
+	}
+}
+
+func TestMatchTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := now.Unix() / totpPeriod
+	codeAt := func(step int64) string {
+		code, err := totpCode(rfc6238Secret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	tests := []struct {
+		name     string
+		code     string
+		lastStep int64
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", codeAt(current), 0, current, true},
+		{"previous step, clock drift", codeAt(current - 1), 0, current - 1, true},
+		{"next step, clock drift", codeAt(current + 1), 0, current + 1, true},
+		{"two steps old", codeAt(current - 2), 0, 0, false},
+		{"two steps ahead", codeAt(current + 2), 0, 0, false},
+		{"already used", codeAt(current), current, 0, false},
+		{"older than the last used", codeAt(current - 1), current - 1, 0, false},
+		{"newer than the last used", codeAt(current + 1), current, current + 1, true},
+		{"wrong code", "000000", 0, 0, false},
+	}
+	for _, tt := range tests {
+		step, ok := matchTOTP(rfc6238Secret, tt.code, now, tt.lastStep)
+		if ok != tt.wantOK || step != tt.wantStep {
+			t.Errorf("%s: matchTOTP = %d, %v; want %d, %v", tt.name, step, ok, tt.wantStep, tt.wantOK)
+		}
+	}
+}
+
+func TestNormalizeCode(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{" 123456 ", "123456"},
+		{"123 456", "123456"},
+		{"ABCDE-FGHIJ", "abcdefghij"},
+		{"abcde fghij", "abcdefghij"},
+	}
+	for _, tt := range tests {
+		if got := normalizeCode(tt.input); got != tt.want {
+			t.Errorf("normalizeCode(%q) = %q; want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestTwoFactorLifecycle(t *testing.T) {
+	router := newTestRouter(t)
+	userID, _ := newTestUser(t, router, "carol")
+	current := time.Now().Unix() / totpPeriod
+
+	if err := checkSecondFactor(router, userID, ""); err != nil {
+		t.Fatalf("logging in without two-factor authentication: %v", err)
+	}
+	if _, err := confirmTwoFactor(router, userID, "123456"); err != ErrNoTwoFactorSetup {
+		t.Fatalf("confirming before setting up: %v; want %v", err, ErrNoTwoFactorSetup)
+	}
+	setup, err := startTwoFactor(router, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := totpCode(setup.Secret, current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recoveryCodes, err := confirmTwoFactor(router, userID, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recoveryCodes) != recoveryCodeCount {
+		t.Fatalf("got %d recovery codes; want %d", len(recoveryCodes), recoveryCodeCount)
+	}
+	next, err := totpCode(setup.Secret, current+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name string
+		code string
+		want error
+	}{
+		{"no code", "", ErrSecondFactorRequired},
+		{"code used to confirm", code, ErrInvalidSecondFactor},
+		{"next code", next, nil},
+		{"next code again", next, ErrInvalidSecondFactor},
+		{"recovery code typed in upper case", strings.ToUpper(recoveryCodes[0]), nil},
+		{"spent recovery code", recoveryCodes[0], ErrInvalidSecondFactor},
+		{"another recovery code without its dash", strings.Replace(recoveryCodes[1], "-", "", 1), nil},
+	}
+	for _, step := range steps {
+		if err := checkSecondFactor(router, userID, step.code); err != step.want {
+			t.Fatalf("%s: %v; want %v", step.name, err, step.want)
+		}
+	}
+
+	status, err := twoFactorStatus(router, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Enabled || status.RecoveryCodesLeft != recoveryCodeCount-2 {
+		t.Errorf("status = %+v; want on with %d recovery codes left", status, recoveryCodeCount-2)
+	}
+
+	if err := disableTwoFactor(router, userID, "000000"); err != ErrInvalidSecondFactor {
+		t.Fatalf("turning off with a wrong code: %v; want %v", err, ErrInvalidSecondFactor)
+	}
+	if err := disableTwoFactor(router, userID, recoveryCodes[2]); err != nil {
+		t.Fatal(err)
+	}
+	if status, err := twoFactorStatus(router, userID); err != nil || status.Enabled || status.RecoveryCodesLeft != 0 {
+		t.Errorf("status after turning off = %+v, %v; want off without recovery codes", status, err)
+	}
+}
+
+// Helper function to turn on two-factor authentication for a user, returning
+// their recovery codes
+func enableTestTwoFactor(t *testing.T, router *dbRouter, userID int) []string {
+	t.Helper()
+	setup, err := startTwoFactor(router, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := totpCode(setup.Secret, time.Now().Unix()/totpPeriod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recoveryCodes, err := confirmTwoFactor(router, userID, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return recoveryCodes
+}
+
+func TestWorkspaceRequiresTwoFactor(t *testing.T) {
+	router := newTestRouter(t)
+	store := newSQLTaskStore(router, 0)
+	ownerID, workspaceID := newTestUser(t, router, "carol")
+	daveID, _ := newTestUser(t, router, "dave")
+	owner := Actor{UserID: ownerID, WorkspaceID: workspaceID}
+	dave := Actor{UserID: daveID, WorkspaceID: workspaceID}
+	if err := store.AddMember(owner, "dave", RoleMember); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.SetTwoFactorRequired(owner, true); !errors.Is(err, ErrForbidden) {
+		t.Errorf("requiring two-factor authentication without it = %v; want %v", err, ErrForbidden)
+	}
+	enableTestTwoFactor(t, router, ownerID)
+	if err := store.SetTwoFactorRequired(owner, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Create(dave, Task{Title: "Report"}); !errors.Is(err, ErrForbidden) {
+		t.Errorf("working without two-factor authentication = %v; want %v", err, ErrForbidden)
+	}
+	recoveryCodes := enableTestTwoFactor(t, router, daveID)
+	if _, err := store.Create(dave, Task{Title: "Report"}); err != nil {
+		t.Errorf("working with two-factor authentication: %v", err)
+	}
+
+	// Turning it off is refused, naming the workspace, until the user leaves it
+	err := disableTwoFactor(router, daveID, recoveryCodes[0])
+	var required *WorkspaceTwoFactorError
+	if !errors.As(err, &required) || required.WorkspaceID != workspaceID || !errors.Is(err, ErrForbidden) {
+		t.Fatalf("turning off two-factor authentication = %v; want the workspace %d named", err, workspaceID)
+	}
+	if err := store.LeaveWorkspace(dave); err != nil {
+		t.Fatal(err)
+	}
+	if err := disableTwoFactor(router, daveID, recoveryCodes[0]); err != nil {
+		t.Errorf("turning off two-factor authentication after leaving: %v", err)
+	}
+}
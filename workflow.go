@@ -0,0 +1,325 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// WorkflowStatus is one named status a task can be in
+type WorkflowStatus struct {
+	Name string `json:"name"`
+	Done bool   `json:"done"` // tasks in this status count as complete
+}
+
+// Workflow is a workspace's set of statuses and the moves allowed between them.
+// The first status is the one new tasks start in.
+type Workflow struct {
+	Statuses    []WorkflowStatus    `json:"statuses"`
+	Transitions map[string][]string `json:"transitions"` // from status -> statuses it may move to
+}
+
+// Names of the statuses that replaced the old one-letter codes
+const (
+	statusTodo = "todo" // was 'N'
+	statusDone = "done" // was 'C'
+)
+
+// Function to get the workflow used by workspaces that haven't defined their own
+func defaultWorkflow() Workflow {
+	return Workflow{
+		Statuses: []WorkflowStatus{
+			{Name: statusTodo},
+			{Name: "in-progress"},
+			{Name: "blocked"},
+			{Name: "review"},
+			{Name: statusDone, Done: true},
+		},
+		Transitions: map[string][]string{
+			statusTodo:    {"in-progress", "blocked", statusDone},
+			"in-progress": {statusTodo, "blocked", "review", statusDone},
+			"blocked":     {statusTodo, "in-progress"},
+			"review":      {"in-progress", statusDone},
+			statusDone:    {statusTodo}, // reopen
+		},
+	}
+}
+
+var (
+	// ErrUnknownStatus is returned for a status that isn't part of the workspace's workflow
+	ErrUnknownStatus = errors.New("unknown status")
+	// ErrTransitionNotAllowed is returned when the workflow forbids moving a task to the requested status
+	ErrTransitionNotAllowed = errors.New("status change not allowed by the workflow")
+)
+
+var statusNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,29}$`)
+
+// Function to normalise a status name as typed by a user
+func normalizeStatusName(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// Status returns the named status and whether it exists
+func (w Workflow) Status(name string) (WorkflowStatus, bool) {
+	for _, status := range w.Statuses {
+		if status.Name == name {
+			return status, true
+		}
+	}
+	return WorkflowStatus{}, false
+}
+
+// Initial returns the status new tasks start in
+func (w Workflow) Initial() string {
+	return w.Statuses[0].Name
+}
+
+// IsDone reports whether tasks in the named status count as complete
+func (w Workflow) IsDone(name string) bool {
+	status, _ := w.Status(name)
+	return status.Done
+}
+
+// Names returns the status names in order
+func (w Workflow) Names() []string {
+	names := make([]string, len(w.Statuses))
+	for i, status := range w.Statuses {
+		names[i] = status.Name
+	}
+	return names
+}
+
+// CanTransition reports whether a task may move from one status to another.
+// Staying in the same status is always allowed.
+func (w Workflow) CanTransition(from, to string) bool {
+	if from == to {
+		return true
+	}
+	for _, next := range w.Transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// DoneStatusFrom returns a done status the task can move to from its current status
+func (w Workflow) DoneStatusFrom(from string) (string, bool) {
+	for _, status := range w.Statuses {
+		if status.Done && w.CanTransition(from, status.Name) {
+			return status.Name, true
+		}
+	}
+	return "", false
+}
+
+// CheckStatus checks that a status exists, for tasks being created
+func (w Workflow) CheckStatus(name string) error {
+	if _, ok := w.Status(name); !ok {
+		return &ValidationError{Field: "status", Err: fmt.Errorf("%w %q; expected one of %s", ErrUnknownStatus, name, strings.Join(w.Names(), ", "))}
+	}
+	return nil
+}
+
+// CheckTransition checks that a task may move between two statuses
+func (w Workflow) CheckTransition(from, to string) error {
+	if err := w.CheckStatus(to); err != nil {
+		return err
+	}
+	if !w.CanTransition(from, to) {
+		allowed := w.Transitions[from]
+		if len(allowed) == 0 {
+			return fmt.Errorf("%w: %q is final", ErrTransitionNotAllowed, from)
+		}
+		return fmt.Errorf("%w: %q can only move to %s", ErrTransitionNotAllowed, from, strings.Join(allowed, ", "))
+	}
+	return nil
+}
+
+// Validate checks the workflow is usable: valid unique names, at least one
+// status, at least one done status and transitions only between known statuses
+func (w Workflow) Validate() error {
+	if len(w.Statuses) == 0 {
+		return &ValidationError{Field: "statuses", Err: errors.New("a workflow needs at least one status")}
+	}
+	seen := make(map[string]bool)
+	hasDone := false
+	for _, status := range w.Statuses {
+		if !statusNamePattern.MatchString(status.Name) {
+			return &ValidationError{Field: "statuses", Err: fmt.Errorf("status %q must be 1-30 lowercase letters, digits or dashes", status.Name)}
+		}
+		if seen[status.Name] {
+			return &ValidationError{Field: "statuses", Err: fmt.Errorf("status %q is listed twice", status.Name)}
+		}
+		seen[status.Name] = true
+		hasDone = hasDone || status.Done
+	}
+	if !hasDone {
+		return &ValidationError{Field: "statuses", Err: errors.New("a workflow needs at least one done status")}
+	}
+	for from, targets := range w.Transitions {
+		if !seen[from] {
+			return &ValidationError{Field: "transitions", Err: fmt.Errorf("transition from unknown status %q", from)}
+		}
+		listed := make(map[string]bool, len(targets))
+		for _, to := range targets {
+			if !seen[to] {
+				return &ValidationError{Field: "transitions", Err: fmt.Errorf("transition to unknown status %q", to)}
+			}
+			if listed[to] {
+				return &ValidationError{Field: "transitions", Err: fmt.Errorf("transition from %q to %q is listed twice", from, to)}
+			}
+			listed[to] = true
+		}
+	}
+	return nil
+}
+
+// WorkflowStore reads and replaces a workspace's status workflow. Changing
+// the workflow needs the admin role.
+type WorkflowStore interface {
+	Workflow(actor Actor) (Workflow, error)
+	SaveWorkflow(actor Actor, workflow Workflow) error
+	ResetWorkflow(actor Actor) error
+}
+
+// Workflow returns the workspace's own workflow, or the default one if it has none
+func (s *sqlTaskStore) Workflow(actor Actor) (Workflow, error) {
+	db := s.router.Reader(actor.UserID)
+	if _, err := requireRole(db, actor, RoleViewer); err != nil {
+		return Workflow{}, err
+	}
+	return loadWorkflow(db, actor.WorkspaceID)
+}
+
+// Function to read a workspace's workflow with any query runner (database or transaction)
+func loadWorkflow(db queryer, workspaceID int) (Workflow, error) {
+	rows, err := db.Query(`SELECT name, is_done FROM workflow_status WHERE workspace_id = $1 ORDER BY position`, workspaceID)
+	if err != nil {
+		return Workflow{}, err
+	}
+	var workflow Workflow
+	for rows.Next() {
+		var status WorkflowStatus
+		if err := rows.Scan(&status.Name, &status.Done); err != nil {
+			rows.Close()
+			return Workflow{}, err
+		}
+		workflow.Statuses = append(workflow.Statuses, status)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return Workflow{}, err
+	}
+	if len(workflow.Statuses) == 0 {
+		return defaultWorkflow(), nil
+	}
+
+	rows, err = db.Query(`SELECT from_status, to_status FROM workflow_transition WHERE workspace_id = $1 ORDER BY from_status, to_status`, workspaceID)
+	if err != nil {
+		return Workflow{}, err
+	}
+	defer rows.Close()
+	workflow.Transitions = make(map[string][]string)
+	for rows.Next() {
+		var from, to string
+		if err := rows.Scan(&from, &to); err != nil {
+			return Workflow{}, err
+		}
+		workflow.Transitions[from] = append(workflow.Transitions[from], to)
+	}
+	return workflow, rows.Err()
+}
+
+// SaveWorkflow replaces the workspace's workflow. Statuses that tasks are still
+// in cannot be dropped; move those tasks first.
+func (s *sqlTaskStore) SaveWorkflow(actor Actor, workflow Workflow) error {
+	if err := workflow.Validate(); err != nil {
+		return err
+	}
+	return s.replaceWorkflow(actor, &workflow)
+}
+
+// ResetWorkflow drops the workspace's own workflow so the default applies again
+func (s *sqlTaskStore) ResetWorkflow(actor Actor) error {
+	return s.replaceWorkflow(actor, nil)
+}
+
+// Function to swap the workspace's stored workflow for a new one (nil for the default) in one transaction
+func (s *sqlTaskStore) replaceWorkflow(actor Actor, workflow *Workflow) error {
+	tx, err := s.router.Writer(actor.UserID).Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := requireRole(tx, actor, RoleAdmin); err != nil {
+		return err
+	}
+	effective := defaultWorkflow()
+	if workflow != nil {
+		effective = *workflow
+	}
+	workspaceID := actor.WorkspaceID
+	if err := checkStatusesInUse(tx, workspaceID, effective); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM workflow_transition WHERE workspace_id = $1`, workspaceID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM workflow_status WHERE workspace_id = $1`, workspaceID); err != nil {
+		return err
+	}
+	if workflow == nil {
+		return tx.Commit()
+	}
+
+	for i, status := range workflow.Statuses {
+		query := `INSERT INTO workflow_status (workspace_id, name, position, is_done) VALUES ($1, $2, $3, $4)`
+		if _, err := tx.Exec(query, workspaceID, status.Name, i, status.Done); err != nil {
+			return err
+		}
+	}
+	for from, targets := range workflow.Transitions {
+		for _, to := range targets {
+			if from == to {
+				continue
+			}
+			query := `INSERT INTO workflow_transition (workspace_id, from_status, to_status) VALUES ($1, $2, $3)`
+			if _, err := tx.Exec(query, workspaceID, from, to); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// Function to refuse a workflow that leaves existing tasks in a status it no
+// longer has. Tasks in the trash count too, so they can always be restored.
+func checkStatusesInUse(tx *sql.Tx, workspaceID int, workflow Workflow) error {
+	rows, err := tx.Query(`SELECT DISTINCT status FROM "task" WHERE workspace_id = $1`, workspaceID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var missing []string
+	for rows.Next() {
+		var status string
+		if err := rows.Scan(&status); err != nil {
+			return err
+		}
+		if _, ok := workflow.Status(status); !ok {
+			missing = append(missing, status)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return &ValidationError{Field: "statuses", Err: fmt.Errorf("tasks are still in %s, counting the trash; move them to another status first", strings.Join(missing, ", "))}
+	}
+	return nil
+}
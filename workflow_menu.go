@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// Status workflow menu
+func workflowMenu(store WorkflowStore, actor Actor) {
+	for {
+		workflow, err := store.Workflow(actor)
+		if err != nil {
+			log.Println("Error loading workflow:", err)
+			return
+		}
+		printWorkflow(workflow)
+
+		fmt.Println("\nStatus Menu:")
+		fmt.Println("---------------------------------")
+		fmt.Println("1 - Add Status")
+		fmt.Println("2 - Remove Status")
+		fmt.Println("3 - Allow Status Change")
+		fmt.Println("4 - Forbid Status Change")
+		fmt.Println("5 - Reset to Default Statuses")
+		fmt.Println("6 - Back")
+
+		fmt.Print("Enter your choice: ")
+		choiceInput, _ := stdin.ReadString('\n')
+		choice, err := strconv.Atoi(sanitizeInput(choiceInput))
+		if err != nil {
+			log.Println("Invalid input. Please enter a number.")
+			continue
+		}
+
+		switch choice {
+		case 1:
+			name := promptStatusName("Enter new status name: ")
+			fmt.Print("Does this status mean the task is complete? (y/N): ")
+			doneInput, _ := stdin.ReadString('\n')
+			workflow.Statuses = append(workflow.Statuses, WorkflowStatus{
+				Name: name,
+				Done: strings.EqualFold(sanitizeInput(doneInput), "y"),
+			})
+		case 2:
+			name := promptStatusName("Enter status to remove: ")
+			if !workflow.removeStatus(name) {
+				fmt.Println("No such status.")
+				continue
+			}
+		case 3:
+			from := promptStatusName("Change from status: ")
+			to := promptStatusName("Change to status: ")
+			workflow.allow(from, to)
+		case 4:
+			from := promptStatusName("Change from status: ")
+			to := promptStatusName("Change to status: ")
+			if !workflow.forbid(from, to) {
+				fmt.Println("That change isn't allowed now anyway.")
+				continue
+			}
+		case 5:
+			if err := store.ResetWorkflow(actor); err != nil {
+				reportWorkflowError(err)
+			} else {
+				fmt.Println("Statuses reset to the defaults.")
+			}
+			continue
+		case 6:
+			return
+		default:
+			fmt.Println("Invalid choice. Please try again.")
+			continue
+		}
+
+		if err := store.SaveWorkflow(actor, workflow); err != nil {
+			reportWorkflowError(err)
+			continue
+		}
+		fmt.Println("Statuses updated successfully!")
+	}
+}
+
+// Helper function to print a workflow's statuses and allowed changes
+func printWorkflow(workflow Workflow) {
+	fmt.Println("---------------------------------")
+	fmt.Println("YOUR STATUSES:")
+	for _, status := range workflow.Statuses {
+		done := ""
+		if status.Done {
+			done = " (complete)"
+		}
+		next := "-"
+		if targets := workflow.Transitions[status.Name]; len(targets) > 0 {
+			next = strings.Join(targets, ", ")
+		}
+		fmt.Printf(" %s%s -> %s\n", status.Name, done, next)
+	}
+}
+
+// Helper function to ask for a status name
+func promptStatusName(prompt string) string {
+	fmt.Print(prompt)
+	name, _ := stdin.ReadString('\n')
+	return normalizeStatusName(name)
+}
+
+// Helper function to explain why a workflow change was refused
+func reportWorkflowError(err error) {
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		fmt.Println("Error:", validationErr.Err)
+		return
+	} else if errors.Is(err, ErrForbidden) {
+		fmt.Println("Error:", err)
+		return
+	}
+	log.Println("Error saving statuses:", err)
+}
+
+// Function to drop a status and every transition that mentions it
+func (w *Workflow) removeStatus(name string) bool {
+	for i, status := range w.Statuses {
+		if status.Name != name {
+			continue
+		}
+		w.Statuses = append(w.Statuses[:i:i], w.Statuses[i+1:]...)
+		delete(w.Transitions, name)
+		for from := range w.Transitions {
+			w.forbid(from, name)
+		}
+		return true
+	}
+	return false
+}
+
+// Function to allow moving from one status to another
+func (w *Workflow) allow(from, to string) {
+	if w.CanTransition(from, to) {
+		return
+	}
+	if w.Transitions == nil {
+		w.Transitions = make(map[string][]string)
+	}
+	w.Transitions[from] = append(w.Transitions[from], to)
+}
+
+// Function to stop allowing a move from one status to another
+func (w *Workflow) forbid(from, to string) bool {
+	targets := w.Transitions[from]
+	for i, next := range targets {
+		if next == to {
+			w.Transitions[from] = append(targets[:i:i], targets[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
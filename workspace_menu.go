@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// Workspace menu
+func workspaceMenu(store WorkspaceStore, sessions *sessionStore, token string, userID int) {
+	for {
+		// Switching, leaving or deleting moves the session to another workspace
+		actor, err := sessions.Actor(token, userID)
+		if err != nil {
+			log.Println("Error loading workspace:", err)
+			return
+		}
+		workspaces, err := store.Workspaces(actor.UserID)
+		if err != nil {
+			log.Println("Error loading workspaces:", err)
+			return
+		}
+		// A workspace that requires two-factor authentication can still be left or switched away from
+		members, err := store.Members(actor)
+		if errors.Is(err, ErrForbidden) {
+			fmt.Println("Error:", err)
+		} else if err != nil {
+			log.Println("Error loading members:", err)
+			return
+		}
+		printWorkspaces(workspaces, actor.WorkspaceID)
+		printMembers(members)
+
+		fmt.Println("\nWorkspace Menu:")
+		fmt.Println("---------------------------------")
+		fmt.Println("1 - Switch Workspace")
+		fmt.Println("2 - Create Workspace")
+		fmt.Println("3 - Add Member")
+		fmt.Println("4 - Change Member Role")
+		fmt.Println("5 - Remove Member")
+		fmt.Println("6 - Rename Workspace")
+		fmt.Println("7 - Leave Workspace")
+		fmt.Println("8 - Delete Workspace")
+		fmt.Println("9 - Require Two-Factor Authentication")
+		fmt.Println("10 - Back")
+
+		fmt.Print("Enter your choice: ")
+		choiceInput, _ := stdin.ReadString('\n')
+		choice, err := strconv.Atoi(sanitizeInput(choiceInput))
+		if err != nil {
+			log.Println("Invalid input. Please enter a number.")
+			continue
+		}
+
+		switch choice {
+		case 1:
+			fmt.Print("Enter workspace ID: ")
+			idInput, _ := stdin.ReadString('\n')
+			workspaceID, _ := strconv.Atoi(sanitizeInput(idInput))
+			err = sessions.UseWorkspace(token, Actor{UserID: actor.UserID, WorkspaceID: workspaceID})
+		case 2:
+			fmt.Print("Enter workspace name: ")
+			name, _ := stdin.ReadString('\n')
+			var workspace Workspace
+			if workspace, err = store.CreateWorkspace(actor.UserID, name); err == nil {
+				err = sessions.UseWorkspace(token, Actor{UserID: actor.UserID, WorkspaceID: workspace.ID})
+			}
+		case 3:
+			fmt.Print("Enter username to add: ")
+			username, _ := stdin.ReadString('\n')
+			var role Role
+			if role, err = readRole(); err == nil {
+				err = store.AddMember(actor, sanitizeInput(username), role)
+			}
+		case 4:
+			fmt.Print("Enter username: ")
+			username, _ := stdin.ReadString('\n')
+			var role Role
+			if role, err = readRole(); err == nil {
+				err = store.SetMemberRole(actor, sanitizeInput(username), role)
+			}
+		case 5:
+			fmt.Print("Enter username to remove: ")
+			username, _ := stdin.ReadString('\n')
+			err = store.RemoveMember(actor, sanitizeInput(username))
+		case 6:
+			fmt.Print("Enter new workspace name: ")
+			name, _ := stdin.ReadString('\n')
+			err = store.RenameWorkspace(actor, name)
+		case 7:
+			err = store.LeaveWorkspace(actor)
+		case 8:
+			fmt.Print("Delete this workspace and all its tasks? (Y/N): ")
+			confirm, _ := stdin.ReadString('\n')
+			if strings.ToUpper(sanitizeInput(confirm)) != "Y" {
+				fmt.Println("Workspace not deleted.")
+				continue
+			}
+			err = store.DeleteWorkspace(actor)
+		case 9:
+			fmt.Print("Require two-factor authentication from every member? (Y/N): ")
+			confirm, _ := stdin.ReadString('\n')
+			err = store.SetTwoFactorRequired(actor, strings.ToUpper(sanitizeInput(confirm)) == "Y")
+		case 10:
+			return
+		default:
+			fmt.Println("Invalid choice. Please try again.")
+			continue
+		}
+
+		if err != nil {
+			reportWorkspaceError(err)
+			continue
+		}
+		fmt.Println("Workspaces updated successfully!")
+	}
+}
+
+// Helper function to ask for a member's role
+func readRole() (Role, error) {
+	fmt.Print("Enter role (viewer, member, admin or owner): ")
+	input, _ := stdin.ReadString('\n')
+	return ParseRole(sanitizeInput(input))
+}
+
+// Helper function to show which workspace the menu is working in
+func printCurrentWorkspace(store WorkspaceStore, actor Actor) {
+	workspaces, err := store.Workspaces(actor.UserID)
+	if err != nil {
+		log.Println("Error loading workspaces:", err)
+		return
+	}
+	for _, workspace := range workspaces {
+		if workspace.ID == actor.WorkspaceID {
+			fmt.Printf("Workspace: %s (%s)\n", workspace.Name, workspace.Role)
+		}
+	}
+}
+
+// Helper function to print the user's workspaces, marking the current one
+func printWorkspaces(workspaces []Workspace, currentID int) {
+	fmt.Println("---------------------------------")
+	fmt.Println("YOUR WORKSPACES:")
+	for _, workspace := range workspaces {
+		marker := " "
+		if workspace.ID == currentID {
+			marker = "*"
+		}
+		twoFactor := ""
+		if workspace.RequireTwoFactor {
+			twoFactor = ", 2FA required"
+		}
+		fmt.Printf("%s %d: %s (%s%s)\n", marker, workspace.ID, workspace.Name, workspace.Role, twoFactor)
+	}
+}
+
+// Helper function to print the members of the current workspace
+func printMembers(members []Member) {
+	fmt.Println("MEMBERS:")
+	for _, member := range members {
+		fmt.Printf(" %s (%s)\n", member.Username, member.Role)
+	}
+}
+
+// Helper function to explain why a workspace change was rejected
+func reportWorkspaceError(err error) {
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) || errors.Is(err, ErrForbidden) || errors.Is(err, ErrWorkspaceNotFound) ||
+		errors.Is(err, ErrMemberNotFound) || err == ErrAlreadyMember || err == ErrLastOwner {
+		fmt.Println("Error:", err)
+		return
+	}
+	log.Println("Error saving workspaces:", err)
+}
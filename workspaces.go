@@ -0,0 +1,423 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Workspace holds tasks, statuses, tags and projects shared by its members
+type Workspace struct {
+	ID               int    `json:"id"`
+	Name             string `json:"name"`
+	Role             Role   `json:"role"`               // the user's own role in the workspace
+	RequireTwoFactor bool   `json:"require_two_factor"` // members must use two-factor authentication to work in it
+}
+
+// Member is a user who belongs to a workspace
+type Member struct {
+	UserID   int    `json:"user_id"`
+	Username string `json:"username"`
+	Role     Role   `json:"role"`
+}
+
+// Actor is a user working in one of their workspaces. Task store calls are
+// made on behalf of an actor and only see the actor's workspace.
+type Actor struct {
+	UserID      int
+	WorkspaceID int
+}
+
+// Role says what a member may do in a workspace
+type Role string
+
+const (
+	RoleViewer Role = "viewer" // read tasks, statuses, tags and projects
+	RoleMember Role = "member" // also create and update tasks, and delete the tasks they created
+	RoleAdmin  Role = "admin"  // also delete any task and manage the workflow, tags, projects and non-owner members
+	RoleOwner  Role = "owner"  // also manage owners, and rename or delete the workspace
+)
+
+// Roles from least to most allowed
+var roles = []Role{RoleViewer, RoleMember, RoleAdmin, RoleOwner}
+
+var (
+	// ErrWorkspaceNotFound is returned for a workspace that doesn't exist or that the user isn't a member of
+	ErrWorkspaceNotFound = errors.New("workspace not found")
+	// ErrForbidden is returned when the user's role in the workspace doesn't allow a change
+	ErrForbidden = errors.New("permission denied")
+	// ErrMemberNotFound is returned for a username that isn't a member of the workspace
+	ErrMemberNotFound = errors.New("member not found")
+	// ErrAlreadyMember is returned when adding a user who is already a member
+	ErrAlreadyMember = errors.New("user is already a member of the workspace")
+	// ErrLastOwner is returned when removing or demoting a workspace's only owner
+	ErrLastOwner = errors.New("a workspace needs at least one owner; make someone else owner first")
+)
+
+// WorkspaceStore manages workspaces and who belongs to them
+type WorkspaceStore interface {
+	Workspaces(userID int) ([]Workspace, error)
+	CreateWorkspace(userID int, name string) (Workspace, error)
+	RenameWorkspace(actor Actor, name string) error
+	DeleteWorkspace(actor Actor) error
+	Members(actor Actor) ([]Member, error)
+	AddMember(actor Actor, username string, role Role) error
+	SetMemberRole(actor Actor, username string, role Role) error
+	RemoveMember(actor Actor, username string) error
+	LeaveWorkspace(actor Actor) error
+	SetTwoFactorRequired(actor Actor, required bool) error
+	DeleteAccount(userID int, password string) error
+}
+
+// ParseRole parses a role name such as "member"
+func ParseRole(input string) (Role, error) {
+	input = strings.ToLower(strings.TrimSpace(input))
+	for _, role := range roles {
+		if string(role) == input {
+			return role, nil
+		}
+	}
+	return "", &ValidationError{Field: "role", Err: fmt.Errorf("unknown role %q; expected viewer, member, admin or owner", input)}
+}
+
+// AtLeast reports whether the role allows everything min allows
+func (r Role) AtLeast(min Role) bool {
+	return r.rank() >= min.rank()
+}
+
+// Helper function to get a role's position in roles; -1 for an unknown role
+func (r Role) rank() int {
+	for i, role := range roles {
+		if role == r {
+			return i
+		}
+	}
+	return -1
+}
+
+// Function to check a workspace name as typed by a user
+func normalizeWorkspaceName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	switch {
+	case name == "":
+		return "", &ValidationError{Field: "name", Err: errors.New("workspace name must not be empty")}
+	case len(name) > 50:
+		return "", &ValidationError{Field: "name", Err: errors.New("workspace name must be at most 50 characters long")}
+	}
+	return name, nil
+}
+
+// Function to get the actor's role in their workspace. Users outside the
+// workspace get ErrWorkspaceNotFound, so they can't tell whether it exists.
+func actorRole(db queryer, actor Actor) (Role, error) {
+	var role Role
+	query := `SELECT role FROM workspace_member WHERE workspace_id = $1 AND user_id = $2`
+	err := db.QueryRow(query, actor.WorkspaceID, actor.UserID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", ErrWorkspaceNotFound
+	}
+	return role, err
+}
+
+// Function to check that the actor's role in their workspace allows what min
+// allows, and that they meet the workspace's two-factor requirement
+func requireRole(db queryer, actor Actor, min Role) (Role, error) {
+	role, err := actorRole(db, actor)
+	if err != nil {
+		return "", err
+	}
+	if !role.AtLeast(min) {
+		return "", fmt.Errorf("%w: needs the %s role or higher, but you are %s", ErrForbidden, min, role)
+	}
+	if err := checkTwoFactorRequirement(db, actor); err != nil {
+		return "", err
+	}
+	return role, nil
+}
+
+// Function to create a workspace with the user as its owner
+func createWorkspace(db queryer, userID int, name string) (int, error) {
+	var workspaceID int
+	query := `INSERT INTO workspace (name, created_by) VALUES ($1, $2) RETURNING workspace_id`
+	if err := db.QueryRow(query, name, userID).Scan(&workspaceID); err != nil {
+		return 0, err
+	}
+	query = `INSERT INTO workspace_member (workspace_id, user_id, role) VALUES ($1, $2, $3)`
+	_, err := db.Exec(query, workspaceID, userID, RoleOwner)
+	return workspaceID, err
+}
+
+// Function to get the workspace a user works in unless they picked another:
+// the first one they joined. A user who left every workspace gets a new one
+// named after them.
+func defaultWorkspace(db queryer, userID int) (int, error) {
+	var workspaceID sql.NullInt64
+	err := db.QueryRow(`SELECT MIN(workspace_id) FROM workspace_member WHERE user_id = $1`, userID).Scan(&workspaceID)
+	if err != nil || workspaceID.Valid {
+		return int(workspaceID.Int64), err
+	}
+	var username string
+	if err := db.QueryRow(`SELECT username FROM "user" WHERE user_id = $1`, userID).Scan(&username); err != nil {
+		return 0, err
+	}
+	return createWorkspace(db, userID, username)
+}
+
+// Function to look up a member of the actor's workspace by username
+func findMember(db queryer, actor Actor, username string) (Member, error) {
+	member := Member{Username: strings.TrimSpace(username)}
+	query := `
+	SELECT m.user_id, m.role FROM workspace_member m JOIN "user" u ON u.user_id = m.user_id
+	WHERE m.workspace_id = $1 AND u.username = $2`
+	err := db.QueryRow(query, actor.WorkspaceID, member.Username).Scan(&member.UserID, &member.Role)
+	if err == sql.ErrNoRows {
+		return Member{}, fmt.Errorf("%w: %q", ErrMemberNotFound, member.Username)
+	}
+	return member, err
+}
+
+// Function to refuse leaving the workspace without an owner when an owner
+// is removed or demoted
+func checkOtherOwners(db queryer, workspaceID int) error {
+	var owners int
+	query := `SELECT COUNT(*) FROM workspace_member WHERE workspace_id = $1 AND role = $2`
+	if err := db.QueryRow(query, workspaceID, RoleOwner).Scan(&owners); err != nil {
+		return err
+	}
+	if owners < 2 {
+		return ErrLastOwner
+	}
+	return nil
+}
+
+// Workspaces returns every workspace the user belongs to, with their role in it
+func (s *sqlTaskStore) Workspaces(userID int) ([]Workspace, error) {
+	query := `
+	SELECT w.workspace_id, w.name, m.role, w.require_two_factor FROM workspace w
+	JOIN workspace_member m ON m.workspace_id = w.workspace_id
+	WHERE m.user_id = $1 ORDER BY w.workspace_id`
+	rows, err := s.router.Reader(userID).Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var workspaces []Workspace
+	for rows.Next() {
+		var workspace Workspace
+		if err := rows.Scan(&workspace.ID, &workspace.Name, &workspace.Role, &workspace.RequireTwoFactor); err != nil {
+			return nil, err
+		}
+		workspaces = append(workspaces, workspace)
+	}
+	return workspaces, rows.Err()
+}
+
+// CreateWorkspace adds an empty workspace owned by the user
+func (s *sqlTaskStore) CreateWorkspace(userID int, name string) (Workspace, error) {
+	name, err := normalizeWorkspaceName(name)
+	if err != nil {
+		return Workspace{}, err
+	}
+
+	tx, err := s.router.Writer(userID).Begin()
+	if err != nil {
+		return Workspace{}, err
+	}
+	defer tx.Rollback()
+
+	workspace := Workspace{Name: name, Role: RoleOwner}
+	if workspace.ID, err = createWorkspace(tx, userID, name); err != nil {
+		return Workspace{}, err
+	}
+	return workspace, tx.Commit()
+}
+
+// RenameWorkspace changes the name of the actor's workspace
+func (s *sqlTaskStore) RenameWorkspace(actor Actor, name string) error {
+	name, err := normalizeWorkspaceName(name)
+	if err != nil {
+		return err
+	}
+	db := s.router.Writer(actor.UserID)
+	if _, err := requireRole(db, actor, RoleOwner); err != nil {
+		return err
+	}
+	_, err = db.Exec(`UPDATE workspace SET name = $1 WHERE workspace_id = $2`, name, actor.WorkspaceID)
+	return err
+}
+
+// DeleteWorkspace deletes the actor's workspace with everything in it
+func (s *sqlTaskStore) DeleteWorkspace(actor Actor) error {
+	db := s.router.Writer(actor.UserID)
+	if _, err := requireRole(db, actor, RoleOwner); err != nil {
+		return err
+	}
+	// The workspace_id foreign keys take its tasks, statuses, tags, projects and members with it
+	_, err := db.Exec(`DELETE FROM workspace WHERE workspace_id = $1`, actor.WorkspaceID)
+	return err
+}
+
+// Members returns everyone in the actor's workspace, owners first
+func (s *sqlTaskStore) Members(actor Actor) ([]Member, error) {
+	db := s.router.Reader(actor.UserID)
+	if _, err := requireRole(db, actor, RoleViewer); err != nil {
+		return nil, err
+	}
+	query := `
+	SELECT m.user_id, u.username, m.role FROM workspace_member m JOIN "user" u ON u.user_id = m.user_id
+	WHERE m.workspace_id = $1 ORDER BY u.username`
+	rows, err := db.Query(query, actor.WorkspaceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var members []Member
+	for rows.Next() {
+		var member Member
+		if err := rows.Scan(&member.UserID, &member.Username, &member.Role); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(members, func(i, j int) bool {
+		return members[i].Role.rank() > members[j].Role.rank()
+	})
+	return members, nil
+}
+
+// AddMember lets a user into the actor's workspace. Admins add members up to
+// admin; only owners make other owners.
+func (s *sqlTaskStore) AddMember(actor Actor, username string, role Role) error {
+	if role.rank() < 0 {
+		return &ValidationError{Field: "role", Err: fmt.Errorf("unknown role %q", role)}
+	}
+
+	tx, err := s.router.Writer(actor.UserID).Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	own, err := requireRole(tx, actor, RoleAdmin)
+	if err != nil {
+		return err
+	}
+	if role == RoleOwner && own != RoleOwner {
+		return fmt.Errorf("%w: only owners can add owners", ErrForbidden)
+	}
+	if _, err := findMember(tx, actor, username); err == nil {
+		return ErrAlreadyMember
+	} else if !errors.Is(err, ErrMemberNotFound) {
+		return err
+	}
+	var userID int
+	username = strings.TrimSpace(username)
+	err = tx.QueryRow(`SELECT user_id FROM "user" WHERE username = $1`, username).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return &ValidationError{Field: "username", Err: fmt.Errorf("no user named %q", username)}
+	} else if err != nil {
+		return err
+	}
+	query := `INSERT INTO workspace_member (workspace_id, user_id, role) VALUES ($1, $2, $3)`
+	if _, err := tx.Exec(query, actor.WorkspaceID, userID, role); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetMemberRole changes a member's role. Admins manage everyone but owners;
+// only owners make or unmake owners, and the last owner stays owner.
+func (s *sqlTaskStore) SetMemberRole(actor Actor, username string, role Role) error {
+	if role.rank() < 0 {
+		return &ValidationError{Field: "role", Err: fmt.Errorf("unknown role %q", role)}
+	}
+
+	tx, err := s.router.Writer(actor.UserID).Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	own, err := requireRole(tx, actor, RoleAdmin)
+	if err != nil {
+		return err
+	}
+	member, err := findMember(tx, actor, username)
+	if err != nil {
+		return err
+	}
+	if (member.Role == RoleOwner || role == RoleOwner) && own != RoleOwner {
+		return fmt.Errorf("%w: only owners can make or unmake owners", ErrForbidden)
+	}
+	if member.Role == RoleOwner && role != RoleOwner {
+		if err := checkOtherOwners(tx, actor.WorkspaceID); err != nil {
+			return err
+		}
+	}
+	query := `UPDATE workspace_member SET role = $1 WHERE workspace_id = $2 AND user_id = $3`
+	if _, err := tx.Exec(query, role, actor.WorkspaceID, member.UserID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RemoveMember takes a user out of the actor's workspace. The tasks they
+// created stay; the ones assigned to them are unassigned. Anyone may remove
+// themselves to leave the workspace, unless they are its last owner; removing
+// others follows the same rules as SetMemberRole.
+func (s *sqlTaskStore) RemoveMember(actor Actor, username string) error {
+	tx, err := s.router.Writer(actor.UserID).Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	member, err := findMember(tx, actor, username)
+	if err != nil {
+		return err
+	}
+	if member.UserID != actor.UserID {
+		own, err := requireRole(tx, actor, RoleAdmin)
+		if err != nil {
+			return err
+		}
+		if member.Role == RoleOwner && own != RoleOwner {
+			return fmt.Errorf("%w: only owners can remove owners", ErrForbidden)
+		}
+	}
+	if member.Role == RoleOwner {
+		if err := checkOtherOwners(tx, actor.WorkspaceID); err != nil {
+			return err
+		}
+	}
+	before, err := s.snapshotInvolving(tx, actor.WorkspaceID, member.UserID)
+	if err != nil {
+		return err
+	}
+	if err := unassignMember(tx, actor, member.UserID); err != nil {
+		return err
+	}
+	if err := s.recordChanges(tx, actor, before); err != nil {
+		return err
+	}
+	query := `DELETE FROM workspace_member WHERE workspace_id = $1 AND user_id = $2`
+	if _, err := tx.Exec(query, actor.WorkspaceID, member.UserID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// LeaveWorkspace takes the actor out of their workspace, unless they are its last owner
+func (s *sqlTaskStore) LeaveWorkspace(actor Actor) error {
+	var username string
+	err := s.router.Writer(actor.UserID).QueryRow(`SELECT username FROM "user" WHERE user_id = $1`, actor.UserID).Scan(&username)
+	if err != nil {
+		return err
+	}
+	return s.RemoveMember(actor, username)
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRoleAtLeast(t *testing.T) {
+	for i, role := range roles {
+		for j, min := range roles {
+			if got, want := role.AtLeast(min), i >= j; got != want {
+				t.Errorf("%s.AtLeast(%s) = %v; want %v", role, min, got, want)
+			}
+		}
+	}
+	if Role("guest").AtLeast(RoleViewer) {
+		t.Error("an unknown role counts as a viewer")
+	}
+}
+
+func TestParseRole(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Role
+		wantErr bool
+	}{
+		{"viewer", RoleViewer, false},
+		{" Admin ", RoleAdmin, false},
+		{"OWNER", RoleOwner, false},
+		{"guest", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		got, err := ParseRole(tt.input)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("ParseRole(%q) = %q, %v; want %q, error %v", tt.input, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestRolePermissions(t *testing.T) {
+	router := newTestRouter(t)
+	store := newSQLTaskStore(router, 0)
+	ownerID, workspaceID := newTestUser(t, router, "carol")
+	owner := Actor{UserID: ownerID, WorkspaceID: workspaceID}
+
+	// One member of the workspace in each role, and a user outside it
+	actors := make(map[Role]Actor)
+	for _, role := range roles {
+		username := "a" + string(role)
+		userID, _ := newTestUser(t, router, username)
+		if err := store.AddMember(owner, username, role); err != nil {
+			t.Fatal(err)
+		}
+		actors[role] = Actor{UserID: userID, WorkspaceID: workspaceID}
+	}
+	outsiderID, _ := newTestUser(t, router, "eve")
+	outsider := Actor{UserID: outsiderID, WorkspaceID: workspaceID}
+
+	newUsers := 0
+	newUser := func() string {
+		newUsers++
+		username := fmt.Sprintf("newcomer%d", newUsers)
+		newTestUser(t, router, username)
+		return username
+	}
+	ownerTask := func() int {
+		task, err := store.Create(owner, Task{Title: "Owner's task"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return task.ID
+	}
+
+	actions := []struct {
+		name string
+		min  Role
+		run  func(actor Actor) error
+	}{
+		{"list tasks", RoleViewer, func(actor Actor) error {
+			_, err := store.List(actor, TaskFilter{})
+			return err
+		}},
+		{"list members", RoleViewer, func(actor Actor) error {
+			_, err := store.Members(actor)
+			return err
+		}},
+		{"create a task", RoleMember, func(actor Actor) error {
+			_, err := store.Create(actor, Task{Title: "New task"})
+			return err
+		}},
+		{"delete someone else's task", RoleAdmin, func(actor Actor) error {
+			return store.Delete(actor, ownerTask(), SubtasksRefuse)
+		}},
+		{"create a project", RoleAdmin, func(actor Actor) error {
+			_, err := store.CreateProject(actor, fmt.Sprintf("Project %d", actor.UserID))
+			return err
+		}},
+		{"add a member", RoleAdmin, func(actor Actor) error {
+			return store.AddMember(actor, newUser(), RoleMember)
+		}},
+		{"add an owner", RoleOwner, func(actor Actor) error {
+			return store.AddMember(actor, newUser(), RoleOwner)
+		}},
+		{"rename the workspace", RoleOwner, func(actor Actor) error {
+			return store.RenameWorkspace(actor, "Team")
+		}},
+	}
+	for _, action := range actions {
+		for _, role := range roles {
+			err := action.run(actors[role])
+			switch {
+			case role.AtLeast(action.min) && err != nil:
+				t.Errorf("%s as %s: %v; want it allowed", action.name, role, err)
+			case !role.AtLeast(action.min) && !errors.Is(err, ErrForbidden):
+				t.Errorf("%s as %s: %v; want %v", action.name, role, err, ErrForbidden)
+			}
+		}
+		if err := action.run(outsider); !errors.Is(err, ErrWorkspaceNotFound) {
+			t.Errorf("%s from outside the workspace: %v; want %v", action.name, err, ErrWorkspaceNotFound)
+		}
+	}
+}
+
+func TestMembersOnlyDeleteTheirOwnTasks(t *testing.T) {
+	router := newTestRouter(t)
+	store := newSQLTaskStore(router, 0)
+	ownerID, workspaceID := newTestUser(t, router, "carol")
+	memberID, _ := newTestUser(t, router, "dave")
+	owner := Actor{UserID: ownerID, WorkspaceID: workspaceID}
+	member := Actor{UserID: memberID, WorkspaceID: workspaceID}
+	if err := store.AddMember(owner, "dave", RoleMember); err != nil {
+		t.Fatal(err)
+	}
+
+	own, err := store.Create(member, Task{Title: "Dave's task"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	others, err := store.Create(owner, Task{Title: "Carol's task"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(member, others.ID, SubtasksRefuse); !errors.Is(err, ErrForbidden) {
+		t.Errorf("deleting someone else's task: %v; want %v", err, ErrForbidden)
+	}
+	if err := store.Delete(member, own.ID, SubtasksRefuse); err != nil {
+		t.Errorf("deleting their own task: %v", err)
+	}
+}
+
+func TestOwnerRules(t *testing.T) {
+	router := newTestRouter(t)
+	store := newSQLTaskStore(router, 0)
+	ownerID, workspaceID := newTestUser(t, router, "carol")
+	adminID, _ := newTestUser(t, router, "ada")
+	newTestUser(t, router, "oscar")
+	owner := Actor{UserID: ownerID, WorkspaceID: workspaceID}
+	admin := Actor{UserID: adminID, WorkspaceID: workspaceID}
+
+	steps := []struct {
+		name string
+		run  func() error
+		want error
+	}{
+		{"the last owner leaves", func() error { return store.LeaveWorkspace(owner) }, ErrLastOwner},
+		{"the last owner steps down", func() error { return store.SetMemberRole(owner, "carol", RoleAdmin) }, ErrLastOwner},
+		{"add an admin", func() error { return store.AddMember(owner, "ada", RoleAdmin) }, nil},
+		{"add them again", func() error { return store.AddMember(owner, "ada", RoleMember) }, ErrAlreadyMember},
+		{"an admin makes themselves owner", func() error { return store.SetMemberRole(admin, "ada", RoleOwner) }, ErrForbidden},
+		{"an admin demotes the owner", func() error { return store.SetMemberRole(admin, "carol", RoleMember) }, ErrForbidden},
+		{"an admin removes the owner", func() error { return store.RemoveMember(admin, "carol") }, ErrForbidden},
+		{"an admin adds a member", func() error { return store.AddMember(admin, "oscar", RoleMember) }, nil},
+		{"an admin promotes them to admin", func() error { return store.SetMemberRole(admin, "oscar", RoleAdmin) }, nil},
+		{"the owner makes a second owner", func() error { return store.SetMemberRole(owner, "oscar", RoleOwner) }, nil},
+		{"an admin removes that owner", func() error { return store.RemoveMember(admin, "oscar") }, ErrForbidden},
+		{"the first owner leaves", func() error { return store.LeaveWorkspace(owner) }, nil},
+		{"the admin leaves", func() error { return store.LeaveWorkspace(admin) }, nil},
+		{"the admin is gone", func() error { _, err := store.Members(admin); return err }, ErrWorkspaceNotFound},
+	}
+	for _, step := range steps {
+		if err := step.run(); !errors.Is(err, step.want) {
+			t.Fatalf("%s: %v; want %v", step.name, err, step.want)
+		}
+	}
+}
+
+func TestSessionWorkspace(t *testing.T) {
+	router := newTestRouter(t)
+	store := newSQLTaskStore(router, 0)
+	sessions := newSessionStore(router, time.Hour)
+	userID, homeID := newTestUser(t, router, "alice")
+	_, othersID := newTestUser(t, router, "bob")
+
+	authToken, err := sessions.Create(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actor, err := sessions.Actor(authToken.Token, userID); err != nil || actor.WorkspaceID != homeID {
+		t.Errorf("a new session works in workspace %d (%v); want the user's own %d", actor.WorkspaceID, err, homeID)
+	}
+	if err := sessions.UseWorkspace(authToken.Token, Actor{UserID: userID, WorkspaceID: othersID}); !errors.Is(err, ErrWorkspaceNotFound) {
+		t.Errorf("switching to someone else's workspace = %v; want %v", err, ErrWorkspaceNotFound)
+	}
+
+	team, err := store.CreateWorkspace(userID, "Team")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sessions.UseWorkspace(authToken.Token, Actor{UserID: userID, WorkspaceID: team.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if actor, err := sessions.Actor(authToken.Token, userID); err != nil || actor.WorkspaceID != team.ID {
+		t.Errorf("after switching the session works in workspace %d (%v); want %d", actor.WorkspaceID, err, team.ID)
+	}
+
+	// Once the workspace is gone the session falls back to the user's first one
+	if err := store.DeleteWorkspace(Actor{UserID: userID, WorkspaceID: team.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if actor, err := sessions.Actor(authToken.Token, userID); err != nil || actor.WorkspaceID != homeID {
+		t.Errorf("after deleting the workspace the session works in %d (%v); want %d", actor.WorkspaceID, err, homeID)
+	}
+}